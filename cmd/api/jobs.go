@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"psycho-platform/internal/config"
+	"psycho-platform/internal/counters"
+	"psycho-platform/internal/handlers"
+	"psycho-platform/internal/jobs"
+	"psycho-platform/internal/presence"
+	"time"
+)
+
+// registerJobs wires the built-in background jobs and their schedules.
+func registerJobs(runner *jobs.Runner, queue *jobs.Queue, db *sql.DB, messages *handlers.MessageHandler, notifications *handlers.NotificationHandler, previewer *handlers.LinkPreviewer, presenceNotifier *presence.Notifier, cfg *config.Config) error {
+	reconciler := counters.NewReconciler(db)
+	runner.Register("counters.reconcile", func(ctx context.Context, _ json.RawMessage) error {
+		_, err := reconciler.Reconcile(ctx)
+		return err
+	})
+
+	runner.Register("presence.reconcile", func(ctx context.Context, _ json.RawMessage) error {
+		return presenceNotifier.Reconcile(ctx)
+	})
+
+	runner.Register("invitations.cleanup", func(ctx context.Context, _ json.RawMessage) error {
+		res, err := db.ExecContext(ctx, `
+			UPDATE group_invitations
+			SET is_active = false
+			WHERE is_active = true
+			  AND ((expires_at IS NOT NULL AND expires_at < CURRENT_TIMESTAMP)
+			       OR (max_uses > 0 AND uses_count >= max_uses))
+		`)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("Deactivated %d expired group invitations", n)
+		}
+		return nil
+	})
+
+	runner.Register("appointments.remind", func(ctx context.Context, _ json.RawMessage) error {
+		return remindUpcomingAppointments(ctx, db, notifications)
+	})
+
+	runner.Register(handlers.ThreadNotifyJob, notifications.NotifyThreadReply)
+	runner.Register(handlers.MentionNotifyJob, notifications.NotifyMentions)
+	runner.Register(handlers.ScheduledDispatchJob, messages.DispatchScheduled)
+	runner.Register(handlers.LinkPreviewJob, previewer.Run)
+	runner.Register(handlers.PollCloseJob, messages.ClosePolls)
+
+	runner.Register("ws.events.prune", func(ctx context.Context, _ json.RawMessage) error {
+		_, err := db.ExecContext(ctx, `
+			DELETE FROM ws_events WHERE created_at < NOW() - $1 * INTERVAL '1 second'
+		`, cfg.WSReplayTTL.Seconds())
+		if err != nil {
+			return err
+		}
+		// Spilled broker events are read as soon as they are notified
+		_, err = db.ExecContext(ctx, `
+			DELETE FROM ws_broker_payloads WHERE created_at < NOW() - INTERVAL '10 minutes'
+		`)
+		return err
+	})
+
+	runner.Register("jobs.prune", func(ctx context.Context, _ json.RawMessage) error {
+		_, err := queue.Prune(ctx, 7*24*time.Hour)
+		return err
+	})
+
+	schedules := []jobs.Schedule{
+		{Name: "counters.reconcile", Spec: "@every " + cfg.CounterReconcileInterval.String(), Kind: "counters.reconcile"},
+		{Name: "presence.reconcile", Spec: "@every 1m", Kind: "presence.reconcile"},
+		{Name: "invitations.cleanup", Spec: "@hourly", Kind: "invitations.cleanup"},
+		{Name: "appointments.remind", Spec: "*/5 * * * *", Kind: "appointments.remind"},
+		{Name: "ws.events.prune", Spec: "*/10 * * * *", Kind: "ws.events.prune"},
+		{Name: "jobs.prune", Spec: "@daily", Kind: "jobs.prune"},
+		{Name: handlers.ScheduledDispatchJob, Spec: "* * * * *", Kind: handlers.ScheduledDispatchJob},
+		{Name: handlers.PollCloseJob, Spec: "* * * * *", Kind: handlers.PollCloseJob},
+	}
+	for _, s := range schedules {
+		if err := runner.Schedule(s); err != nil {
+			return fmt.Errorf("schedule %s: %w", s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// remindUpcomingAppointments notifies both sides of confirmed appointments
+// starting within the next hour. Rows are claimed with SKIP LOCKED and
+// marked in the same transaction, so each reminder goes out once.
+func remindUpcomingAppointments(ctx context.Context, db *sql.DB, notifications *handlers.NotificationHandler) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, provider_id, client_id, COALESCE(title, ''), scheduled_at
+		FROM appointments
+		WHERE status = 'confirmed'
+		  AND reminder_sent_at IS NULL
+		  AND scheduled_at BETWEEN CURRENT_TIMESTAMP AND CURRENT_TIMESTAMP + INTERVAL '1 hour'
+		FOR UPDATE SKIP LOCKED
+	`)
+	if err != nil {
+		return err
+	}
+
+	type reminder struct {
+		id, providerID, clientID, title string
+		scheduledAt                     time.Time
+	}
+	var reminders []reminder
+	for rows.Next() {
+		var r reminder
+		if err := rows.Scan(&r.id, &r.providerID, &r.clientID, &r.title, &r.scheduledAt); err != nil {
+			rows.Close()
+			return err
+		}
+		reminders = append(reminders, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, r := range reminders {
+		if _, err := tx.ExecContext(ctx, `UPDATE appointments SET reminder_sent_at = CURRENT_TIMESTAMP WHERE id = $1`, r.id); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, r := range reminders {
+		content := fmt.Sprintf("%s о %s", r.title, r.scheduledAt.Format("15:04"))
+		for _, userID := range []string{r.providerID, r.clientID} {
+			if err := notifications.CreateNotification(userID, "appointment_reminder", "Нагадування про зустріч", content, "/appointments"); err != nil {
+				log.Printf("Failed to send appointment reminder %s to %s: %v", r.id, userID, err)
+			}
+		}
+	}
+
+	return nil
+}
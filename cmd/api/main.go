@@ -1,12 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
 	"psycho-platform/internal/config"
 	"psycho-platform/internal/database"
+	"psycho-platform/internal/handlers"
+	"psycho-platform/internal/jobs"
+	"psycho-platform/internal/presence"
+	"psycho-platform/internal/rooms"
 	"psycho-platform/internal/router"
+	"psycho-platform/internal/unfurl"
 	"psycho-platform/internal/websocket"
+	"sync"
+	"syscall"
+	"time"
+	// Scheduled messages recur in the user's timezone; don't depend on the
+	// host having zoneinfo
+	_ "time/tzdata"
 
 	"github.com/joho/godotenv"
 )
@@ -26,12 +41,13 @@ func main() {
 
 	// Initialize database
 	log.Println("Connecting to PostgreSQL...")
-	db, err := database.NewPostgresDB(cfg.DatabaseURL)
+	cluster, err := database.NewPostgresDB(cfg.DatabaseURL, cfg.DatabaseReplicaURLs...)
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
-	defer db.Close()
-	log.Println("✓ PostgreSQL connected")
+	cluster.SetReplicaPolicy(cfg.ReplicaMaxLag, cfg.ReadYourWritesWindow)
+	db := cluster.DB
+	log.Printf("✓ PostgreSQL connected (%d read replicas)", len(cfg.DatabaseReplicaURLs))
 
 	// Run migrations
 	log.Println("Running migrations...")
@@ -48,19 +64,72 @@ func main() {
 		log.Println("Continuing without Redis (rate limiting disabled)")
 		redisClient = nil
 	} else {
-		defer redisClient.Close()
+		cluster.UseRedisStickiness(redisClient)
 		log.Println("✓ Redis connected")
 	}
 
 	// Initialize WebSocket hub
 	log.Println("Initializing WebSocket hub...")
 	hub := websocket.NewHub()
+	hub.SetAuthorizer(rooms.NewAuthorizer(db))
+	hub.SetAccountValidator(websocket.NewAccountValidator(db), cfg.WSRevalidateInterval)
+	hub.SetQueueLimits(cfg.WSSendQueue, cfg.WSMaxDrops)
+	nodeID := cfg.NodeID
+	if nodeID == "" {
+		nodeID = websocket.DefaultNodeID()
+	}
+	// The replay log lives next to the broker so sequence numbers are
+	// shared by every node
+	replayOpts := websocket.ReplayOptions{Size: cfg.WSReplaySize, TTL: cfg.WSReplayTTL}
+	var replayLog websocket.ReplayLog = websocket.NewMemoryReplayLog(replayOpts)
+	switch cfg.WSBroker {
+	case "redis", "auto":
+		if redisClient != nil {
+			hub.UseBroker(nodeID, websocket.NewRedisBroker(redisClient), websocket.NewRedisPresence(redisClient))
+			replayLog = websocket.NewRedisReplayLog(redisClient, replayOpts)
+			hub.UseTypingStore(websocket.NewRedisTypingStore(redisClient))
+			log.Printf("✓ WebSocket broker: Redis (node %s)", nodeID)
+		} else if cfg.WSBroker == "redis" {
+			log.Println("WARNING: WS_BROKER=redis but Redis is unavailable, running in-process only")
+		}
+	case "postgres":
+		hub.UseBroker(nodeID, websocket.NewPostgresBroker(db, cfg.DatabaseURL), websocket.NewPostgresPresence(db))
+		replayLog = websocket.NewPostgresReplayLog(db, replayOpts)
+		log.Printf("✓ WebSocket broker: Postgres LISTEN/NOTIFY (node %s)", nodeID)
+	}
+	hub.UseReplayLog(replayLog)
+	presenceNotifier := presence.NewNotifier(db, hub)
+	hub.OnPresenceChange(presenceNotifier.Changed)
 	go hub.Run()
 	log.Println("✓ WebSocket hub running")
 
+	// Start background workers; they stop when workersCtx is cancelled
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		cluster.MonitorReplicas(workersCtx, 15*time.Second)
+	}()
+
+	jobQueue := jobs.NewQueue(db)
+	jobRunner := jobs.NewRunner(db, jobQueue, jobs.RunnerOptions{Workers: cfg.JobWorkers})
+	unfurler := unfurl.New(unfurl.Options{Timeout: cfg.UnfurlTimeout, MaxBytes: int64(cfg.UnfurlMaxBytes)})
+	previewer := handlers.NewLinkPreviewer(db, hub, unfurler)
+	if err := registerJobs(jobRunner, jobQueue, db, handlers.NewMessageHandler(db, hub, jobQueue), handlers.NewNotificationHandler(db, hub), previewer, presenceNotifier, cfg); err != nil {
+		log.Fatal("Failed to register background jobs:", err)
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		jobRunner.Run(workersCtx)
+	}()
+	log.Printf("✓ Job runner started with %d workers", cfg.JobWorkers)
+
 	// Setup router
 	log.Println("Setting up routes...")
-	r := router.Setup(db, redisClient, hub, cfg)
+	r := router.Setup(cluster, redisClient, hub, jobQueue, jobRunner, cfg)
 	log.Println("✓ Routes configured")
 
 	// Start server
@@ -69,9 +138,76 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("✓ Server starting on port %s", port)
-	log.Println("=== API Ready ===")
-	if err := r.Run(":" + port); err != nil {
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.HTTPReadTimeout,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+	}
+
+	// SSE streams are ordinary requests that only end with their client;
+	// closing the hub's connections when shutdown starts lets the HTTP
+	// drain finish instead of waiting out the whole timeout
+	srv.RegisterOnShutdown(hub.CloseConnections)
+
+	// Railway sends SIGTERM before replacing the instance during a deploy
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("✓ Server starting on port %s", port)
+		log.Println("=== API Ready ===")
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
 		log.Fatal("Failed to start server:", err)
+	case <-signals.Done():
+		log.Println("=== Shutting down ===")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// 1. Stop accepting connections and drain in-flight HTTP requests
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("WARNING: HTTP drain incomplete: %v", err)
+	}
+	log.Println("✓ HTTP server stopped")
+
+	// 2. Tell WebSocket clients to reconnect elsewhere
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		log.Printf("WARNING: WebSocket shutdown incomplete: %v", err)
+	}
+	log.Println("✓ WebSocket hub stopped")
+
+	// 3. Stop background workers. They get their own deadline: a slow
+	// drain must not leave them no time to finish before storage closes
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	workersTimeout := time.NewTimer(cfg.ShutdownTimeout)
+	defer workersTimeout.Stop()
+	select {
+	case <-workersDone:
+		log.Println("✓ Background workers stopped")
+	case <-workersTimeout.C:
+		log.Println("WARNING: Background workers did not stop in time")
+	}
+
+	// 4. Close storage last
+	if redisClient != nil {
+		redisClient.Close()
 	}
+	cluster.Close()
+	log.Println("=== Shutdown complete ===")
 }
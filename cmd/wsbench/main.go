@@ -0,0 +1,295 @@
+// Command wsbench measures WebSocket fan-out: it starts an in-process hub
+// behind a loopback listener, connects many clients to one room and
+// broadcasts events to them, reporting throughput, delivery latency and
+// how the hub treated deliberately slow clients.
+//
+//	go run ./cmd/wsbench -clients 10000 -events 200 -slow 100
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/bits"
+	"net"
+	"net/http"
+	"os"
+	"psycho-platform/internal/websocket"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+const benchRoom = "topic_bench"
+
+var logger = log.New(os.Stderr, "", log.LstdFlags)
+
+type benchEvent struct {
+	N      int    `json:"n"`
+	SentAt int64  `json:"sent_at"`
+	Pad    string `json:"pad,omitempty"`
+}
+
+func (benchEvent) EventType() string { return "bench" }
+
+type allowAll struct{}
+
+func (allowAll) CanJoin(context.Context, string, string) (bool, error) { return true, nil }
+
+// histogram buckets latencies by powers of two microseconds.
+type histogram struct {
+	buckets [40]atomic.Int64
+	count   atomic.Int64
+	max     atomic.Int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	us := d.Microseconds()
+	if us < 1 {
+		us = 1
+	}
+	h.buckets[bits.Len64(uint64(us))].Add(1)
+	h.count.Add(1)
+	for {
+		cur := h.max.Load()
+		if us <= cur || h.max.CompareAndSwap(cur, us) {
+			return
+		}
+	}
+}
+
+// quantile returns the upper bound of the bucket containing q.
+func (h *histogram) quantile(q float64) time.Duration {
+	target := int64(float64(h.count.Load()) * q)
+	var seen int64
+	for i := range h.buckets {
+		seen += h.buckets[i].Load()
+		if seen > target {
+			return time.Duration(1<<i) * time.Microsecond
+		}
+	}
+	return time.Duration(h.max.Load()) * time.Microsecond
+}
+
+func main() {
+	clients := flag.Int("clients", 10000, "connected clients")
+	slow := flag.Int("slow", 0, "clients among them that never read")
+	events := flag.Int("events", 200, "events to broadcast")
+	rate := flag.Int("rate", 0, "events per second, 0 for as fast as possible")
+	payload := flag.Int("payload", 256, "padding bytes per event")
+	encoding := flag.String("encoding", websocket.EncodingJSON, "json or msgpack")
+	queue := flag.Int("queue", 256, "per-client send queue")
+	maxDrops := flag.Int("max-drops", 8, "drops before a slow client is disconnected")
+	timeout := flag.Duration("timeout", 2*time.Minute, "give up waiting for deliveries after")
+	flag.Parse()
+
+	if *slow > *clients {
+		logger.Fatal("-slow cannot exceed -clients")
+	}
+	proto := websocket.Protocol{Version: websocket.ProtocolVersion, Encoding: *encoding}
+	if !proto.Supported() {
+		logger.Fatalf("unsupported encoding %q", *encoding)
+	}
+	if err := raiseFileLimit(uint64(*clients)*2 + 256); err != nil {
+		logger.Printf("Could not raise the open file limit: %v", err)
+	}
+
+	// The hub logs every join; keep only the benchmark's own output
+	log.SetOutput(io.Discard)
+	hub := websocket.NewHub()
+	hub.SetAuthorizer(allowAll{})
+	hub.SetQueueLimits(*queue, *maxDrops)
+	go hub.Run()
+	defer hub.Shutdown(context.Background())
+
+	addr := serve(hub)
+	fast := *clients - *slow
+
+	var warmedUp, finished sync.WaitGroup
+	var received atomic.Int64
+	latency := &histogram{}
+
+	start := time.Now()
+	conns := dialAll(addr, proto.Name(), *clients)
+	logger.Printf("Connected %d clients in %v", len(conns), time.Since(start).Round(time.Millisecond))
+
+	warmedUp.Add(len(conns))
+	finished.Add(fast)
+	for i, conn := range conns {
+		if err := conn.WriteJSON(map[string]string{"type": "join_room", "room": benchRoom}); err != nil {
+			logger.Fatalf("join_room: %v", err)
+		}
+		go readLoop(conn, proto.Encoding, i < fast, *events, &warmedUp, &finished, &received, latency)
+	}
+
+	// Broadcast until every client has seen an event, i.e. has joined
+	warmupDone := make(chan struct{})
+	go func() {
+		warmedUp.Wait()
+		close(warmupDone)
+	}()
+	for warming := true; warming; {
+		hub.BroadcastToRoom(benchRoom, benchEvent{N: -1})
+		select {
+		case <-warmupDone:
+			warming = false
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	pad := strings.Repeat("x", *payload)
+	var interval time.Duration
+	if *rate > 0 {
+		interval = time.Second / time.Duration(*rate)
+	}
+
+	start = time.Now()
+	for n := 0; n < *events; n++ {
+		hub.BroadcastToRoom(benchRoom, benchEvent{N: n, SentAt: time.Now().UnixNano(), Pad: pad})
+		if interval > 0 {
+			time.Sleep(interval)
+		}
+	}
+	sent := time.Since(start)
+
+	done := make(chan struct{})
+	go func() {
+		finished.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(*timeout):
+		logger.Printf("Timed out waiting for deliveries")
+	}
+	elapsed := time.Since(start)
+
+	stats := hub.Stats(context.Background())
+	expected := int64(fast) * int64(*events)
+	fmt.Printf("clients        %d (%d slow)\n", *clients, *slow)
+	fmt.Printf("encoding       %s\n", proto.Encoding)
+	fmt.Printf("events         %d x %d bytes padding\n", *events, *payload)
+	fmt.Printf("broadcast      %v (%.0f events/s)\n", sent.Round(time.Millisecond), float64(*events)/sent.Seconds())
+	fmt.Printf("delivered      %d/%d to fast clients in %v (%.0f frames/s)\n",
+		received.Load(), expected, elapsed.Round(time.Millisecond), float64(received.Load())/elapsed.Seconds())
+	fmt.Printf("latency        p50 %v  p95 %v  p99 %v  max %v\n",
+		latency.quantile(0.50), latency.quantile(0.95), latency.quantile(0.99),
+		time.Duration(latency.max.Load())*time.Microsecond)
+	fmt.Printf("dropped        %d frames, %d slow disconnects\n", stats.Dropped, stats.SlowClosed)
+}
+
+// serve exposes the hub on a loopback port. The user ID comes from the
+// query string: the benchmark measures fan-out, not authentication.
+func serve(hub *websocket.Hub) string {
+	upgrader := gorilla.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		Subprotocols:    websocket.SupportedProtocols(),
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		logger.Fatal(err)
+	}
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		proto, ok := websocket.ParseProtocol(conn.Subprotocol())
+		if !ok {
+			proto = websocket.DefaultProtocol
+		}
+		websocket.ServeWs(hub, conn, r.URL.Query().Get("user"), proto)
+	}))
+
+	return listener.Addr().String()
+}
+
+func dialAll(addr, subprotocol string, n int) []*gorilla.Conn {
+	dialer := gorilla.Dialer{
+		Subprotocols:     []string{subprotocol},
+		HandshakeTimeout: 30 * time.Second,
+	}
+
+	conns := make([]*gorilla.Conn, n)
+	sem := make(chan struct{}, 256)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			url := "ws://" + addr + "/?user=bench-" + strconv.Itoa(i)
+			conn, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				logger.Fatalf("dial client %d: %v", i, err)
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+	return conns
+}
+
+type benchFrame struct {
+	Type    string     `json:"type" codec:"type"`
+	Payload benchEvent `json:"payload" codec:"payload"`
+}
+
+// readLoop reads bench frames until the connection closes. Slow clients
+// stop reading after warm-up.
+func readLoop(conn *gorilla.Conn, encoding string, reads bool, events int,
+	warmedUp, finished *sync.WaitGroup, received *atomic.Int64, latency *histogram) {
+	mh := &codec.MsgpackHandle{}
+	mh.RawToString = true
+
+	warm, seen := false, 0
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if reads && seen < events {
+				logger.Printf("Fast client closed after %d/%d events: %v", seen, events, err)
+				finished.Done()
+			}
+			return
+		}
+
+		var f benchFrame
+		if encoding == websocket.EncodingMsgpack {
+			err = codec.NewDecoderBytes(data, mh).Decode(&f)
+		} else {
+			err = json.Unmarshal(data, &f)
+		}
+		if err != nil || f.Type != "bench" {
+			continue
+		}
+
+		if f.Payload.N < 0 {
+			if !warm {
+				warm = true
+				warmedUp.Done()
+				if !reads {
+					return
+				}
+			}
+			continue
+		}
+
+		latency.observe(time.Since(time.Unix(0, f.Payload.SentAt)))
+		received.Add(1)
+		if seen++; seen == events {
+			finished.Done()
+		}
+	}
+}
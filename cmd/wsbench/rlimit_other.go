@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+func raiseFileLimit(n uint64) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// raiseFileLimit lifts the soft open file limit towards n, bounded by the
+// hard limit, since every client holds two sockets.
+func raiseFileLimit(n uint64) error {
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+		return err
+	}
+	if lim.Cur >= n {
+		return nil
+	}
+	lim.Cur = min(n, lim.Max)
+	return syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim)
+}
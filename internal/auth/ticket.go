@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TicketTTL is how long a WebSocket ticket can be redeemed.
+const TicketTTL = 30 * time.Second
+
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+// TicketStore issues single-use tickets that stand in for the JWT on the
+// WebSocket handshake, where browsers cannot send an Authorization header.
+type TicketStore interface {
+	Issue(ctx context.Context, userID string) (string, error)
+	// Redeem returns the ticket's user and invalidates it.
+	Redeem(ctx context.Context, ticket string) (string, error)
+}
+
+func newTicket() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// MemoryTicketStore only works when the ticket is redeemed on the node
+// that issued it; use RedisTicketStore behind a load balancer.
+type MemoryTicketStore struct {
+	mu      sync.Mutex
+	tickets map[string]memoryTicket
+}
+
+type memoryTicket struct {
+	userID    string
+	expiresAt time.Time
+}
+
+func NewMemoryTicketStore() *MemoryTicketStore {
+	return &MemoryTicketStore{tickets: make(map[string]memoryTicket)}
+}
+
+func (s *MemoryTicketStore) Issue(ctx context.Context, userID string) (string, error) {
+	ticket, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for t, entry := range s.tickets {
+		if now.After(entry.expiresAt) {
+			delete(s.tickets, t)
+		}
+	}
+	s.tickets[ticket] = memoryTicket{userID: userID, expiresAt: now.Add(TicketTTL)}
+
+	return ticket, nil
+}
+
+func (s *MemoryTicketStore) Redeem(ctx context.Context, ticket string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.tickets[ticket]
+	delete(s.tickets, ticket)
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", ErrInvalidTicket
+	}
+	return entry.userID, nil
+}
+
+type RedisTicketStore struct {
+	client *redis.Client
+}
+
+func NewRedisTicketStore(client *redis.Client) *RedisTicketStore {
+	return &RedisTicketStore{client: client}
+}
+
+func (s *RedisTicketStore) Issue(ctx context.Context, userID string) (string, error) {
+	ticket, err := newTicket()
+	if err != nil {
+		return "", err
+	}
+	if err := s.client.Set(ctx, "ws_ticket:"+ticket, userID, TicketTTL).Err(); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// Redeem uses GETDEL so two concurrent handshakes cannot share a ticket.
+func (s *RedisTicketStore) Redeem(ctx context.Context, ticket string) (string, error) {
+	userID, err := s.client.GetDel(ctx, "ws_ticket:"+ticket).Result()
+	if err == redis.Nil {
+		return "", ErrInvalidTicket
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
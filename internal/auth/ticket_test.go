@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryTicketStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTicketStore()
+
+	ticket, err := store.Issue(ctx, "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := store.Redeem(ctx, ticket); err != nil || id != "user-1" {
+		t.Fatalf("Redeem = %q, %v; want user-1", id, err)
+	}
+	if _, err := store.Redeem(ctx, ticket); err != ErrInvalidTicket {
+		t.Fatalf("second Redeem error = %v, want ErrInvalidTicket", err)
+	}
+
+	// Each ticket is spent on its own
+	first, _ := store.Issue(ctx, "user-1")
+	second, _ := store.Issue(ctx, "user-1")
+	if first == second {
+		t.Fatal("Issue returned the same ticket twice")
+	}
+	if _, err := store.Redeem(ctx, first); err != nil {
+		t.Fatalf("Redeem of first ticket: %v", err)
+	}
+	if id, err := store.Redeem(ctx, second); err != nil || id != "user-1" {
+		t.Fatalf("Redeem of second ticket = %q, %v; want user-1", id, err)
+	}
+
+	// Concurrent handshakes cannot share a ticket
+	ticket, _ = store.Issue(ctx, "user-1")
+	var wg sync.WaitGroup
+	var redeemed atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := store.Redeem(ctx, ticket); err == nil {
+				redeemed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := redeemed.Load(); n != 1 {
+		t.Fatalf("ticket redeemed %d times, want once", n)
+	}
+
+	expired, _ := store.Issue(ctx, "user-1")
+	store.tickets[expired] = memoryTicket{userID: "user-1", expiresAt: time.Now().Add(-time.Second)}
+	if _, err := store.Redeem(ctx, expired); err != ErrInvalidTicket {
+		t.Fatalf("Redeem of expired ticket error = %v, want ErrInvalidTicket", err)
+	}
+
+	if _, err := store.Redeem(ctx, "unknown"); err != ErrInvalidTicket {
+		t.Fatalf("Redeem of unknown ticket error = %v, want ErrInvalidTicket", err)
+	}
+}
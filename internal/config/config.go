@@ -1,27 +1,76 @@
 package config
 
-import "os"
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type Config struct {
-	DatabaseURL     string
-	RedisURL        string
-	JWTSecret       string
-	HMSAPIKey       string
-	HMSAPISecret    string
-	Environment     string
-	FrontendURL     string
+	DatabaseURL              string
+	DatabaseReplicaURLs      []string
+	ReplicaMaxLag            time.Duration
+	ReadYourWritesWindow     time.Duration
+	RedisURL                 string
+	JWTSecret                string
+	HMSAPIKey                string
+	HMSAPISecret             string
+	Environment              string
+	FrontendURL              string
+	CounterReconcileInterval time.Duration
+	HTTPReadTimeout          time.Duration
+	HTTPWriteTimeout         time.Duration
+	HTTPIdleTimeout          time.Duration
+	ShutdownTimeout          time.Duration
+	JobWorkers               int
+	NodeID                   string
+	WSBroker                 string
+	WSAllowedOrigins         []string
+	WSRevalidateInterval     time.Duration
+	WSReplaySize             int
+	WSReplayTTL              time.Duration
+	WSSendQueue              int
+	WSMaxDrops               int
+	UnfurlTimeout            time.Duration
+	UnfurlMaxBytes           int
 }
 
 func Load() *Config {
-	return &Config{
-		DatabaseURL:  getEnv("DATABASE_URL", "postgres://localhost/psycho_platform?sslmode=disable"),
-		RedisURL:     getEnv("REDIS_URL", "localhost:6379"),
-		JWTSecret:    getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		HMSAPIKey:    getEnv("HMS_API_KEY", ""),
-		HMSAPISecret: getEnv("HMS_API_SECRET", ""),
-		Environment:  getEnv("ENVIRONMENT", "development"),
-		FrontendURL:  getEnv("FRONTEND_URL", "http://localhost:3000"),
+	cfg := &Config{
+		DatabaseURL:              getEnv("DATABASE_URL", "postgres://localhost/psycho_platform?sslmode=disable"),
+		DatabaseReplicaURLs:      getEnvList("DATABASE_REPLICA_URLS"),
+		ReplicaMaxLag:            getEnvDuration("REPLICA_MAX_LAG", 10*time.Second),
+		ReadYourWritesWindow:     getEnvDuration("READ_YOUR_WRITES_WINDOW", 10*time.Second),
+		RedisURL:                 getEnv("REDIS_URL", "localhost:6379"),
+		JWTSecret:                getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		HMSAPIKey:                getEnv("HMS_API_KEY", ""),
+		HMSAPISecret:             getEnv("HMS_API_SECRET", ""),
+		Environment:              getEnv("ENVIRONMENT", "development"),
+		FrontendURL:              getEnv("FRONTEND_URL", "http://localhost:3000"),
+		CounterReconcileInterval: getEnvDuration("COUNTER_RECONCILE_INTERVAL", time.Hour),
+		HTTPReadTimeout:          getEnvDuration("HTTP_READ_TIMEOUT", 15*time.Second),
+		HTTPWriteTimeout:         getEnvDuration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		HTTPIdleTimeout:          getEnvDuration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		ShutdownTimeout:          getEnvDuration("SHUTDOWN_TIMEOUT", 25*time.Second),
+		JobWorkers:               getEnvInt("JOB_WORKERS", 2),
+		NodeID:                   getEnv("NODE_ID", ""),
+		WSBroker:                 getEnv("WS_BROKER", "auto"),
+		WSAllowedOrigins:         getEnvList("WS_ALLOWED_ORIGINS"),
+		WSRevalidateInterval:     getEnvDuration("WS_REVALIDATE_INTERVAL", time.Minute),
+		WSReplaySize:             getEnvInt("WS_REPLAY_SIZE", 500),
+		WSReplayTTL:              getEnvDuration("WS_REPLAY_TTL", time.Hour),
+		WSSendQueue:              getEnvInt("WS_SEND_QUEUE", 256),
+		WSMaxDrops:               getEnvInt("WS_MAX_DROPS", 8),
+		UnfurlTimeout:            getEnvDuration("UNFURL_TIMEOUT", 5*time.Second),
+		UnfurlMaxBytes:           getEnvInt("UNFURL_MAX_BYTES", 512<<10),
 	}
+
+	if len(cfg.WSAllowedOrigins) == 0 {
+		cfg.WSAllowedOrigins = []string{cfg.FrontendURL}
+	}
+
+	return cfg
 }
 
 func getEnv(key, defaultValue string) string {
@@ -30,3 +79,33 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList splits a comma-separated value, dropping empty entries.
+func getEnvList(key string) []string {
+	list := []string{}
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if n, err := strconv.Atoi(value); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration parses values like "30s" or "1h"; invalid values fall back to the default.
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultValue
+}
@@ -0,0 +1,101 @@
+package counters
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var errConnReset = errors.New("connection reset")
+
+// flakyDriver answers the reconciler's queries: every row of every table
+// looks drifted, stored 1 against an actual 2, and the update of row "c"
+// fails.
+type flakyDriver struct{}
+
+func (flakyDriver) Open(name string) (driver.Conn, error) { return flakyConn{}, nil }
+
+type flakyConn struct{}
+
+func (flakyConn) Prepare(query string) (driver.Stmt, error) { return flakyStmt{query}, nil }
+func (flakyConn) Close() error                              { return nil }
+func (flakyConn) Begin() (driver.Tx, error)                 { return flakyTx{}, nil }
+
+type flakyTx struct{}
+
+func (flakyTx) Commit() error   { return nil }
+func (flakyTx) Rollback() error { return nil }
+
+type flakyStmt struct {
+	query string
+}
+
+func (s flakyStmt) Close() error  { return nil }
+func (s flakyStmt) NumInput() int { return -1 }
+
+func (s flakyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if args[0] == "c" {
+		return nil, errConnReset
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s flakyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	switch {
+	case strings.Contains(s.query, "IS DISTINCT FROM"):
+		return &flakyRows{cols: []string{"id", "drift"}, rows: [][]driver.Value{{"a", true}, {"b", true}, {"c", true}}}, nil
+	case strings.Contains(s.query, "FOR UPDATE"):
+		return &flakyRows{cols: []string{"stored"}, rows: [][]driver.Value{{int64(1)}}}, nil
+	default:
+		return &flakyRows{cols: []string{"actual"}, rows: [][]driver.Value{{int64(2)}}}, nil
+	}
+}
+
+type flakyRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *flakyRows) Columns() []string { return r.cols }
+func (r *flakyRows) Close() error      { return nil }
+
+func (r *flakyRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("counters-flaky", flakyDriver{})
+}
+
+func TestReconcileKeepsRepairsOfFailedChecks(t *testing.T) {
+	db, err := sql.Open("counters-flaky", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	drifts, err := NewReconciler(db).Reconcile(context.Background())
+	if !errors.Is(err, errConnReset) {
+		t.Fatalf("Reconcile error = %v, want %v", err, errConnReset)
+	}
+
+	// Every check repaired a and b before failing on c
+	if len(drifts) != 2*len(checks) {
+		t.Fatalf("got %d drifts, want %d: %+v", len(drifts), 2*len(checks), drifts)
+	}
+	for i, d := range drifts {
+		want := Drift{Counter: checks[i/2].name, ID: []string{"a", "b"}[i%2], Stored: 1, Actual: 2}
+		if d != want {
+			t.Errorf("drift %d = %+v, want %+v", i, d, want)
+		}
+	}
+}
@@ -177,21 +177,6 @@ func RunMigrations(db *sql.DB) error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
-		`CREATE TABLE IF NOT EXISTS message_read_receipts (
-			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
-			message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
-			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
-			read_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(message_id, user_id)
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS typing_indicators (
-			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
-			room_id VARCHAR(255) NOT NULL,
-			started_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY(user_id, room_id)
-		)`,
-
 		`CREATE TABLE IF NOT EXISTS file_attachments (
 			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
 			user_id UUID REFERENCES users(id) ON DELETE CASCADE,
@@ -235,10 +220,207 @@ func RunMigrations(db *sql.DB) error {
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 		)`,
 
+		`CREATE TABLE IF NOT EXISTS jobs (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			kind VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INT NOT NULL DEFAULT 0,
+			max_attempts INT NOT NULL DEFAULT 5,
+			run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			locked_by VARCHAR(255),
+			locked_until TIMESTAMPTZ,
+			last_error TEXT,
+			dedupe_key VARCHAR(255) UNIQUE,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			finished_at TIMESTAMPTZ
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS job_schedules (
+			name VARCHAR(100) PRIMARY KEY,
+			spec VARCHAR(100) NOT NULL,
+			kind VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}',
+			next_run_at TIMESTAMPTZ NOT NULL,
+			last_run_at TIMESTAMPTZ,
+			updated_at TIMESTAMPTZ DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS message_revisions (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			edited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS room_read_cursors (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			topic_id UUID REFERENCES topics(id) ON DELETE CASCADE,
+			group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+			last_read_message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+			last_read_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((topic_id IS NULL) <> (group_id IS NULL))
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS thread_follows (
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			following BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, message_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS message_mentions (
+			message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			kind VARCHAR(10) NOT NULL DEFAULT 'user',
+			notified_at TIMESTAMP,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (message_id, user_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS message_deletions (
+			message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			reason TEXT,
+			deleted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS message_pins (
+			message_id UUID PRIMARY KEY REFERENCES messages(id) ON DELETE CASCADE,
+			topic_id UUID REFERENCES topics(id) ON DELETE CASCADE,
+			group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+			pinned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+			position INTEGER NOT NULL,
+			pinned_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			CHECK ((topic_id IS NULL) <> (group_id IS NULL))
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			topic_id UUID REFERENCES topics(id) ON DELETE CASCADE,
+			group_id UUID REFERENCES groups(id) ON DELETE CASCADE,
+			content TEXT NOT NULL,
+			send_at TIMESTAMPTZ NOT NULL,
+			recurrence VARCHAR(100),
+			timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+			status VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+			sent_count INTEGER NOT NULL DEFAULT 0,
+			last_sent_at TIMESTAMPTZ,
+			last_message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+			last_error TEXT,
+			created_at TIMESTAMPTZ DEFAULT NOW(),
+			updated_at TIMESTAMPTZ DEFAULT NOW(),
+			CHECK ((topic_id IS NULL) <> (group_id IS NULL))
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ws_nodes (
+			node_id VARCHAR(255) PRIMARY KEY,
+			heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ws_presence (
+			node_id VARCHAR(255) NOT NULL REFERENCES ws_nodes(node_id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			PRIMARY KEY (node_id, user_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ws_room_seq (
+			room VARCHAR(100) PRIMARY KEY,
+			seq BIGINT NOT NULL
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ws_events (
+			room VARCHAR(100) NOT NULL,
+			seq BIGINT NOT NULL,
+			frame TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (room, seq)
+		)`,
+
+		// Broker events too large for a NOTIFY payload
+		`CREATE TABLE IF NOT EXISTS ws_broker_payloads (
+			id BIGSERIAL PRIMARY KEY,
+			data TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+
+		// Fetched pages, cached by URL; ok is false when the fetch failed
+		`CREATE TABLE IF NOT EXISTS link_previews (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			url VARCHAR(2048) UNIQUE NOT NULL,
+			ok BOOLEAN NOT NULL,
+			title TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			image_url TEXT NOT NULL DEFAULT '',
+			site_name TEXT NOT NULL DEFAULT '',
+			fetched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS message_link_previews (
+			message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+			preview_id UUID REFERENCES link_previews(id) ON DELETE CASCADE,
+			position INT NOT NULL,
+			PRIMARY KEY (message_id, preview_id)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS polls (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			message_id UUID UNIQUE NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+			multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+			anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+			results VARCHAR(20) NOT NULL DEFAULT 'always',
+			closes_at TIMESTAMPTZ,
+			closed_at TIMESTAMPTZ,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS poll_options (
+			id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+			poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			text VARCHAR(200) NOT NULL,
+			position INT NOT NULL
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS poll_votes (
+			poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+			option_id UUID NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+			user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (option_id, user_id)
+		)`,
+
+		`ALTER TABLE user_status ADD COLUMN IF NOT EXISTS presence VARCHAR(10) NOT NULL DEFAULT 'offline'`,
+		`ALTER TABLE ws_presence ADD COLUMN IF NOT EXISTS connections INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE ws_presence ADD COLUMN IF NOT EXISTS status VARCHAR(10) NOT NULL DEFAULT 'online'`,
+
+		`ALTER TABLE messages ADD COLUMN IF NOT EXISTS client_msg_id VARCHAR(64)`,
+		`ALTER TABLE direct_messages ADD COLUMN IF NOT EXISTS client_msg_id VARCHAR(64)`,
+
 		`ALTER TABLE topics ADD COLUMN IF NOT EXISTS is_pinned BOOLEAN DEFAULT FALSE`,
+		`ALTER TABLE appointments ADD COLUMN IF NOT EXISTS reminder_sent_at TIMESTAMP`,
 		`ALTER TABLE topics ADD COLUMN IF NOT EXISTS pinned_at TIMESTAMP`,
 		`ALTER TABLE topics ADD COLUMN IF NOT EXISTS pinned_by UUID REFERENCES users(id) ON DELETE SET NULL`,
 
+		// Emoji sequences (ZWJ families, flags) outgrow ten characters;
+		// NULL allowed_reactions means any emoji may be used in the group
+		`DO $$ BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'reactions' AND column_name = 'emoji'
+				  AND character_maximum_length < 32
+			) THEN
+				ALTER TABLE reactions ALTER COLUMN emoji TYPE VARCHAR(32);
+			END IF;
+		END $$`,
+		`ALTER TABLE groups ADD COLUMN IF NOT EXISTS allowed_reactions TEXT[]`,
+		`ALTER TABLE groups ADD COLUMN IF NOT EXISTS link_previews_enabled BOOLEAN NOT NULL DEFAULT TRUE`,
+
 		`CREATE INDEX IF NOT EXISTS idx_messages_topic ON messages(topic_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_group ON messages(group_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_messages_user ON messages(user_id)`,
@@ -259,6 +441,126 @@ func RunMigrations(db *sql.DB) error {
 		`CREATE INDEX IF NOT EXISTS idx_activity_feed_user ON activity_feed(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_group_invitations_code ON group_invitations(invitation_code)`,
 		`CREATE INDEX IF NOT EXISTS idx_topics_pinned ON topics(is_pinned, pinned_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_jobs_runnable ON jobs(status, run_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_ws_presence_user ON ws_presence(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_ws_events_created ON ws_events(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_ws_broker_payloads_created ON ws_broker_payloads(created_at)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_messages_client_msg ON messages(user_id, client_msg_id) WHERE client_msg_id IS NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_direct_messages_client_msg ON direct_messages(sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL`,
+
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_room_read_cursors_topic ON room_read_cursors(user_id, topic_id) WHERE topic_id IS NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_room_read_cursors_group ON room_read_cursors(user_id, group_id) WHERE group_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_message_revisions_message ON message_revisions(message_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_topic_created ON messages(topic_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_group_created ON messages(group_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_parent_created ON messages(parent_id, created_at) WHERE parent_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_thread_follows_message ON thread_follows(message_id) WHERE following`,
+		`CREATE INDEX IF NOT EXISTS idx_message_mentions_user ON message_mentions(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_messages_user_created ON messages(user_id, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_message_pins_topic ON message_pins(topic_id, position) WHERE topic_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_message_pins_group ON message_pins(group_id, position) WHERE group_id IS NOT NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages(send_at) WHERE status = 'scheduled'`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_user ON scheduled_messages(user_id, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_poll_options_poll ON poll_options(poll_id, position)`,
+		`CREATE INDEX IF NOT EXISTS idx_poll_votes_poll_user ON poll_votes(poll_id, user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_polls_closing ON polls(closes_at) WHERE closed_at IS NULL`,
+
+		// Per-message read receipts gave way to one cursor per room; keep
+		// each user's latest receipt as their cursor
+		`DO $$ BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'message_read_receipts') THEN
+				INSERT INTO room_read_cursors (user_id, topic_id, group_id, last_read_message_id, last_read_at)
+				SELECT DISTINCT ON (r.user_id, m.topic_id, m.group_id)
+				       r.user_id, m.topic_id, m.group_id, m.id, m.created_at
+				FROM message_read_receipts r
+				JOIN messages m ON m.id = r.message_id
+				WHERE (m.topic_id IS NULL) <> (m.group_id IS NULL)
+				ORDER BY r.user_id, m.topic_id, m.group_id, m.created_at DESC
+				ON CONFLICT DO NOTHING;
+				DROP TABLE message_read_receipts;
+			END IF;
+		END $$`,
+
+		// Typing indicators moved to the WebSocket hub's TTL store
+		`DROP TABLE IF EXISTS typing_indicators`,
+		`DELETE FROM job_schedules WHERE name = 'typing.expire'`,
+
+		// Denormalised counters are maintained by triggers so every write path,
+		// including cascades, keeps them consistent within its own transaction.
+		`ALTER TABLE groups ADD COLUMN IF NOT EXISTS messages_count INT DEFAULT 0`,
+
+		`CREATE OR REPLACE FUNCTION sync_message_counts() RETURNS TRIGGER AS $$
+		BEGIN
+			IF TG_OP IN ('UPDATE', 'DELETE') AND NOT COALESCE(OLD.is_deleted, false) THEN
+				UPDATE topics SET messages_count = messages_count - 1 WHERE id = OLD.topic_id;
+				UPDATE groups SET messages_count = messages_count - 1 WHERE id = OLD.group_id;
+			END IF;
+			IF TG_OP IN ('INSERT', 'UPDATE') AND NOT COALESCE(NEW.is_deleted, false) THEN
+				UPDATE topics SET messages_count = messages_count + 1 WHERE id = NEW.topic_id;
+				UPDATE groups SET messages_count = messages_count + 1 WHERE id = NEW.group_id;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'messages_count_insert_delete') THEN
+				CREATE TRIGGER messages_count_insert_delete
+					AFTER INSERT OR DELETE ON messages
+					FOR EACH ROW EXECUTE FUNCTION sync_message_counts();
+			END IF;
+		END $$`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'messages_count_update') THEN
+				CREATE TRIGGER messages_count_update
+					AFTER UPDATE OF is_deleted, topic_id, group_id ON messages
+					FOR EACH ROW
+					WHEN (OLD.is_deleted IS DISTINCT FROM NEW.is_deleted
+					   OR OLD.topic_id IS DISTINCT FROM NEW.topic_id
+					   OR OLD.group_id IS DISTINCT FROM NEW.group_id)
+					EXECUTE FUNCTION sync_message_counts();
+			END IF;
+		END $$`,
+
+		`CREATE OR REPLACE FUNCTION sync_votes_count() RETURNS TRIGGER AS $$
+		BEGIN
+			IF TG_OP IN ('UPDATE', 'DELETE') THEN
+				UPDATE topics
+				SET votes_count = votes_count - CASE WHEN OLD.vote_type = 'down' THEN -1 ELSE 1 END
+				WHERE id = OLD.topic_id;
+			END IF;
+			IF TG_OP IN ('INSERT', 'UPDATE') THEN
+				UPDATE topics
+				SET votes_count = votes_count + CASE WHEN NEW.vote_type = 'down' THEN -1 ELSE 1 END
+				WHERE id = NEW.topic_id;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'topic_votes_count') THEN
+				CREATE TRIGGER topic_votes_count
+					AFTER INSERT OR UPDATE OR DELETE ON topic_votes
+					FOR EACH ROW EXECUTE FUNCTION sync_votes_count();
+			END IF;
+		END $$`,
+
+		`CREATE OR REPLACE FUNCTION sync_members_count() RETURNS TRIGGER AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				UPDATE groups SET members_count = members_count - 1 WHERE id = OLD.group_id;
+			ELSE
+				UPDATE groups SET members_count = members_count + 1 WHERE id = NEW.group_id;
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+		`DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'group_members_count') THEN
+				CREATE TRIGGER group_members_count
+					AFTER INSERT OR DELETE ON group_members
+					FOR EACH ROW EXECUTE FUNCTION sync_members_count();
+			END IF;
+		END $$`,
 
 		// Fix old column names
 		`DO $$ BEGIN
@@ -1,13 +1,79 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
 )
 
-func NewPostgresDB(url string) (*sql.DB, error) {
+// DB is the primary connection pool plus optional read replicas. It embeds
+// the primary *sql.DB, so every existing call goes to the primary; read-only
+// handlers opt in to replicas through Reader.
+type DB struct {
+	*sql.DB
+
+	replicas []*replica
+	next     uint32
+
+	maxLag   time.Duration
+	stickFor time.Duration
+	sticky   stickyStore
+}
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+// ReplicaStatus is reported by the health endpoint.
+type ReplicaStatus struct {
+	Name    string  `json:"name"`
+	Healthy bool    `json:"healthy"`
+	LagSecs float64 `json:"lag_seconds"`
+}
+
+func NewPostgresDB(url string, replicaURLs ...string) (*DB, error) {
+	primary, err := openPool(url)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &DB{
+		DB:       primary,
+		maxLag:   10 * time.Second,
+		stickFor: 10 * time.Second,
+		sticky:   newMemorySticky(),
+	}
+
+	// An unreachable replica must not keep the API from starting; it stays
+	// unhealthy until the monitor sees it come up.
+	for i, replicaURL := range replicaURLs {
+		pool, err := sql.Open("postgres", replicaURL)
+		if err != nil {
+			log.Printf("WARNING: Failed to open read replica %d: %v", i+1, err)
+			continue
+		}
+		pool.SetMaxOpenConns(25)
+		pool.SetMaxIdleConns(5)
+
+		r := &replica{name: fmt.Sprintf("replica-%d", i+1), db: pool}
+		db.replicas = append(db.replicas, r)
+	}
+	db.checkReplicas(context.Background())
+
+	return db, nil
+}
+
+func openPool(url string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
@@ -22,3 +88,184 @@ func NewPostgresDB(url string) (*sql.DB, error) {
 
 	return db, nil
 }
+
+// SetReplicaPolicy overrides the maximum tolerated replication lag and how
+// long a user's reads stay on the primary after one of their writes.
+func (d *DB) SetReplicaPolicy(maxLag, stickFor time.Duration) {
+	d.maxLag = maxLag
+	d.stickFor = stickFor
+}
+
+// UseRedisStickiness shares read-your-writes markers between API replicas.
+func (d *DB) UseRedisStickiness(client *redis.Client) {
+	d.sticky = &redisSticky{client: client}
+}
+
+// Reader returns a pool for read-only queries on behalf of userID: a
+// healthy replica, or the primary when the user wrote recently or no
+// replica is available.
+func (d *DB) Reader(userID string) *sql.DB {
+	if len(d.replicas) == 0 {
+		return d.DB
+	}
+	if userID != "" && d.sticky.recent(userID) {
+		return d.DB
+	}
+
+	start := atomic.AddUint32(&d.next, 1)
+	for i := range d.replicas {
+		r := d.replicas[(int(start)+i)%len(d.replicas)]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+
+	return d.DB
+}
+
+// MarkWrite pins userID's reads to the primary for the stickiness window,
+// so they see their own writes before the replicas catch up.
+func (d *DB) MarkWrite(userID string) {
+	if len(d.replicas) == 0 || userID == "" {
+		return
+	}
+	d.sticky.mark(userID, d.stickFor)
+}
+
+// MonitorReplicas re-checks replica health on every interval until ctx is done.
+func (d *DB) MonitorReplicas(ctx context.Context, interval time.Duration) {
+	if len(d.replicas) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		d.checkReplicas(ctx)
+	}
+}
+
+// checkReplicas measures every replica against the primary's current WAL
+// position. A replica is only lag-free once it has replayed up to that
+// position: comparing its own receive and replay positions would call a
+// replica whose WAL receiver disconnected "caught up" forever.
+func (d *DB) checkReplicas(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var primaryLSN string
+	if err := d.DB.QueryRowContext(ctx, "SELECT pg_current_wal_lsn()::text").Scan(&primaryLSN); err != nil {
+		// Without the primary there is nothing to compare to; reads keep
+		// their current routing until it is back
+		log.Printf("WARNING: Failed to read primary WAL position: %v", err)
+		return
+	}
+
+	for _, r := range d.replicas {
+		d.checkReplica(ctx, r, primaryLSN)
+	}
+}
+
+func (d *DB) checkReplica(ctx context.Context, r *replica, primaryLSN string) {
+	// A replica that replayed everything the primary had written is
+	// current even when the primary is idle and nothing new arrives;
+	// otherwise its lag is the age of the last transaction it replayed.
+	var lag float64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT CASE
+			WHEN NOT pg_is_in_recovery() THEN 0
+			WHEN pg_last_wal_replay_lsn() >= $1::pg_lsn THEN 0
+			-- Behind without ever replaying a transaction: no usable lag yet
+			ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp())::float8, -1)
+		END
+	`, primaryLSN).Scan(&lag)
+
+	healthy := err == nil && lag >= 0 && time.Duration(lag*float64(time.Second)) <= d.maxLag
+	r.lag.Store(int64(lag * 1000))
+	if was := r.healthy.Swap(healthy); was != healthy {
+		if healthy {
+			log.Printf("Read %s is healthy again", r.name)
+		} else {
+			log.Printf("WARNING: Read %s unhealthy (lag %.1fs, err %v), falling back to primary", r.name, lag, err)
+		}
+	}
+}
+
+// Replicas reports the current state of every configured replica.
+func (d *DB) Replicas() []ReplicaStatus {
+	statuses := make([]ReplicaStatus, 0, len(d.replicas))
+	for _, r := range d.replicas {
+		statuses = append(statuses, ReplicaStatus{
+			Name:    r.name,
+			Healthy: r.healthy.Load(),
+			LagSecs: float64(r.lag.Load()) / 1000,
+		})
+	}
+	return statuses
+}
+
+// Close closes the replicas and then the primary.
+func (d *DB) Close() error {
+	for _, r := range d.replicas {
+		r.db.Close()
+	}
+	return d.DB.Close()
+}
+
+type stickyStore interface {
+	mark(userID string, window time.Duration)
+	recent(userID string) bool
+}
+
+type memorySticky struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func newMemorySticky() *memorySticky {
+	return &memorySticky{until: make(map[string]time.Time)}
+}
+
+func (s *memorySticky) mark(userID string, window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.until[userID] = now.Add(window)
+
+	// Opportunistically drop expired markers so the map stays small
+	if len(s.until) > 10000 {
+		for id, t := range s.until {
+			if t.Before(now) {
+				delete(s.until, id)
+			}
+		}
+	}
+}
+
+func (s *memorySticky) recent(userID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.until[userID])
+}
+
+type redisSticky struct {
+	client *redis.Client
+}
+
+func (s *redisSticky) mark(userID string, window time.Duration) {
+	s.client.Set(context.Background(), "rw_sticky:"+userID, 1, window)
+}
+
+// recent errs on the side of the primary when Redis is unavailable.
+func (s *redisSticky) recent(userID string) bool {
+	n, err := s.client.Exists(context.Background(), "rw_sticky:"+userID).Result()
+	return err != nil || n > 0
+}
@@ -1,18 +1,20 @@
 package handlers
 
 import (
-	"database/sql"
 	"encoding/json"
 	"net/http"
+	"psycho-platform/internal/database"
 
 	"github.com/gin-gonic/gin"
 )
 
+// ActivityHandler serves the feed and trending topics from a read replica
+// when one is configured; CreateActivity always writes to the primary.
 type ActivityHandler struct {
-	db *sql.DB
+	db *database.DB
 }
 
-func NewActivityHandler(db *sql.DB) *ActivityHandler {
+func NewActivityHandler(db *database.DB) *ActivityHandler {
 	return &ActivityHandler{db: db}
 }
 
@@ -21,7 +23,7 @@ func (h *ActivityHandler) GetActivityFeed(c *gin.Context) {
 	limit := c.DefaultQuery("limit", "50")
 
 	// Get user's activity and followed users' activity
-	rows, err := h.db.Query(`
+	rows, err := h.db.Reader(userID).Query(`
 		SELECT a.id, a.user_id, a.activity_type, a.entity_type, a.entity_id,
 		       a.content, a.metadata, a.created_at,
 		       u.username, u.display_name, u.avatar_url
@@ -82,7 +84,7 @@ func (h *ActivityHandler) GetTrendingTopics(c *gin.Context) {
 	limit := c.DefaultQuery("limit", "10")
 	timeframe := c.DefaultQuery("timeframe", "24") // hours
 
-	rows, err := h.db.Query(`
+	rows, err := h.db.Reader(c.GetString("user_id")).Query(`
 		SELECT t.id, t.title, t.description, t.votes_count, t.messages_count,
 		       COUNT(DISTINCT m.id) as recent_messages,
 		       u.username, u.display_name
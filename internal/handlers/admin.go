@@ -3,16 +3,17 @@ package handlers
 import (
 	"database/sql"
 	"net/http"
+	"psycho-platform/internal/database"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AdminHandler struct {
-	db *sql.DB
+	db *database.DB
 }
 
-func NewAdminHandler(db *sql.DB) *AdminHandler {
+func NewAdminHandler(db *database.DB) *AdminHandler {
 	return &AdminHandler{db: db}
 }
 
@@ -28,14 +29,16 @@ func (h *AdminHandler) GetStats(c *gin.Context) {
 		TotalSuperAdmins  int `json:"total_super_admins"`
 	}
 
-	h.db.QueryRow("SELECT COUNT(*) FROM users").Scan(&stats.TotalUsers)
-	h.db.QueryRow("SELECT COUNT(*) FROM topics").Scan(&stats.TotalTopics)
-	h.db.QueryRow("SELECT COUNT(*) FROM groups").Scan(&stats.TotalGroups)
-	h.db.QueryRow("SELECT COUNT(*) FROM messages").Scan(&stats.TotalMessages)
-	h.db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&stats.TotalSessions)
-	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'premium'").Scan(&stats.TotalPremiumUsers)
-	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'basic'").Scan(&stats.TotalBasicUsers)
-	h.db.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'super_admin'").Scan(&stats.TotalSuperAdmins)
+	// Aggregate counts tolerate replica lag
+	reader := h.db.Reader(c.GetString("user_id"))
+	reader.QueryRow("SELECT COUNT(*) FROM users").Scan(&stats.TotalUsers)
+	reader.QueryRow("SELECT COUNT(*) FROM topics").Scan(&stats.TotalTopics)
+	reader.QueryRow("SELECT COUNT(*) FROM groups").Scan(&stats.TotalGroups)
+	reader.QueryRow("SELECT COUNT(*) FROM messages").Scan(&stats.TotalMessages)
+	reader.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&stats.TotalSessions)
+	reader.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'premium'").Scan(&stats.TotalPremiumUsers)
+	reader.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'basic'").Scan(&stats.TotalBasicUsers)
+	reader.QueryRow("SELECT COUNT(*) FROM users WHERE role = 'super_admin'").Scan(&stats.TotalSuperAdmins)
 
 	c.JSON(http.StatusOK, stats)
 }
@@ -104,7 +107,7 @@ func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
 	}
 
 	role := strings.ToLower(req.Role)
-	if role != "super_admin" && role != "premium" && role != "basic" {
+	if role != "super_admin" && role != "moderator" && role != "premium" && role != "basic" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
 		return
 	}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"net/http"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/validation"
 	"psycho-platform/internal/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -20,6 +25,8 @@ func NewDMHandler(db *sql.DB, hub *websocket.Hub) *DMHandler {
 type CreateDMRequest struct {
 	RecipientID string `json:"recipient_id" binding:"required"`
 	Content     string `json:"content" binding:"required"`
+	// ClientMsgID is chosen by the client to deduplicate retried sends
+	ClientMsgID string `json:"client_msg_id"`
 }
 
 func (h *DMHandler) SendDirectMessage(c *gin.Context) {
@@ -30,23 +37,76 @@ func (h *DMHandler) SendDirectMessage(c *gin.Context) {
 		return
 	}
 
+	message, created, err := h.sendDirectMessage(c.Request.Context(), userID, req)
+	if err != nil {
+		writeSendError(c, err, "Failed to send message")
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	c.JSON(status, message)
+}
+
+// HandleSendFrame is the WebSocket counterpart of SendDirectMessage,
+// registered for send_dm frames.
+func (h *DMHandler) HandleSendFrame(ctx context.Context, userID string, payload json.RawMessage) (interface{}, error) {
+	var req CreateDMRequest
+	if err := json.Unmarshal(payload, &req); err != nil || req.RecipientID == "" {
+		return nil, &sendError{http.StatusBadRequest, "invalid_payload", "Malformed send_dm payload"}
+	}
+	if req.ClientMsgID == "" {
+		return nil, &sendError{http.StatusBadRequest, "invalid_payload", "client_msg_id is required"}
+	}
+
+	message, _, err := h.sendDirectMessage(ctx, userID, req)
+	return message, err
+}
+
+// sendDirectMessage validates and stores a direct message, creating the
+// conversation on first contact, and pushes it to the recipient. A retry
+// with an already used client_msg_id returns the stored message with
+// created=false.
+func (h *DMHandler) sendDirectMessage(ctx context.Context, userID string, req CreateDMRequest) (*models.DirectMessage, bool, error) {
+	content := validation.SanitizeString(req.Content)
+	if err := validation.ValidateContent(content, maxMessageLength); err != nil {
+		return nil, false, &sendError{http.StatusBadRequest, "invalid_content", err.Error()}
+	}
+	if len(req.ClientMsgID) > maxClientMsgIDLength {
+		return nil, false, &sendError{http.StatusBadRequest, "invalid_payload", "client_msg_id is too long"}
+	}
+	if req.RecipientID == userID {
+		return nil, false, &sendError{http.StatusBadRequest, "invalid_target", "Cannot message yourself"}
+	}
+
+	var recipientActive bool
+	err := h.db.QueryRowContext(ctx, "SELECT is_active FROM users WHERE id = $1", req.RecipientID).Scan(&recipientActive)
+	if err == sql.ErrNoRows || (err == nil && !recipientActive) {
+		return nil, false, &sendError{http.StatusNotFound, "not_found", "Recipient not found"}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
 	// Check if user is blocked
 	var isBlocked bool
-	h.db.QueryRow(`
+	if err := h.db.QueryRowContext(ctx, `
 		SELECT EXISTS(
 			SELECT 1 FROM user_blocks
 			WHERE user_id = $1 AND blocked_user_id = $2
 		)
-	`, req.RecipientID, userID).Scan(&isBlocked)
-
+	`, req.RecipientID, userID).Scan(&isBlocked); err != nil {
+		return nil, false, err
+	}
 	if isBlocked {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are blocked by this user"})
-		return
+		return nil, false, &sendError{http.StatusForbidden, "blocked", "You are blocked by this user"}
 	}
 
 	// Create conversation if not exists
 	var conversationID string
-	err := h.db.QueryRow(`
+	err = h.db.QueryRowContext(ctx, `
 		INSERT INTO conversations (user1_id, user2_id)
 		SELECT $1, $2
 		WHERE NOT EXISTS (
@@ -56,54 +116,63 @@ func (h *DMHandler) SendDirectMessage(c *gin.Context) {
 		)
 		RETURNING id
 	`, userID, req.RecipientID).Scan(&conversationID)
-
-	if err != nil {
+	if err == sql.ErrNoRows {
 		// Conversation exists, get it
-		h.db.QueryRow(`
+		err = h.db.QueryRowContext(ctx, `
 			SELECT id FROM conversations
 			WHERE (user1_id = $1 AND user2_id = $2)
 			   OR (user1_id = $2 AND user2_id = $1)
 		`, userID, req.RecipientID).Scan(&conversationID)
 	}
+	if err != nil {
+		return nil, false, err
+	}
 
-	// Create message
-	var message struct {
-		ID             string `json:"id"`
-		ConversationID string `json:"conversation_id"`
-		SenderID       string `json:"sender_id"`
-		Content        string `json:"content"`
-		IsRead         bool   `json:"is_read"`
-		CreatedAt      string `json:"created_at"`
+	var clientMsgID *string
+	if req.ClientMsgID != "" {
+		clientMsgID = &req.ClientMsgID
 	}
 
-	err = h.db.QueryRow(`
-		INSERT INTO direct_messages (conversation_id, sender_id, content)
-		VALUES ($1, $2, $3)
+	message := models.DirectMessage{ClientMsgID: req.ClientMsgID}
+	err = h.db.QueryRowContext(ctx, `
+		INSERT INTO direct_messages (conversation_id, sender_id, content, client_msg_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (sender_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
 		RETURNING id, conversation_id, sender_id, content, is_read, created_at
-	`, conversationID, userID, req.Content).Scan(
+	`, conversationID, userID, content, clientMsgID).Scan(
 		&message.ID, &message.ConversationID, &message.SenderID,
 		&message.Content, &message.IsRead, &message.CreatedAt,
 	)
-
+	if err == sql.ErrNoRows {
+		err = h.db.QueryRowContext(ctx, `
+			SELECT id, conversation_id, sender_id, content, is_read, created_at
+			FROM direct_messages
+			WHERE sender_id = $1 AND client_msg_id = $2
+		`, userID, req.ClientMsgID).Scan(
+			&message.ID, &message.ConversationID, &message.SenderID,
+			&message.Content, &message.IsRead, &message.CreatedAt,
+		)
+		if err != nil {
+			return nil, false, err
+		}
+		return &message, false, nil
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send message"})
-		return
+		return nil, false, err
 	}
 
 	// Update conversation timestamp
-	h.db.Exec(`
+	h.db.ExecContext(ctx, `
 		UPDATE conversations
 		SET last_message_at = CURRENT_TIMESTAMP
 		WHERE id = $1
 	`, conversationID)
 
 	// Broadcast via WebSocket
-	h.hub.BroadcastToRoom("dm_"+req.RecipientID, map[string]interface{}{
-		"type":    "new_dm",
-		"payload": message,
-	})
+	h.hub.BroadcastToRoom(rooms.DM(req.RecipientID), websocket.NewDMEvent{DirectMessage: &message})
+	h.hub.StopTyping(userID, rooms.Conversation(conversationID))
 
-	c.JSON(http.StatusCreated, message)
+	return &message, true, nil
 }
 
 func (h *DMHandler) GetConversations(c *gin.Context) {
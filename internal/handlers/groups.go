@@ -4,16 +4,20 @@ import (
 	"database/sql"
 	"net/http"
 	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/websocket"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 type GroupHandler struct {
-	db *sql.DB
+	db  *sql.DB
+	hub *websocket.Hub
 }
 
-func NewGroupHandler(db *sql.DB) *GroupHandler {
-	return &GroupHandler{db: db}
+func NewGroupHandler(db *sql.DB, hub *websocket.Hub) *GroupHandler {
+	return &GroupHandler{db: db, hub: hub}
 }
 
 func (h *GroupHandler) CreateGroup(c *gin.Context) {
@@ -33,12 +37,13 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 
 	var group models.Group
 	err = tx.QueryRow(`
-		INSERT INTO groups (name, description, is_private, created_by, members_count)
-		VALUES ($1, $2, $3, $4, 1)
-		RETURNING id, name, description, avatar_url, is_private, created_by, members_count, created_at, updated_at
+		INSERT INTO groups (name, description, is_private, created_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, name, description, COALESCE(avatar_url, ''), is_private, created_by,
+		          members_count, messages_count, created_at, updated_at
 	`, req.Name, req.Description, req.IsPrivate, userID).Scan(
 		&group.ID, &group.Name, &group.Description, &group.AvatarURL,
-		&group.IsPrivate, &group.CreatedBy, &group.MembersCount,
+		&group.IsPrivate, &group.CreatedBy, &group.MembersCount, &group.MessagesCount,
 		&group.CreatedAt, &group.UpdatedAt,
 	)
 
@@ -47,12 +52,13 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 		return
 	}
 
-	// Add creator as admin
+	// Add creator as admin; the members_count trigger bumps the counter
 	_, err = tx.Exec("INSERT INTO group_members (group_id, user_id, role) VALUES ($1, $2, 'admin')", group.ID, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add creator to group"})
 		return
 	}
+	group.MembersCount = 1
 
 	tx.Commit()
 	c.JSON(http.StatusCreated, group)
@@ -62,12 +68,16 @@ func (h *GroupHandler) GetGroups(c *gin.Context) {
 	userID := c.GetString("user_id")
 
 	query := `
-		SELECT g.id, g.name, g.description, g.avatar_url, g.is_private, g.created_by,
-		       g.members_count, g.created_at, g.updated_at,
+		SELECT g.id, g.name, g.description, COALESCE(g.avatar_url, ''), g.is_private, g.created_by,
+		       g.members_count, g.messages_count, g.created_at, g.updated_at,
 		       COALESCE(gm.role, '') as user_role,
-		       CASE WHEN gm.user_id IS NOT NULL THEN true ELSE false END as is_member
+		       CASE WHEN gm.user_id IS NOT NULL THEN true ELSE false END as is_member,
+		       unread.unread_count, unread.mention_count, g.allowed_reactions,
+		       g.link_previews_enabled
 		FROM groups g
 		LEFT JOIN group_members gm ON g.id = gm.group_id AND gm.user_id = $1
+		LEFT JOIN room_read_cursors rc ON rc.user_id = $1 AND rc.group_id = g.id
+		CROSS JOIN LATERAL (` + unreadSQL("group_id", "g.id", "gm.user_id IS NOT NULL") + `) unread
 		WHERE g.is_private = false OR gm.user_id IS NOT NULL
 		ORDER BY g.created_at DESC
 	`
@@ -84,8 +94,10 @@ func (h *GroupHandler) GetGroups(c *gin.Context) {
 		var group models.Group
 		err := rows.Scan(
 			&group.ID, &group.Name, &group.Description, &group.AvatarURL,
-			&group.IsPrivate, &group.CreatedBy, &group.MembersCount,
+			&group.IsPrivate, &group.CreatedBy, &group.MembersCount, &group.MessagesCount,
 			&group.CreatedAt, &group.UpdatedAt, &group.Role, &group.IsMember,
+			&group.UnreadCount, &group.MentionCount, pq.Array(&group.AllowedReactions),
+			&group.LinkPreviewsEnabled,
 		)
 		if err != nil {
 			continue
@@ -119,8 +131,6 @@ func (h *GroupHandler) JoinGroup(c *gin.Context) {
 		return
 	}
 
-	h.db.Exec("UPDATE groups SET members_count = (SELECT COUNT(*) FROM group_members WHERE group_id = $1) WHERE id = $1", groupID)
-
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -134,7 +144,7 @@ func (h *GroupHandler) LeaveGroup(c *gin.Context) {
 		return
 	}
 
-	h.db.Exec("UPDATE groups SET members_count = (SELECT COUNT(*) FROM group_members WHERE group_id = $1) WHERE id = $1", groupID)
+	h.hub.RemoveUserFromRoom(userID, rooms.Group(groupID))
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
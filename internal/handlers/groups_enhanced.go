@@ -5,9 +5,13 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"net/http"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/validation"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 // Enhanced group functionality
@@ -175,13 +179,6 @@ func (h *GroupHandler) JoinByInvitation(c *gin.Context) {
 		WHERE invitation_code = $1
 	`, inviteCode)
 
-	// Update group member count
-	h.db.Exec(`
-		UPDATE groups
-		SET members_count = (SELECT COUNT(*) FROM group_members WHERE group_id = $1)
-		WHERE id = $1
-	`, groupID)
-
 	c.JSON(http.StatusOK, gin.H{"success": true, "group_id": groupID})
 }
 
@@ -272,16 +269,106 @@ func (h *GroupHandler) RemoveMember(c *gin.Context) {
 		return
 	}
 
-	// Update member count
-	h.db.Exec(`
-		UPDATE groups
-		SET members_count = (SELECT COUNT(*) FROM group_members WHERE group_id = $1)
-		WHERE id = $1
-	`, groupID)
+	h.hub.RemoveUserFromRoom(memberID, rooms.Group(groupID))
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// maxAllowedReactions caps a group's reaction allow-list.
+const maxAllowedReactions = 20
+
+// SetAllowedReactions limits the emoji members can react with, e.g. to
+// supportive ones in a grief group. An empty list lifts the restriction.
+// Reactions already given are kept.
+func (h *GroupHandler) SetAllowedReactions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	groupID := c.Param("id")
+
+	var req models.SetAllowedReactionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed := []string{}
+	seen := make(map[string]bool)
+	for _, emoji := range req.Allowed {
+		if err := validation.ValidateEmoji(emoji); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "emoji": emoji})
+			return
+		}
+		if !seen[emoji] {
+			seen[emoji] = true
+			allowed = append(allowed, emoji)
+		}
+	}
+	if len(allowed) > maxAllowedReactions {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many allowed reactions"})
+		return
+	}
+
+	// Check if user is admin of the group
+	var role string
+	err := h.db.QueryRow(`
+		SELECT role FROM group_members
+		WHERE group_id = $1 AND user_id = $2
+	`, groupID, userID).Scan(&role)
+
+	if err != nil || role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can change allowed reactions"})
+		return
+	}
+
+	var value interface{} = pq.Array(allowed)
+	if len(allowed) == 0 {
+		value = nil
+	}
+	_, err = h.db.Exec(`
+		UPDATE groups SET allowed_reactions = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+	`, value, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update allowed reactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"allowed_reactions": allowed})
+}
+
+// SetLinkPreviews turns link previews in the group's new messages on or
+// off. Previews already shown are kept.
+func (h *GroupHandler) SetLinkPreviews(c *gin.Context) {
+	userID := c.GetString("user_id")
+	groupID := c.Param("id")
+
+	var req models.SetLinkPreviewsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check if user is admin of the group
+	var role string
+	err := h.db.QueryRow(`
+		SELECT role FROM group_members
+		WHERE group_id = $1 AND user_id = $2
+	`, groupID, userID).Scan(&role)
+
+	if err != nil || role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can change link previews"})
+		return
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE groups SET link_previews_enabled = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2
+	`, *req.Enabled, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update link previews"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"link_previews_enabled": *req.Enabled})
+}
+
 func generateInviteCode() string {
 	b := make([]byte, 12)
 	rand.Read(b)
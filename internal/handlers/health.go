@@ -1,20 +1,22 @@
 package handlers
 
 import (
-	"database/sql"
 	"net/http"
+	"psycho-platform/internal/database"
+	"psycho-platform/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 )
 
 type HealthHandler struct {
-	db    *sql.DB
+	db    *database.DB
 	redis *redis.Client
+	hub   *websocket.Hub
 }
 
-func NewHealthHandler(db *sql.DB, redis *redis.Client) *HealthHandler {
-	return &HealthHandler{db: db, redis: redis}
+func NewHealthHandler(db *database.DB, redis *redis.Client, hub *websocket.Hub) *HealthHandler {
+	return &HealthHandler{db: db, redis: redis, hub: hub}
 }
 
 func (h *HealthHandler) Check(c *gin.Context) {
@@ -32,6 +34,13 @@ func (h *HealthHandler) Check(c *gin.Context) {
 		health["database"] = "healthy"
 	}
 
+	// Replicas don't degrade the status: reads fall back to the primary
+	if replicas := h.db.Replicas(); len(replicas) > 0 {
+		health["replicas"] = replicas
+	}
+
+	health["websocket"] = h.hub.Stats(c.Request.Context())
+
 	// Check Redis
 	if err := h.redis.Ping(c.Request.Context()).Err(); err != nil {
 		health["redis"] = "unhealthy"
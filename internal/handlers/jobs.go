@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"psycho-platform/internal/jobs"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobHandler struct {
+	queue  *jobs.Queue
+	runner *jobs.Runner
+}
+
+func NewJobHandler(queue *jobs.Queue, runner *jobs.Runner) *JobHandler {
+	return &JobHandler{queue: queue, runner: runner}
+}
+
+func (h *JobHandler) GetJobs(c *gin.Context) {
+	status := c.Query("status")
+	kind := c.Query("kind")
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 500 {
+		limit = 50
+	}
+
+	list, err := h.queue.List(c.Request.Context(), status, kind, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (h *JobHandler) GetSchedules(c *gin.Context) {
+	schedules, err := h.runner.Schedules(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+func (h *JobHandler) RetryJob(c *gin.Context) {
+	jobID := c.Param("id")
+
+	err := h.queue.Retry(c.Request.Context(), jobID)
+	if err == jobs.ErrJobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found or not retryable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"psycho-platform/internal/jobs"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/unfurl"
+	"psycho-platform/internal/websocket"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LinkPreviewJob unfurls the links in a message and attaches the previews.
+// Its payload is LinkPreviewPayload.
+const LinkPreviewJob = "messages.unfurl"
+
+type LinkPreviewPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// maxLinkPreviews caps the previews of one message.
+const maxLinkPreviews = 3
+
+// How long fetched pages are cached; failures are retried sooner.
+const (
+	linkPreviewTTL       = 24 * time.Hour
+	linkPreviewFailedTTL = time.Hour
+)
+
+// queueLinkPreviews schedules unfurling for a message with links. Edits
+// always queue it, so previews of removed links are dropped too.
+func (h *MessageHandler) queueLinkPreviews(ctx context.Context, messageID, content string, edited bool) {
+	if !edited && len(unfurl.ExtractURLs(content, 1)) == 0 {
+		return
+	}
+	if _, err := h.jobs.Enqueue(ctx, LinkPreviewJob, LinkPreviewPayload{MessageID: messageID}, jobs.EnqueueOptions{}); err != nil {
+		log.Printf("Failed to queue link previews of %s: %v", messageID, err)
+	}
+}
+
+// LinkPreviewer runs LinkPreviewJob.
+type LinkPreviewer struct {
+	db       *sql.DB
+	hub      *websocket.Hub
+	unfurler *unfurl.Unfurler
+}
+
+func NewLinkPreviewer(db *sql.DB, hub *websocket.Hub, unfurler *unfurl.Unfurler) *LinkPreviewer {
+	return &LinkPreviewer{db: db, hub: hub, unfurler: unfurler}
+}
+
+// Run attaches previews of the message's links, fetching pages not in the
+// cache, and pushes them to the room as message_updated. Groups that
+// disabled link previews get none.
+func (p *LinkPreviewer) Run(ctx context.Context, payload json.RawMessage) error {
+	var req LinkPreviewPayload
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+
+	var content string
+	var topicID, groupID *string
+	var isDeleted, enabled bool
+	err := p.db.QueryRowContext(ctx, `
+		SELECT m.content, m.topic_id, m.group_id, COALESCE(m.is_deleted, false),
+		       COALESCE(g.link_previews_enabled, true)
+		FROM messages m
+		LEFT JOIN groups g ON g.id = m.group_id
+		WHERE m.id = $1
+	`, req.MessageID).Scan(&content, &topicID, &groupID, &isDeleted, &enabled)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isDeleted {
+		return nil
+	}
+
+	var urls []string
+	if enabled {
+		urls = unfurl.ExtractURLs(content, maxLinkPreviews)
+	}
+	previewIDs := []string{}
+	previews := []models.LinkPreview{}
+	for _, u := range urls {
+		id, preview, err := p.preview(ctx, u)
+		if err != nil {
+			return err
+		}
+		if preview != nil {
+			previewIDs = append(previewIDs, id)
+			previews = append(previews, *preview)
+		}
+	}
+
+	changed, err := p.attach(ctx, req.MessageID, content, previewIDs)
+	if err != nil || !changed {
+		return err
+	}
+
+	p.hub.BroadcastToRoom(messageRoom(topicID, groupID), websocket.MessageUpdatedEvent{
+		ID:           req.MessageID,
+		LinkPreviews: previews,
+	})
+	return nil
+}
+
+// preview returns the cached preview of rawURL, fetching the page when the
+// cache has none or it expired. A page without a preview returns nil.
+func (p *LinkPreviewer) preview(ctx context.Context, rawURL string) (string, *models.LinkPreview, error) {
+	var id string
+	var ok bool
+	preview := models.LinkPreview{URL: rawURL}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT id, ok, title, description, image_url, site_name
+		FROM link_previews
+		WHERE url = $1
+		  AND fetched_at > CURRENT_TIMESTAMP - CASE WHEN ok THEN $2 ELSE $3 END * INTERVAL '1 second'
+	`, rawURL, linkPreviewTTL.Seconds(), linkPreviewFailedTTL.Seconds()).Scan(
+		&id, &ok, &preview.Title, &preview.Description, &preview.ImageURL, &preview.SiteName,
+	)
+	if err == nil {
+		if !ok {
+			return "", nil, nil
+		}
+		return id, &preview, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", nil, err
+	}
+
+	fetched, fetchErr := p.unfurler.Unfurl(ctx, rawURL)
+	if fetchErr != nil {
+		if ctx.Err() != nil {
+			// Cancelled, e.g. at shutdown: the page itself did not fail,
+			// so let the job retry instead of caching a failure
+			return "", nil, ctx.Err()
+		}
+		// Fetch failures are cached rather than retried by the job
+		fetched = &unfurl.Preview{}
+	}
+	err = p.db.QueryRowContext(ctx, `
+		INSERT INTO link_previews (url, ok, title, description, image_url, site_name, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP)
+		ON CONFLICT (url) DO UPDATE
+		SET ok = EXCLUDED.ok, title = EXCLUDED.title, description = EXCLUDED.description,
+		    image_url = EXCLUDED.image_url, site_name = EXCLUDED.site_name,
+		    fetched_at = EXCLUDED.fetched_at
+		RETURNING id
+	`, rawURL, fetchErr == nil, fetched.Title, fetched.Description, fetched.ImageURL, fetched.SiteName).Scan(&id)
+	if err != nil {
+		return "", nil, err
+	}
+	if fetchErr != nil {
+		return "", nil, nil
+	}
+
+	preview.Title = fetched.Title
+	preview.Description = fetched.Description
+	preview.ImageURL = fetched.ImageURL
+	preview.SiteName = fetched.SiteName
+	return id, &preview, nil
+}
+
+// attach replaces the message's previews with previewIDs, unless the
+// message was edited since content was read; the edit queued its own job.
+// It reports whether the previews changed.
+func (p *LinkPreviewer) attach(ctx context.Context, messageID, content string, previewIDs []string) (bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var current string
+	var existing []string
+	err = tx.QueryRowContext(ctx, `
+		SELECT m.content, ARRAY(
+			SELECT mp.preview_id::text FROM message_link_previews mp
+			WHERE mp.message_id = m.id ORDER BY mp.position
+		)
+		FROM messages m
+		WHERE m.id = $1
+		FOR UPDATE
+	`, messageID).Scan(&current, pq.Array(&existing))
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if current != content || equalStrings(existing, previewIDs) {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM message_link_previews WHERE message_id = $1", messageID); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_link_previews (message_id, preview_id, position)
+		SELECT $1, p.id, p.position - 1
+		FROM unnest($2::uuid[]) WITH ORDINALITY AS p(id, position)
+	`, messageID, pq.Array(previewIDs))
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func loadLinkPreviews(db *sql.DB, ids []string, messages []models.Message, index map[string]int) error {
+	rows, err := db.Query(`
+		SELECT mp.message_id, p.url, p.title, p.description, p.image_url, p.site_name
+		FROM message_link_previews mp
+		JOIN link_previews p ON p.id = mp.preview_id AND p.ok
+		JOIN messages m ON m.id = mp.message_id AND NOT COALESCE(m.is_deleted, false)
+		WHERE mp.message_id = ANY($1::uuid[])
+		ORDER BY mp.position
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var preview models.LinkPreview
+		if err := rows.Scan(
+			&messageID, &preview.URL, &preview.Title, &preview.Description,
+			&preview.ImageURL, &preview.SiteName,
+		); err != nil {
+			return err
+		}
+
+		msg := &messages[index[messageID]]
+		msg.LinkPreviews = append(msg.LinkPreviews, preview)
+	}
+
+	return rows.Err()
+}
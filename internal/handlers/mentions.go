@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"psycho-platform/internal/jobs"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// MentionNotifyJob notifies the users mentioned in a message who were not
+// notified yet. Its payload is MentionNotifyPayload.
+const MentionNotifyJob = "mentions.notify"
+
+type MentionNotifyPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// maxMentions caps the distinct usernames taken from one message.
+const maxMentions = 20
+
+// Mention kinds, stored in message_mentions.kind.
+const (
+	mentionUser  = "user"
+	mentionHere  = "here"
+	mentionGroup = "group"
+)
+
+// mentionPattern matches "@name" not preceded by a word character, so
+// e-mail addresses are not mentions. Names longer than a username are cut
+// off by the regexp and rejected by the trailing check in parseMentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_])@([A-Za-z0-9_]{3,50})`)
+
+// parseMentions returns the usernames content mentions, and whether it
+// uses @here (members online now) or @group (all members). Those two are
+// reserved and never match a user of that name.
+func parseMentions(content string) (usernames []string, here, group bool) {
+	seen := make(map[string]bool)
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		end := m[3]
+		if end < len(content) && isUsernameByte(content[end]) {
+			continue
+		}
+		name := content[m[2]:end]
+		switch name {
+		case mentionHere:
+			here = true
+		case mentionGroup:
+			group = true
+		default:
+			if !seen[name] && len(usernames) < maxMentions {
+				seen[name] = true
+				usernames = append(usernames, name)
+			}
+		}
+	}
+	return usernames, here, group
+}
+
+func isUsernameByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// mentionableSQL restricts users u to those author $1 may mention in the
+// room $2: group members, or for a topic its creator and anyone who has
+// posted in it while they can still see it, minus blocks either way.
+func mentionableSQL(room readRoom) string {
+	member := `EXISTS (SELECT 1 FROM group_members gm WHERE gm.group_id = $2 AND gm.user_id = u.id)`
+	if room.column == "topic_id" {
+		member = `EXISTS (
+			SELECT 1 FROM topics t
+			WHERE t.id = $2
+			  AND (COALESCE(t.is_public, true) OR t.created_by = u.id)
+			  AND (t.created_by = u.id OR EXISTS (
+			      SELECT 1 FROM messages pm WHERE pm.topic_id = t.id AND pm.user_id = u.id
+			  ))
+		)`
+	}
+	return `u.id <> $1
+		AND COALESCE(u.is_active, true)
+		AND ` + member + `
+		AND NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.user_id = u.id AND b.blocked_user_id = $1)
+			   OR (b.user_id = $1 AND b.blocked_user_id = u.id)
+		)`
+}
+
+func mentionRoom(topicID, groupID *string) readRoom {
+	if groupID != nil {
+		return groupReadRoom(*groupID)
+	}
+	return topicReadRoom(*topicID)
+}
+
+// syncMentions stores who a message mentions, replacing what an earlier
+// version mentioned, and queues notifications for anyone newly mentioned.
+// Mentions of users outside the room, or blocked either way, are dropped;
+// @here and @group only count when the author moderates the room.
+func (h *MessageHandler) syncMentions(ctx context.Context, messageID, authorID, content string, topicID, groupID *string) error {
+	usernames, here, group := parseMentions(content)
+	if here || group {
+		var role string
+		if err := h.db.QueryRowContext(ctx, "SELECT role FROM users WHERE id = $1", authorID).Scan(&role); err != nil {
+			return err
+		}
+		allowed, err := h.canModerate(ctx, authorID, role, groupID)
+		if err != nil {
+			return err
+		}
+		here, group = here && allowed, group && allowed
+	}
+
+	room := mentionRoom(topicID, groupID)
+	userIDs, kinds := []string{}, []string{}
+	if len(usernames) > 0 || here || group {
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT u.id, CASE WHEN u.username = ANY($3) THEN 'user' WHEN $5 THEN 'group' ELSE 'here' END
+			FROM users u
+			WHERE `+mentionableSQL(room)+`
+			  AND (u.username = ANY($3) OR $5 OR ($4 AND EXISTS (
+			      SELECT 1 FROM user_status s WHERE s.user_id = u.id AND s.is_online
+			  )))
+		`, authorID, room.id, pq.Array(usernames), here, group)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var userID, kind string
+			if err := rows.Scan(&userID, &kind); err != nil {
+				return err
+			}
+			userIDs = append(userIDs, userID)
+			kinds = append(kinds, kind)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+	}
+
+	_, err := h.db.ExecContext(ctx, `
+		DELETE FROM message_mentions
+		WHERE message_id = $1 AND NOT (user_id = ANY($2::uuid[]))
+	`, messageID, pq.Array(userIDs))
+	if err != nil {
+		return err
+	}
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	res, err := h.db.ExecContext(ctx, `
+		INSERT INTO message_mentions (message_id, user_id, kind)
+		SELECT $1, u.user_id, u.kind
+		FROM unnest($2::uuid[], $3::text[]) AS u(user_id, kind)
+		ON CONFLICT (message_id, user_id) DO NOTHING
+	`, messageID, pq.Array(userIDs), pq.Array(kinds))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+
+	_, err = h.jobs.Enqueue(ctx, MentionNotifyJob, MentionNotifyPayload{MessageID: messageID}, jobs.EnqueueOptions{})
+	return err
+}
+
+// NotifyMentions notifies the users a message mentions who have not been
+// notified of it yet, e.g. those added by an edit.
+func (h *NotificationHandler) NotifyMentions(ctx context.Context, payload json.RawMessage) error {
+	var p MentionNotifyPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	// Claiming rows by setting notified_at keeps concurrent jobs for the
+	// same message from notifying anyone twice
+	rows, err := h.db.QueryContext(ctx, `
+		UPDATE message_mentions mm
+		SET notified_at = CURRENT_TIMESTAMP
+		FROM messages m
+		JOIN users a ON a.id = m.user_id
+		WHERE mm.message_id = $1 AND mm.notified_at IS NULL
+		  AND m.id = mm.message_id AND NOT COALESCE(m.is_deleted, false)
+		RETURNING mm.user_id, mm.kind, COALESCE(a.display_name, a.username), m.content,
+		          m.topic_id, m.group_id, COALESCE(m.parent_id, m.id)
+	`, p.MessageID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type mention struct {
+		userID, kind, author, content, threadID string
+		topicID, groupID                        *string
+	}
+	var mentions []mention
+	for rows.Next() {
+		var m mention
+		if err := rows.Scan(&m.userID, &m.kind, &m.author, &m.content, &m.topicID, &m.groupID, &m.threadID); err != nil {
+			return err
+		}
+		mentions = append(mentions, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, m := range mentions {
+		title := "Вас згадали"
+		if m.kind != mentionUser {
+			title = "Згадка @" + m.kind
+		}
+		link := messageLink(m.topicID, m.groupID, m.threadID)
+		if err := h.CreateNotification(m.userID, "mention", title, m.author+": "+notificationSnippet(m.content), link); err != nil {
+			log.Printf("Failed to notify %s of mention in %s: %v", m.userID, p.MessageID, err)
+		}
+	}
+	return nil
+}
+
+type MentionHandler struct {
+	db *sql.DB
+}
+
+func NewMentionHandler(db *sql.DB) *MentionHandler {
+	return &MentionHandler{db: db}
+}
+
+// Suggest autocompletes "@q" in a topic or group: the users the caller
+// could mention there whose username or display name starts with q.
+func (h *MentionHandler) Suggest(c *gin.Context) {
+	userID := c.GetString("user_id")
+	topicID := c.Query("topic_id")
+	groupID := c.Query("group_id")
+	if (topicID == "") == (groupID == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of topic_id or group_id is required"})
+		return
+	}
+	room := topicReadRoom(topicID)
+	if groupID != "" {
+		room = groupReadRoom(groupID)
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 50 {
+		limit = 10
+	}
+	q := strings.TrimPrefix(strings.TrimSpace(c.Query("q")), "@")
+
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(c.Request.Context(), userID, room.name())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	rows, err := h.db.QueryContext(c.Request.Context(), `
+		SELECT u.id, u.username, COALESCE(u.display_name, u.username), COALESCE(u.avatar_url, '')
+		FROM users u
+		WHERE `+mentionableSQL(room)+`
+		  AND (u.username ILIKE $3 || '%' OR u.display_name ILIKE $3 || '%')
+		ORDER BY u.username
+		LIMIT $4
+	`, userID, room.id, escapeLike(q), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+		return
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suggestions"})
+			return
+		}
+		users = append(users, u)
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,21 +1,30 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"log"
 	"net/http"
+	"psycho-platform/internal/jobs"
 	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/validation"
 	"psycho-platform/internal/websocket"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type MessageHandler struct {
-	db  *sql.DB
-	hub *websocket.Hub
+	db   *sql.DB
+	hub  *websocket.Hub
+	jobs *jobs.Queue
 }
 
-func NewMessageHandler(db *sql.DB, hub *websocket.Hub) *MessageHandler {
-	return &MessageHandler{db: db, hub: hub}
+func NewMessageHandler(db *sql.DB, hub *websocket.Hub, queue *jobs.Queue) *MessageHandler {
+	return &MessageHandler{db: db, hub: hub, jobs: queue}
 }
 
 func (h *MessageHandler) CreateMessage(c *gin.Context) {
@@ -26,76 +35,225 @@ func (h *MessageHandler) CreateMessage(c *gin.Context) {
 		return
 	}
 
+	message, created, err := h.createMessage(c.Request.Context(), userID, req)
+	if err != nil {
+		writeSendError(c, err, "Failed to create message")
+		return
+	}
+
+	status := http.StatusCreated
+	if !created {
+		status = http.StatusOK
+	}
+	c.JSON(status, message)
+}
+
+// HandleSendFrame is the WebSocket counterpart of CreateMessage, registered
+// for send_message frames.
+func (h *MessageHandler) HandleSendFrame(ctx context.Context, userID string, payload json.RawMessage) (interface{}, error) {
+	var req models.CreateMessageRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, &sendError{http.StatusBadRequest, "invalid_payload", "Malformed send_message payload"}
+	}
+	if req.ClientMsgID == "" {
+		return nil, &sendError{http.StatusBadRequest, "invalid_payload", "client_msg_id is required"}
+	}
+
+	message, _, err := h.createMessage(ctx, userID, req)
+	return message, err
+}
+
+// createMessage validates, authorizes and stores a topic or group message
+// and broadcasts it to the room. A retry carrying an already used
+// client_msg_id returns the stored message with created=false and is not
+// broadcast again.
+func (h *MessageHandler) createMessage(ctx context.Context, userID string, req models.CreateMessageRequest) (*models.Message, bool, error) {
+	content := validation.SanitizeString(req.Content)
+	if err := validation.ValidateContent(content, maxMessageLength); err != nil {
+		return nil, false, &sendError{http.StatusBadRequest, "invalid_content", err.Error()}
+	}
+	if len(req.ClientMsgID) > maxClientMsgIDLength {
+		return nil, false, &sendError{http.StatusBadRequest, "invalid_payload", "client_msg_id is too long"}
+	}
+	if (req.TopicID == nil) == (req.GroupID == nil) {
+		return nil, false, &sendError{http.StatusBadRequest, "invalid_target", "Exactly one of topic_id or group_id is required"}
+	}
+	if req.Poll != nil {
+		if req.ParentID != nil {
+			return nil, false, &sendError{http.StatusBadRequest, "invalid_poll", "Polls cannot be thread replies"}
+		}
+		if err := validatePoll(req.Poll, time.Now()); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := h.checkCanPost(ctx, userID, req.TopicID, req.GroupID); err != nil {
+		return nil, false, err
+	}
+
+	for _, refID := range []*string{req.ParentID, req.QuotedMessageID} {
+		if refID == nil {
+			continue
+		}
+		var sameRoom bool
+		err := h.db.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM messages
+				WHERE id = $1
+				  AND topic_id IS NOT DISTINCT FROM $2
+				  AND group_id IS NOT DISTINCT FROM $3
+			)
+		`, *refID, req.TopicID, req.GroupID).Scan(&sameRoom)
+		if err != nil {
+			return nil, false, err
+		}
+		if !sameRoom {
+			return nil, false, &sendError{http.StatusBadRequest, "invalid_reference", "Referenced message is not in this conversation"}
+		}
+	}
+
+	if req.ParentID != nil {
+		// Threads are one level deep: replying to a reply joins its thread
+		var rootID string
+		err := h.db.QueryRowContext(ctx, "SELECT COALESCE(parent_id, id) FROM messages WHERE id = $1", *req.ParentID).Scan(&rootID)
+		if err != nil {
+			return nil, false, err
+		}
+		req.ParentID = &rootID
+	}
+
+	var clientMsgID *string
+	if req.ClientMsgID != "" {
+		clientMsgID = &req.ClientMsgID
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
 	var message models.Message
-	err := h.db.QueryRow(`
-		INSERT INTO messages (content, topic_id, group_id, user_id, parent_id, quoted_message_id)
-		VALUES ($1, $2, $3, $4, $5, $6)
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO messages (content, topic_id, group_id, user_id, parent_id, quoted_message_id, client_msg_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, client_msg_id) WHERE client_msg_id IS NOT NULL DO NOTHING
 		RETURNING id, content, topic_id, group_id, user_id, parent_id, quoted_message_id, is_edited, created_at
-	`, req.Content, req.TopicID, req.GroupID, userID, req.ParentID, req.QuotedMessageID).Scan(
+	`, content, req.TopicID, req.GroupID, userID, req.ParentID, req.QuotedMessageID, clientMsgID).Scan(
 		&message.ID, &message.Content, &message.TopicID, &message.GroupID,
 		&message.UserID, &message.ParentID, &message.QuotedMessageID,
 		&message.IsEdited, &message.CreatedAt,
 	)
-
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		existing, err := h.messageByClientID(ctx, userID, req.ClientMsgID)
+		return existing, false, err
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create message"})
-		return
+		return nil, false, err
+	}
+	if req.Poll != nil {
+		if message.Poll, err = insertPoll(ctx, tx, message.ID, req.Poll); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
 	}
 
 	// Get user info
 	var user models.User
-	h.db.QueryRow("SELECT id, username, display_name, avatar_url FROM users WHERE id = $1", userID).Scan(
+	h.db.QueryRowContext(ctx, "SELECT id, username, COALESCE(display_name, username), COALESCE(avatar_url, '') FROM users WHERE id = $1", userID).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL,
 	)
 	message.User = &user
-
-	// Update message count
-	if req.TopicID != nil {
-		h.db.Exec("UPDATE topics SET messages_count = messages_count + 1 WHERE id = $1", *req.TopicID)
-	}
+	message.ClientMsgID = req.ClientMsgID
+	message.Reactions = []models.ReactionSummary{}
+	message.Attachments = []models.Attachment{}
+	message.LinkPreviews = []models.LinkPreview{}
 
 	// Broadcast via WebSocket
-	roomID := ""
-	if req.TopicID != nil {
-		roomID = "topic_" + *req.TopicID
-	} else if req.GroupID != nil {
-		roomID = "group_" + *req.GroupID
-	}
+	roomID := messageRoom(req.TopicID, req.GroupID)
+	h.hub.BroadcastToRoom(roomID, websocket.NewMessageEvent{Message: &message})
+	h.hub.StopTyping(userID, roomID)
 
-	if roomID != "" {
-		h.hub.BroadcastToRoom(roomID, map[string]interface{}{
-			"type":    "new_message",
-			"payload": message,
-		})
+	if err := h.syncMentions(ctx, message.ID, userID, content, message.TopicID, message.GroupID); err != nil {
+		log.Printf("Failed to store mentions of %s: %v", message.ID, err)
+	}
+	if message.ParentID != nil {
+		h.onThreadReply(ctx, &message)
 	}
+	h.queueLinkPreviews(ctx, message.ID, content, false)
 
-	c.JSON(http.StatusCreated, message)
+	return &message, true, nil
 }
 
-func (h *MessageHandler) GetMessages(c *gin.Context) {
-	topicID := c.Query("topic_id")
-	groupID := c.Query("group_id")
-	limit := c.DefaultQuery("limit", "50")
+// checkCanPost allows posting to public topics, private topics the user
+// created, and groups the user belongs to.
+func (h *MessageHandler) checkCanPost(ctx context.Context, userID string, topicID, groupID *string) error {
+	var exists, allowed bool
+	var err error
+	if topicID != nil {
+		err = h.db.QueryRowContext(ctx, `
+			SELECT true, COALESCE(is_public, true) OR created_by = $2
+			FROM topics WHERE id = $1
+		`, *topicID, userID).Scan(&exists, &allowed)
+	} else {
+		err = h.db.QueryRowContext(ctx, `
+			SELECT true, EXISTS(
+				SELECT 1 FROM group_members WHERE group_id = g.id AND user_id = $2
+			)
+			FROM groups g WHERE g.id = $1
+		`, *groupID, userID).Scan(&exists, &allowed)
+	}
+
+	if err == sql.ErrNoRows {
+		return &sendError{http.StatusNotFound, "not_found", "Topic or group not found"}
+	}
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &sendError{http.StatusForbidden, "forbidden", "You cannot post here"}
+	}
+	return nil
+}
 
-	query := `
-		SELECT m.id, m.content, m.topic_id, m.group_id, m.user_id, m.parent_id,
-		       m.quoted_message_id, m.is_edited, m.edited_at, m.created_at,
-		       u.username, u.display_name, u.avatar_url
+func (h *MessageHandler) messageByClientID(ctx context.Context, userID, clientMsgID string) (*models.Message, error) {
+	var msg models.Message
+	var user models.User
+	err := h.db.QueryRowContext(ctx, `
+		SELECT `+messageColumns+`
 		FROM messages m
 		JOIN users u ON m.user_id = u.id
-		WHERE ($1 = '' OR m.topic_id = $1::uuid)
-		  AND ($2 = '' OR m.group_id = $2::uuid)
-		ORDER BY m.created_at DESC
-		LIMIT $3
-	`
-
-	rows, err := h.db.Query(query, topicID, groupID, limit)
+		WHERE m.user_id = $1 AND m.client_msg_id = $2
+	`, userID, clientMsgID).Scan(
+		&msg.ID, &msg.Content, &msg.TopicID, &msg.GroupID, &msg.UserID,
+		&msg.ParentID, &msg.QuotedMessageID, &msg.IsEdited, &msg.EditedAt,
+		&msg.IsDeleted, &msg.CreatedAt, &user.Username, &user.DisplayName, &user.AvatarURL,
+	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
-		return
+		return nil, err
 	}
-	defer rows.Close()
+	user.ID = msg.UserID
+	msg.User = &user
+	msg.ClientMsgID = clientMsgID
+
+	messages := []models.Message{msg}
+	if err := hydrateMessages(h.db, userID, messages); err != nil {
+		return nil, err
+	}
+	return &messages[0], nil
+}
 
+// messageColumns are the columns read by scanMessages, selected from
+// messages m joined with their author u.
+const messageColumns = `
+	m.id, m.content, m.topic_id, m.group_id, m.user_id, m.parent_id,
+	m.quoted_message_id, m.is_edited, m.edited_at, COALESCE(m.is_deleted, false), m.created_at,
+	u.username, COALESCE(u.display_name, u.username), COALESCE(u.avatar_url, '')`
+
+func scanMessages(rows *sql.Rows) ([]models.Message, error) {
 	messages := []models.Message{}
 	for rows.Next() {
 		var msg models.Message
@@ -103,82 +261,190 @@ func (h *MessageHandler) GetMessages(c *gin.Context) {
 		err := rows.Scan(
 			&msg.ID, &msg.Content, &msg.TopicID, &msg.GroupID, &msg.UserID,
 			&msg.ParentID, &msg.QuotedMessageID, &msg.IsEdited, &msg.EditedAt,
-			&msg.CreatedAt, &user.Username, &user.DisplayName, &user.AvatarURL,
+			&msg.IsDeleted, &msg.CreatedAt, &user.Username, &user.DisplayName, &user.AvatarURL,
 		)
 		if err != nil {
-			continue
+			return nil, err
 		}
 		user.ID = msg.UserID
 		msg.User = &user
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
 
-		// Get reactions
-		reactRows, _ := h.db.Query(`
-			SELECT r.id, r.emoji, r.user_id, u.username, u.display_name
-			FROM reactions r
-			JOIN users u ON r.user_id = u.id
-			WHERE r.message_id = $1
-		`, msg.ID)
-
-		reactions := []models.Reaction{}
-		for reactRows.Next() {
-			var reaction models.Reaction
-			var reactUser models.User
-			reactRows.Scan(&reaction.ID, &reaction.Emoji, &reaction.UserID, &reactUser.Username, &reactUser.DisplayName)
-			reactUser.ID = reaction.UserID
-			reaction.User = &reactUser
-			reactions = append(reactions, reaction)
-		}
-		reactRows.Close()
-		msg.Reactions = reactions
+// GetMessages lists a topic's or group's latest messages. With
+// ?exclude_replies=true thread replies are left out; they are fetched with
+// GetThread.
+func (h *MessageHandler) GetMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+	topicID := c.Query("topic_id")
+	groupID := c.Query("group_id")
+	limit := c.DefaultQuery("limit", "50")
+	excludeReplies := c.Query("exclude_replies") == "true"
 
-		messages = append(messages, msg)
+	rows, err := h.db.Query(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE ($1 = '' OR m.topic_id = $1::uuid)
+		  AND ($2 = '' OR m.group_id = $2::uuid)
+		  AND (NOT $4 OR m.parent_id IS NULL)
+		ORDER BY m.created_at DESC
+		LIMIT $3
+	`, topicID, groupID, limit, excludeReplies)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	messages, err := scanMessages(rows)
+	rows.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read messages"})
+		return
+	}
+
+	if err := hydrateMessages(h.db, userID, messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message details"})
+		return
 	}
 
 	c.JSON(http.StatusOK, messages)
 }
 
+// AddReaction reacts to a message. Reacting twice with the same emoji is a
+// no-op. Groups may restrict which emoji are allowed.
 func (h *MessageHandler) AddReaction(c *gin.Context) {
 	userID := c.GetString("user_id")
 	messageID := c.Param("id")
+	ctx := c.Request.Context()
+
 	var req models.AddReactionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := validation.ValidateEmoji(req.Emoji); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := uuid.Parse(messageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	var topicID, groupID *string
+	var isDeleted, allowed bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT m.topic_id, m.group_id, COALESCE(m.is_deleted, false),
+		       g.allowed_reactions IS NULL OR $2 = ANY(g.allowed_reactions)
+		FROM messages m
+		LEFT JOIN groups g ON g.id = m.group_id
+		WHERE m.id = $1
+	`, messageID, req.Emoji).Scan(&topicID, &groupID, &isDeleted, &allowed)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if isDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message was deleted"})
+		return
+	}
+	room := messageRoom(topicID, groupID)
+	canJoin, err := rooms.NewAuthorizer(h.db).CanJoin(ctx, userID, room)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+		return
+	}
+	if !canJoin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This reaction is not allowed in this group"})
+		return
+	}
 
 	var reactionID string
-	err := h.db.QueryRow(`
+	err = h.db.QueryRowContext(ctx, `
 		INSERT INTO reactions (message_id, user_id, emoji)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (message_id, user_id, emoji) DO NOTHING
 		RETURNING id
 	`, messageID, userID, req.Emoji).Scan(&reactionID)
-
+	if err == sql.ErrNoRows {
+		err = h.db.QueryRowContext(ctx, `
+			SELECT id FROM reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+		`, messageID, userID, req.Emoji).Scan(&reactionID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": reactionID})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add reaction"})
 		return
 	}
 
+	event := websocket.ReactionEvent{MessageID: messageID, UserID: userID, Emoji: req.Emoji}
+	if err := h.db.QueryRowContext(ctx, reactionCountSQL, messageID, req.Emoji).Scan(&event.Count); err == nil {
+		h.hub.BroadcastToRoom(room, websocket.ReactionAddedEvent{ReactionEvent: event})
+	}
+
 	c.JSON(http.StatusCreated, gin.H{"id": reactionID})
 }
 
+const reactionCountSQL = "SELECT COUNT(*) FROM reactions WHERE message_id = $1 AND emoji = $2"
+
+// RemoveReaction withdraws the caller's reaction, if any.
 func (h *MessageHandler) RemoveReaction(c *gin.Context) {
 	userID := c.GetString("user_id")
 	messageID := c.Param("id")
 	emoji := c.Query("emoji")
+	ctx := c.Request.Context()
+
+	if emoji == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "emoji is required"})
+		return
+	}
+	if _, err := uuid.Parse(messageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
 
-	_, err := h.db.Exec("DELETE FROM reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3", messageID, userID, emoji)
+	var topicID, groupID *string
+	err := h.db.QueryRowContext(ctx, `
+		DELETE FROM reactions r
+		USING messages m
+		WHERE r.message_id = $1 AND r.user_id = $2 AND r.emoji = $3 AND m.id = r.message_id
+		RETURNING m.topic_id, m.group_id
+	`, messageID, userID, emoji).Scan(&topicID, &groupID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove reaction"})
 		return
 	}
 
+	event := websocket.ReactionEvent{MessageID: messageID, UserID: userID, Emoji: emoji}
+	if err := h.db.QueryRowContext(ctx, reactionCountSQL, messageID, emoji).Scan(&event.Count); err == nil {
+		h.hub.BroadcastToRoom(messageRoom(topicID, groupID), websocket.ReactionRemovedEvent{ReactionEvent: event})
+	}
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// EditMessage replaces a message's content. Every version is kept in
+// message_revisions, starting with the original on the first edit, so
+// moderators can see what was edited away.
 func (h *MessageHandler) EditMessage(c *gin.Context) {
 	userID := c.GetString("user_id")
 	messageID := c.Param("id")
+	ctx := c.Request.Context()
 
 	var req struct {
 		Content string `json:"content" binding:"required"`
@@ -187,10 +453,29 @@ func (h *MessageHandler) EditMessage(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	content := validation.SanitizeString(req.Content)
+	if err := validation.ValidateContent(content, maxMessageLength); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Verify ownership
-	var ownerID string
-	err := h.db.QueryRow("SELECT user_id FROM messages WHERE id = $1", messageID).Scan(&ownerID)
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Verify ownership; the row lock keeps concurrent edits in order
+	var ownerID, oldContent string
+	var topicID, groupID *string
+	var isDeleted bool
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT user_id, content, topic_id, group_id, COALESCE(is_deleted, false), created_at
+		FROM messages WHERE id = $1
+		FOR UPDATE
+	`, messageID).Scan(&ownerID, &oldContent, &topicID, &groupID, &isDeleted, &createdAt)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
 		return
@@ -200,99 +485,216 @@ func (h *MessageHandler) EditMessage(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot edit other user's message"})
 		return
 	}
+	if isDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message was deleted"})
+		return
+	}
+	if content == oldContent {
+		c.JSON(http.StatusOK, gin.H{"success": true})
+		return
+	}
 
-	_, err = h.db.Exec(`
+	// The original becomes the first revision on the first edit
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_revisions (message_id, content, edited_by, created_at)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (SELECT 1 FROM message_revisions WHERE message_id = $1)
+	`, messageID, oldContent, ownerID, createdAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
+
+	var editedAt time.Time
+	err = tx.QueryRowContext(ctx, `
 		UPDATE messages
 		SET content = $1, is_edited = true, edited_at = CURRENT_TIMESTAMP
 		WHERE id = $2
-	`, req.Content, messageID)
+		RETURNING edited_at
+	`, content, messageID).Scan(&editedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
 
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO message_revisions (message_id, content, edited_by, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, messageID, content, userID, editedAt)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
 		return
 	}
 
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+		return
+	}
+
+	h.hub.BroadcastToRoom(messageRoom(topicID, groupID), websocket.MessageEditedEvent{
+		ID:       messageID,
+		Content:  content,
+		EditedBy: userID,
+		EditedAt: editedAt,
+	})
+	if err := h.syncMentions(ctx, messageID, userID, content, topicID, groupID); err != nil {
+		log.Printf("Failed to store mentions of %s: %v", messageID, err)
+	}
+	h.queueLinkPreviews(ctx, messageID, content, true)
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
-func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+// GetRevisions lists every version of a message, oldest first. Only the
+// author and moderators may see them.
+func (h *MessageHandler) GetRevisions(c *gin.Context) {
 	userID := c.GetString("user_id")
 	messageID := c.Param("id")
+	ctx := c.Request.Context()
 
-	// Verify ownership
 	var ownerID string
-	err := h.db.QueryRow("SELECT user_id FROM messages WHERE id = $1", messageID).Scan(&ownerID)
+	var groupID *string
+	err := h.db.QueryRowContext(ctx, "SELECT user_id, group_id FROM messages WHERE id = $1", messageID).Scan(&ownerID, &groupID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
 		return
 	}
 
 	if ownerID != userID {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete other user's message"})
-		return
+		allowed, err := h.canModerate(ctx, userID, c.GetString("user_role"), groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the author and moderators can view revisions"})
+			return
+		}
 	}
 
-	_, err = h.db.Exec(`
-		UPDATE messages
-		SET is_deleted = true, deleted_at = CURRENT_TIMESTAMP, content = '[Видалено]'
-		WHERE id = $1
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT r.id, r.message_id, r.content, r.edited_by, r.created_at,
+		       COALESCE(u.username, ''), COALESCE(u.display_name, u.username, ''), COALESCE(u.avatar_url, '')
+		FROM message_revisions r
+		LEFT JOIN users u ON r.edited_by = u.id
+		WHERE r.message_id = $1
+		ORDER BY r.created_at, r.id
 	`, messageID)
-
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch revisions"})
 		return
 	}
+	defer rows.Close()
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	revisions := []models.MessageRevision{}
+	for rows.Next() {
+		var rev models.MessageRevision
+		var editor models.User
+		if err := rows.Scan(&rev.ID, &rev.MessageID, &rev.Content, &rev.EditedBy, &rev.CreatedAt,
+			&editor.Username, &editor.DisplayName, &editor.AvatarURL); err != nil {
+			continue
+		}
+		if rev.EditedBy != nil {
+			editor.ID = *rev.EditedBy
+			rev.Editor = &editor
+		}
+		revisions = append(revisions, rev)
+	}
+
+	c.JSON(http.StatusOK, revisions)
+}
+
+// canModerate reports whether userID moderates messages in groupID, or in
+// topics when groupID is nil: super admins and site moderators moderate
+// everywhere, group admins and moderators their group.
+func (h *MessageHandler) canModerate(ctx context.Context, userID, role string, groupID *string) (bool, error) {
+	if role == "super_admin" || role == "moderator" {
+		return true, nil
+	}
+	if groupID == nil {
+		return false, nil
+	}
+
+	var allowed bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM group_members
+			WHERE group_id = $1 AND user_id = $2 AND role IN ('admin', 'moderator')
+		)
+	`, *groupID, userID).Scan(&allowed)
+	return allowed, err
+}
+
+// messageRoom names the hub room of a topic or group message.
+func messageRoom(topicID, groupID *string) string {
+	if topicID != nil {
+		return rooms.Topic(*topicID)
+	}
+	return rooms.Group(*groupID)
 }
 
+// MarkAsRead advances the caller's read cursor in the message's topic or
+// group up to the message.
 func (h *MessageHandler) MarkAsRead(c *gin.Context) {
 	userID := c.GetString("user_id")
 	messageID := c.Param("id")
+	ctx := c.Request.Context()
 
-	_, err := h.db.Exec(`
-		INSERT INTO message_read_receipts (message_id, user_id)
-		VALUES ($1, $2)
-		ON CONFLICT (message_id, user_id) DO NOTHING
-	`, messageID, userID)
+	var topicID, groupID sql.NullString
+	err := h.db.QueryRowContext(ctx, "SELECT topic_id, group_id FROM messages WHERE id = $1", messageID).Scan(&topicID, &groupID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
+		return
+	}
 
+	room := topicReadRoom(topicID.String)
+	if !topicID.Valid {
+		room = groupReadRoom(groupID.String)
+	}
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(ctx, userID, room.name())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
 		return
 	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	if _, err := advanceReadCursor(ctx, h.db, h.hub, userID, room, messageID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// StartTyping is the REST fallback for the WebSocket typing frame. The
+// indicator expires unless repeated, so clients need not call StopTyping.
 func (h *MessageHandler) StartTyping(c *gin.Context) {
 	userID := c.GetString("user_id")
 	roomID := c.Query("room")
 
-	if roomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "room parameter required"})
+	if !websocket.TypingRoom(roomID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room must be a topic, group or conversation"})
 		return
 	}
 
-	_, err := h.db.Exec(`
-		INSERT INTO typing_indicators (user_id, room_id, started_at)
-		VALUES ($1, $2, CURRENT_TIMESTAMP)
-		ON CONFLICT (user_id, room_id)
-		DO UPDATE SET started_at = CURRENT_TIMESTAMP
-	`, userID, roomID)
-
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(c.Request.Context(), userID, roomID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update typing status"})
 		return
 	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
 
-	// Broadcast typing indicator
-	h.hub.BroadcastToRoom(roomID, map[string]interface{}{
-		"type": "typing",
-		"payload": map[string]interface{}{
-			"user_id":  userID,
-			"is_typing": true,
-		},
-	})
+	h.hub.StartTyping(userID, roomID)
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -301,24 +703,22 @@ func (h *MessageHandler) StopTyping(c *gin.Context) {
 	userID := c.GetString("user_id")
 	roomID := c.Query("room")
 
-	if roomID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "room parameter required"})
+	if !websocket.TypingRoom(roomID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "room must be a topic, group or conversation"})
 		return
 	}
 
-	h.db.Exec(`
-		DELETE FROM typing_indicators
-		WHERE user_id = $1 AND room_id = $2
-	`, userID, roomID)
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(c.Request.Context(), userID, roomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update typing status"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
 
-	// Broadcast stop typing
-	h.hub.BroadcastToRoom(roomID, map[string]interface{}{
-		"type": "typing",
-		"payload": map[string]interface{}{
-			"user_id":  userID,
-			"is_typing": false,
-		},
-	})
+	h.hub.StopTyping(userID, roomID)
 
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"database/sql"
+	"psycho-platform/internal/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// reactionSampleSize is the number of reacting users returned with every
+// reaction summary, enough for a "Anna, Petro and 5 others" tooltip.
+const reactionSampleSize = 3
+
+// hydrateMessages fills reactions, attachments, reply counts and times and
+// quoted messages for a page of messages. It issues a fixed number of queries
+// regardless of the page size.
+func hydrateMessages(db *sql.DB, userID string, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(messages))
+	index := make(map[string]int, len(messages))
+	quotedIDs := []string{}
+	for i := range messages {
+		messages[i].Reactions = []models.ReactionSummary{}
+		messages[i].Attachments = []models.Attachment{}
+		messages[i].LinkPreviews = []models.LinkPreview{}
+		ids = append(ids, messages[i].ID)
+		index[messages[i].ID] = i
+		if messages[i].QuotedMessageID != nil {
+			quotedIDs = append(quotedIDs, *messages[i].QuotedMessageID)
+		}
+	}
+
+	if err := loadReactionSummaries(db, userID, ids, messages, index); err != nil {
+		return err
+	}
+	if err := loadAttachments(db, ids, messages, index); err != nil {
+		return err
+	}
+	if err := loadLinkPreviews(db, ids, messages, index); err != nil {
+		return err
+	}
+	if err := loadPolls(db, userID, ids, messages, index); err != nil {
+		return err
+	}
+	if err := loadReplyCounts(db, ids, messages, index); err != nil {
+		return err
+	}
+	return loadQuotedMessages(db, quotedIDs, messages)
+}
+
+func loadReactionSummaries(db *sql.DB, userID string, ids []string, messages []models.Message, index map[string]int) error {
+	rows, err := db.Query(`
+		SELECT r.message_id, r.emoji, COUNT(*),
+		       BOOL_OR(r.user_id::text = $2),
+		       (ARRAY_AGG(u.id::text ORDER BY r.created_at))[1:$3],
+		       (ARRAY_AGG(u.username ORDER BY r.created_at))[1:$3],
+		       (ARRAY_AGG(COALESCE(u.display_name, u.username) ORDER BY r.created_at))[1:$3]
+		FROM reactions r
+		JOIN users u ON r.user_id = u.id
+		WHERE r.message_id = ANY($1::uuid[])
+		GROUP BY r.message_id, r.emoji
+		ORDER BY MIN(r.created_at)
+	`, pq.Array(ids), userID, reactionSampleSize)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID string
+		var summary models.ReactionSummary
+		var userIDs, usernames, displayNames []string
+		if err := rows.Scan(
+			&messageID, &summary.Emoji, &summary.Count, &summary.ReactedByMe,
+			pq.Array(&userIDs), pq.Array(&usernames), pq.Array(&displayNames),
+		); err != nil {
+			return err
+		}
+
+		summary.SampleUsers = make([]models.User, 0, len(userIDs))
+		for i := range userIDs {
+			summary.SampleUsers = append(summary.SampleUsers, models.User{
+				ID:          userIDs[i],
+				Username:    usernames[i],
+				DisplayName: displayNames[i],
+			})
+		}
+
+		msg := &messages[index[messageID]]
+		msg.Reactions = append(msg.Reactions, summary)
+	}
+
+	return rows.Err()
+}
+
+func loadAttachments(db *sql.DB, ids []string, messages []models.Message, index map[string]int) error {
+	rows, err := db.Query(`
+		SELECT id, message_id, original_name, file_type, file_size, file_url, created_at
+		FROM file_attachments
+		WHERE message_id = ANY($1::uuid[])
+		ORDER BY created_at ASC
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.Attachment
+		if err := rows.Scan(
+			&a.ID, &a.MessageID, &a.OriginalName, &a.FileType,
+			&a.FileSize, &a.FileURL, &a.CreatedAt,
+		); err != nil {
+			return err
+		}
+
+		msg := &messages[index[a.MessageID]]
+		msg.Attachments = append(msg.Attachments, a)
+	}
+
+	return rows.Err()
+}
+
+func loadReplyCounts(db *sql.DB, ids []string, messages []models.Message, index map[string]int) error {
+	rows, err := db.Query(`
+		SELECT parent_id, COUNT(*), MAX(created_at)
+		FROM messages
+		WHERE parent_id = ANY($1::uuid[]) AND is_deleted = false
+		GROUP BY parent_id
+	`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parentID string
+		var count int
+		var lastReplyAt time.Time
+		if err := rows.Scan(&parentID, &count, &lastReplyAt); err != nil {
+			return err
+		}
+		messages[index[parentID]].ReplyCount = count
+		messages[index[parentID]].LastReplyAt = &lastReplyAt
+	}
+
+	return rows.Err()
+}
+
+func loadQuotedMessages(db *sql.DB, quotedIDs []string, messages []models.Message) error {
+	if len(quotedIDs) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`
+		SELECT m.id, m.content, m.topic_id, m.group_id, m.user_id, m.is_edited, m.created_at,
+		       u.username, COALESCE(u.display_name, u.username), COALESCE(u.avatar_url, '')
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.id = ANY($1::uuid[])
+	`, pq.Array(quotedIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	quoted := make(map[string]*models.Message, len(quotedIDs))
+	for rows.Next() {
+		var q models.Message
+		var user models.User
+		if err := rows.Scan(
+			&q.ID, &q.Content, &q.TopicID, &q.GroupID, &q.UserID, &q.IsEdited, &q.CreatedAt,
+			&user.Username, &user.DisplayName, &user.AvatarURL,
+		); err != nil {
+			return err
+		}
+		user.ID = q.UserID
+		q.User = &user
+		quoted[q.ID] = &q
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range messages {
+		if messages[i].QuotedMessageID != nil {
+			messages[i].QuotedMessage = quoted[*messages[i].QuotedMessageID]
+		}
+	}
+
+	return nil
+}
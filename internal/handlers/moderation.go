@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/validation"
+	"psycho-platform/internal/websocket"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// deletedPlaceholder replaces a deleted message's content; the original
+	// is kept in message_deletions.
+	deletedPlaceholder = "[Видалено]"
+	maxReasonLength    = 500
+	// maxBulkDelete caps the messages removed by one bulk deletion.
+	maxBulkDelete = 1000
+	// maxBulkDeleteHours is how far back a bulk deletion may reach.
+	maxBulkDeleteHours = 7 * 24
+)
+
+type DeleteMessageRequest struct {
+	Reason string `json:"reason"`
+}
+
+type BulkDeleteRequest struct {
+	UserID  string  `json:"user_id" binding:"required"`
+	TopicID *string `json:"topic_id"`
+	GroupID *string `json:"group_id"`
+	Hours   int     `json:"hours"`
+	Reason  string  `json:"reason" binding:"required"`
+}
+
+// moderationReason sanitizes and bounds a deletion reason, returning nil
+// for an empty one.
+func moderationReason(reason string) (*string, bool) {
+	reason = validation.SanitizeString(reason)
+	if reason == "" {
+		return nil, true
+	}
+	if utf8.RuneCountInString(reason) > maxReasonLength {
+		return nil, false
+	}
+	return &reason, true
+}
+
+// softDeleteMessages deletes the live messages matching where, replacing
+// their content with deletedPlaceholder after saving it with the reason in
+// message_deletions, and tells their rooms. Placeholders in where start at
+// $3.
+func (h *MessageHandler) softDeleteMessages(ctx context.Context, deletedBy string, reason *string, moderated bool, where string, args ...interface{}) ([]string, error) {
+	rows, err := h.db.QueryContext(ctx, `
+		WITH target AS (
+			SELECT id, content FROM messages m
+			WHERE NOT COALESCE(m.is_deleted, false) AND `+where+`
+			FOR UPDATE
+		), saved AS (
+			INSERT INTO message_deletions (message_id, content, reason, deleted_by)
+			SELECT id, content, $2, $1 FROM target
+			ON CONFLICT (message_id) DO UPDATE
+			SET content = EXCLUDED.content, reason = EXCLUDED.reason,
+			    deleted_by = EXCLUDED.deleted_by, deleted_at = CURRENT_TIMESTAMP
+		)
+		UPDATE messages m
+		SET is_deleted = true, deleted_at = CURRENT_TIMESTAMP, content = '`+deletedPlaceholder+`'
+		FROM target t
+		WHERE m.id = t.id
+		RETURNING m.id, m.topic_id, m.group_id, m.deleted_at
+	`, append([]interface{}{deletedBy, reason}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type deleted struct {
+		room  string
+		event websocket.MessageDeletedEvent
+	}
+	ids := []string{}
+	var events []deleted
+	for rows.Next() {
+		var topicID, groupID *string
+		event := websocket.MessageDeletedEvent{Moderated: moderated}
+		if err := rows.Scan(&event.ID, &topicID, &groupID, &event.DeletedAt); err != nil {
+			return nil, err
+		}
+		ids = append(ids, event.ID)
+		events = append(events, deleted{messageRoom(topicID, groupID), event})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, e := range events {
+		h.hub.BroadcastToRoom(e.room, e.event)
+	}
+	return ids, nil
+}
+
+// DeleteMessage soft-deletes a message. Authors may delete their own;
+// moderators of its room may delete anyone's but must give a reason.
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	messageID := c.Param("id")
+	ctx := c.Request.Context()
+
+	var req DeleteMessageRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	reason, ok := moderationReason(req.Reason)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason is too long"})
+		return
+	}
+	if _, err := uuid.Parse(messageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	var ownerID string
+	var groupID *string
+	var isDeleted bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT user_id, group_id, COALESCE(is_deleted, false) FROM messages WHERE id = $1
+	`, messageID).Scan(&ownerID, &groupID, &isDeleted)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if isDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message was deleted"})
+		return
+	}
+
+	moderated := ownerID != userID
+	if moderated {
+		allowed, err := h.canModerate(ctx, userID, c.GetString("user_role"), groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Cannot delete other user's message"})
+			return
+		}
+		if reason == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to delete another user's message"})
+			return
+		}
+	}
+
+	ids, err := h.softDeleteMessages(ctx, userID, reason, moderated, "m.id = $3", messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+		return
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message was deleted"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// BulkDeleteMessages removes a user's messages from the last hours (24 by
+// default), in one group or topic or, for site moderators, everywhere.
+func (h *MessageHandler) BulkDeleteMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	var req BulkDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := uuid.Parse(req.UserID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+	if req.TopicID != nil && req.GroupID != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At most one of topic_id or group_id is allowed"})
+		return
+	}
+	for _, id := range []*string{req.TopicID, req.GroupID} {
+		if id == nil {
+			continue
+		}
+		if _, err := uuid.Parse(*id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid topic_id or group_id"})
+			return
+		}
+	}
+	if req.Hours == 0 {
+		req.Hours = 24
+	}
+	if req.Hours < 0 || req.Hours > maxBulkDeleteHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hours must be between 1 and 168"})
+		return
+	}
+	reason, ok := moderationReason(req.Reason)
+	if !ok || reason == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason of up to 500 characters is required"})
+		return
+	}
+
+	// Group moderators purge their group; topics and the whole site are
+	// left to site moderators
+	allowed, err := h.canModerate(ctx, userID, c.GetString("user_role"), req.GroupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete messages"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Moderator access required"})
+		return
+	}
+
+	ids, err := h.softDeleteMessages(ctx, userID, reason, true, `m.id IN (
+		SELECT id FROM messages
+		WHERE user_id = $3
+		  AND created_at > NOW() - $4 * INTERVAL '1 hour'
+		  AND ($5::uuid IS NULL OR topic_id = $5)
+		  AND ($6::uuid IS NULL OR group_id = $6)
+		  AND NOT COALESCE(is_deleted, false)
+		ORDER BY created_at DESC
+		LIMIT $7
+	)`, req.UserID, req.Hours, req.TopicID, req.GroupID, maxBulkDelete)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete messages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted": len(ids), "ids": ids})
+}
+
+// RestoreMessage undoes a deletion, putting the saved content back.
+// Only moderators of the message's room may restore.
+func (h *MessageHandler) RestoreMessage(c *gin.Context) {
+	messageID := c.Param("id")
+	ctx := c.Request.Context()
+
+	if !h.authorizeModeration(c, messageID) {
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore message"})
+		return
+	}
+	defer tx.Rollback()
+
+	var content string
+	var topicID, groupID *string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE messages m
+		SET content = d.content, is_deleted = false, deleted_at = NULL
+		FROM message_deletions d
+		WHERE m.id = $1 AND d.message_id = m.id AND m.is_deleted
+		RETURNING m.content, m.topic_id, m.group_id
+	`, messageID).Scan(&content, &topicID, &groupID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message is not deleted or cannot be restored"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore message"})
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM message_deletions WHERE message_id = $1", messageID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore message"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore message"})
+		return
+	}
+
+	h.hub.BroadcastToRoom(messageRoom(topicID, groupID), websocket.MessageRestoredEvent{
+		ID:      messageID,
+		Content: content,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// GetDeletion shows moderators what a deleted message said and why it
+// was removed.
+func (h *MessageHandler) GetDeletion(c *gin.Context) {
+	messageID := c.Param("id")
+
+	if !h.authorizeModeration(c, messageID) {
+		return
+	}
+
+	var d models.MessageDeletion
+	err := h.db.QueryRowContext(c.Request.Context(), `
+		SELECT message_id, content, reason, deleted_by, deleted_at
+		FROM message_deletions WHERE message_id = $1
+	`, messageID).Scan(&d.MessageID, &d.Content, &d.Reason, &d.DeletedBy, &d.DeletedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message is not deleted"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deletion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// authorizeModeration checks the caller moderates the room of messageID,
+// answering the request on failure.
+func (h *MessageHandler) authorizeModeration(c *gin.Context, messageID string) bool {
+	if _, err := uuid.Parse(messageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return false
+	}
+
+	var groupID *string
+	err := h.db.QueryRowContext(c.Request.Context(), "SELECT group_id FROM messages WHERE id = $1", messageID).Scan(&groupID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return false
+	}
+
+	allowed, err := h.canModerate(c.Request.Context(), c.GetString("user_id"), c.GetString("user_role"), groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Moderator access required"})
+		return false
+	}
+	return true
+}
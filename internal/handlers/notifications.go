@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"net/http"
 	"psycho-platform/internal/websocket"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
@@ -125,6 +126,25 @@ func (h *NotificationHandler) DeleteNotification(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// notificationSnippetLength caps the message excerpt in a notification, in
+// runes.
+const notificationSnippetLength = 100
+
+func notificationSnippet(content string) string {
+	if utf8.RuneCountInString(content) > notificationSnippetLength {
+		return string([]rune(content)[:notificationSnippetLength]) + "…"
+	}
+	return content
+}
+
+// messageLink points a notification at a topic or group message's thread.
+func messageLink(topicID, groupID *string, threadID string) string {
+	if groupID != nil {
+		return "/groups/" + *groupID + "?thread=" + threadID
+	}
+	return "/topics/" + *topicID + "?thread=" + threadID
+}
+
 // Helper function to create notification
 func (h *NotificationHandler) CreateNotification(userID, notifType, title, content, link string) error {
 	var notifID string
@@ -139,15 +159,12 @@ func (h *NotificationHandler) CreateNotification(userID, notifType, title, conte
 	}
 
 	// Send via WebSocket
-	h.hub.BroadcastToRoom("user_"+userID, map[string]interface{}{
-		"type": "notification",
-		"payload": map[string]interface{}{
-			"id":      notifID,
-			"type":    notifType,
-			"title":   title,
-			"content": content,
-			"link":    link,
-		},
+	h.hub.BroadcastToRoom("user_"+userID, websocket.NotificationEvent{
+		ID:      notifID,
+		Type:    notifType,
+		Title:   title,
+		Content: content,
+		Link:    link,
 	})
 
 	return nil
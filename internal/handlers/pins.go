@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// maxPins caps the pinned messages of one topic or group.
+const maxPins = 50
+
+type PinMessageRequest struct {
+	// Position is where the pin goes in the room's list, from 0; it is
+	// appended when omitted. Pinning a pinned message moves it.
+	Position *int `json:"position"`
+}
+
+// canManageRoom reports whether userID may pin messages and schedule
+// recurring ones in a room: moderators of the group, or the topic's
+// creator and site moderators.
+func (h *MessageHandler) canManageRoom(ctx context.Context, userID, role string, topicID, groupID *string) (bool, error) {
+	if topicID != nil {
+		var owner bool
+		err := h.db.QueryRowContext(ctx, "SELECT created_by = $2 FROM topics WHERE id = $1", *topicID, userID).Scan(&owner)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		if owner {
+			return true, nil
+		}
+	}
+	return h.canModerate(ctx, userID, role, groupID)
+}
+
+// PinMessage pins a topic or group message, or moves it within the pins.
+func (h *MessageHandler) PinMessage(c *gin.Context) {
+	h.setPinned(c, true)
+}
+
+// UnpinMessage removes a message from its room's pins.
+func (h *MessageHandler) UnpinMessage(c *gin.Context) {
+	h.setPinned(c, false)
+}
+
+func (h *MessageHandler) setPinned(c *gin.Context, pin bool) {
+	userID := c.GetString("user_id")
+	messageID := c.Param("id")
+	ctx := c.Request.Context()
+
+	var req PinMessageRequest
+	if pin && c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Position != nil && *req.Position < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position must not be negative"})
+		return
+	}
+	if _, err := uuid.Parse(messageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+
+	var topicID, groupID *string
+	var isDeleted bool
+	err := h.db.QueryRowContext(ctx, `
+		SELECT topic_id, group_id, COALESCE(is_deleted, false) FROM messages WHERE id = $1
+	`, messageID).Scan(&topicID, &groupID, &isDeleted)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if pin && isDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message was deleted"})
+		return
+	}
+
+	allowed, err := h.canManageRoom(ctx, userID, c.GetString("user_role"), topicID, groupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pins"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only moderators and the topic owner can pin messages"})
+		return
+	}
+
+	room := mentionRoom(topicID, groupID)
+	pins, err := h.updatePins(ctx, room, func(pins []string) ([]string, error) {
+		pins = removePin(pins, messageID)
+		if !pin {
+			return pins, nil
+		}
+		if len(pins) >= maxPins {
+			return nil, errTooManyPins
+		}
+		at := len(pins)
+		if req.Position != nil && *req.Position < at {
+			at = *req.Position
+		}
+		pins = append(pins[:at], append([]string{messageID}, pins[at:]...)...)
+		return pins, nil
+	}, userID)
+	if err == errTooManyPins {
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many pinned messages"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pins"})
+		return
+	}
+
+	if pin {
+		h.hub.BroadcastToRoom(room.name(), websocket.MessagePinnedEvent{ID: messageID, PinnedBy: userID, Pins: pins})
+	} else {
+		h.hub.BroadcastToRoom(room.name(), websocket.MessageUnpinnedEvent{ID: messageID, Pins: pins})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pins": pins})
+}
+
+var errTooManyPins = errors.New("too many pinned messages")
+
+func removePin(pins []string, messageID string) []string {
+	for i, id := range pins {
+		if id == messageID {
+			return append(pins[:i], pins[i+1:]...)
+		}
+	}
+	return pins
+}
+
+// updatePins rewrites a room's pin order with edit, which receives the
+// current order; pins it adds are recorded as pinned by pinnedBy. The
+// room's row is locked so concurrent edits apply one after the other.
+func (h *MessageHandler) updatePins(ctx context.Context, room readRoom, edit func([]string) ([]string, error), pinnedBy string) ([]string, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	table := "topics"
+	if room.column == "group_id" {
+		table = "groups"
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM "+table+" WHERE id = $1 FOR UPDATE", room.id); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT message_id FROM message_pins WHERE `+room.column+` = $1 ORDER BY position
+	`, room.id)
+	if err != nil {
+		return nil, err
+	}
+	pins := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pins = append(pins, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pins, err = edit(pins)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM message_pins WHERE `+room.column+` = $1 AND message_id <> ALL($2::uuid[])
+	`, room.id, pq.Array(pins))
+	if err != nil {
+		return nil, err
+	}
+	for position, id := range pins {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO message_pins (message_id, `+room.column+`, pinned_by, position)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (message_id) DO UPDATE SET position = EXCLUDED.position
+		`, id, room.id, pinnedBy, position)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pins, nil
+}
+
+// GetTopicPins lists a topic's pinned messages in order.
+func (h *MessageHandler) GetTopicPins(c *gin.Context) {
+	h.getPins(c, topicReadRoom(c.Param("id")))
+}
+
+// GetGroupPins lists a group's pinned messages in order.
+func (h *MessageHandler) GetGroupPins(c *gin.Context) {
+	h.getPins(c, groupReadRoom(c.Param("id")))
+}
+
+func (h *MessageHandler) getPins(c *gin.Context, room readRoom) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(ctx, userID, room.name())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pins"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	// Pins of deleted messages are hidden, and come back on restore
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+messageColumns+`, p.position, p.pinned_by, p.pinned_at
+		FROM message_pins p
+		JOIN messages m ON m.id = p.message_id
+		JOIN users u ON m.user_id = u.id
+		WHERE p.`+room.column+` = $1 AND NOT COALESCE(m.is_deleted, false)
+		ORDER BY p.position
+	`, room.id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pins"})
+		return
+	}
+	defer rows.Close()
+
+	pins := []models.PinnedMessage{}
+	messages := []models.Message{}
+	for rows.Next() {
+		var msg models.Message
+		var user models.User
+		var pin models.PinnedMessage
+		err := rows.Scan(
+			&msg.ID, &msg.Content, &msg.TopicID, &msg.GroupID, &msg.UserID,
+			&msg.ParentID, &msg.QuotedMessageID, &msg.IsEdited, &msg.EditedAt,
+			&msg.IsDeleted, &msg.CreatedAt, &user.Username, &user.DisplayName, &user.AvatarURL,
+			&pin.Position, &pin.PinnedBy, &pin.PinnedAt,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read pins"})
+			return
+		}
+		user.ID = msg.UserID
+		msg.User = &user
+		pins = append(pins, pin)
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read pins"})
+		return
+	}
+	rows.Close()
+
+	if err := hydrateMessages(h.db, userID, messages); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message details"})
+		return
+	}
+	for i := range pins {
+		pins[i].Message = messages[i]
+	}
+
+	c.JSON(http.StatusOK, pins)
+}
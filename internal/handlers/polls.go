@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/validation"
+	"psycho-platform/internal/websocket"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// PollCloseJob closes the polls whose close time passed and pushes their
+// final results.
+const PollCloseJob = "polls.close"
+
+const (
+	minPollOptions      = 2
+	maxPollOptions      = 10
+	maxPollOptionLength = 200
+)
+
+// pollClosedSQL is true for a closed poll p, by hand or by its close time.
+const pollClosedSQL = `(p.closed_at IS NOT NULL OR COALESCE(p.closes_at <= NOW(), false))`
+
+// validatePoll checks a poll's settings and normalises its options.
+func validatePoll(req *models.CreatePollRequest, now time.Time) error {
+	options := make([]string, 0, len(req.Options))
+	seen := make(map[string]bool)
+	for _, option := range req.Options {
+		option = strings.TrimSpace(validation.SanitizeString(option))
+		if option == "" || utf8.RuneCountInString(option) > maxPollOptionLength {
+			return &sendError{http.StatusBadRequest, "invalid_poll", "Poll options must be 1 to 200 characters"}
+		}
+		if seen[option] {
+			return &sendError{http.StatusBadRequest, "invalid_poll", "Poll options must be distinct"}
+		}
+		seen[option] = true
+		options = append(options, option)
+	}
+	if len(options) < minPollOptions || len(options) > maxPollOptions {
+		return &sendError{http.StatusBadRequest, "invalid_poll", "A poll needs 2 to 10 options"}
+	}
+	req.Options = options
+
+	switch req.Results {
+	case "":
+		req.Results = models.PollResultsAlways
+	case models.PollResultsAlways, models.PollResultsAfterVote, models.PollResultsAfterClose:
+	default:
+		return &sendError{http.StatusBadRequest, "invalid_poll", "results must be always, after_vote or after_close"}
+	}
+
+	if req.ClosesAt != nil && (!req.ClosesAt.After(now) || req.ClosesAt.After(now.Add(maxScheduleAhead))) {
+		return &sendError{http.StatusBadRequest, "invalid_poll", "closes_at must be in the future and within a year"}
+	}
+	return nil
+}
+
+// insertPoll attaches the poll req to a message being created in tx.
+func insertPoll(ctx context.Context, tx *sql.Tx, messageID string, req *models.CreatePollRequest) (*models.Poll, error) {
+	poll := models.Poll{
+		MessageID:      messageID,
+		MultipleChoice: req.MultipleChoice,
+		Anonymous:      req.Anonymous,
+		Results:        req.Results,
+		ClosesAt:       req.ClosesAt,
+		MyVotes:        []string{},
+		Options:        []models.PollOption{},
+	}
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO polls (message_id, multiple_choice, anonymous, results, closes_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`, messageID, poll.MultipleChoice, poll.Anonymous, poll.Results, poll.ClosesAt).Scan(&poll.ID, &poll.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for position, text := range req.Options {
+		option := models.PollOption{Text: text, Votes: new(int)}
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO poll_options (poll_id, text, position) VALUES ($1, $2, $3) RETURNING id
+		`, poll.ID, text, position).Scan(&option.ID)
+		if err != nil {
+			return nil, err
+		}
+		poll.Options = append(poll.Options, option)
+	}
+
+	redactPoll(&poll)
+	return &poll, nil
+}
+
+// queryPolls loads the polls p matching where, whose placeholders start at
+// $2, with every option's votes and userID's own votes.
+func queryPolls(ctx context.Context, db *sql.DB, userID, where string, arg interface{}) ([]models.Poll, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.id, p.message_id, p.multiple_choice, p.anonymous, p.results,
+		       p.closes_at, p.closed_at, `+pollClosedSQL+`,
+		       (SELECT COUNT(DISTINCT v.user_id) FROM poll_votes v WHERE v.poll_id = p.id),
+		       ARRAY(SELECT v.option_id::text FROM poll_votes v WHERE v.poll_id = p.id AND v.user_id::text = $1),
+		       p.created_at
+		FROM polls p
+		WHERE `+where, userID, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	polls := []models.Poll{}
+	ids := []string{}
+	index := make(map[string]int)
+	for rows.Next() {
+		var p models.Poll
+		if err := rows.Scan(
+			&p.ID, &p.MessageID, &p.MultipleChoice, &p.Anonymous, &p.Results,
+			&p.ClosesAt, &p.ClosedAt, &p.IsClosed, &p.TotalVoters, pq.Array(&p.MyVotes),
+			&p.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if p.MyVotes == nil {
+			p.MyVotes = []string{}
+		}
+		p.Options = []models.PollOption{}
+		index[p.ID] = len(polls)
+		ids = append(ids, p.ID)
+		polls = append(polls, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(polls) == 0 {
+		return polls, nil
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT o.poll_id, o.id, o.text, COUNT(v.user_id)
+		FROM poll_options o
+		LEFT JOIN poll_votes v ON v.option_id = o.id
+		WHERE o.poll_id = ANY($1::uuid[])
+		GROUP BY o.id
+		ORDER BY o.position
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pollID string
+		var option models.PollOption
+		var votes int
+		if err := rows.Scan(&pollID, &option.ID, &option.Text, &votes); err != nil {
+			return nil, err
+		}
+		option.Votes = &votes
+		p := &polls[index[pollID]]
+		p.Options = append(p.Options, option)
+	}
+	return polls, rows.Err()
+}
+
+// redactPoll decides whether the poll's reader, whose votes are in
+// MyVotes, may see the results, and hides them otherwise.
+func redactPoll(p *models.Poll) {
+	switch {
+	case p.IsClosed, p.Results == models.PollResultsAlways:
+		p.ResultsVisible = true
+	case p.Results == models.PollResultsAfterVote:
+		p.ResultsVisible = len(p.MyVotes) > 0
+	default:
+		p.ResultsVisible = false
+	}
+	if !p.ResultsVisible {
+		for i := range p.Options {
+			p.Options[i].Votes = nil
+			p.Options[i].Voters = nil
+		}
+	}
+}
+
+func loadPolls(db *sql.DB, userID string, ids []string, messages []models.Message, index map[string]int) error {
+	polls, err := queryPolls(context.Background(), db, userID, "p.message_id = ANY($2::uuid[])", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	for i := range polls {
+		msg := &messages[index[polls[i].MessageID]]
+		if msg.IsDeleted {
+			continue
+		}
+		redactPoll(&polls[i])
+		msg.Poll = &polls[i]
+	}
+	return nil
+}
+
+// pollTarget is the message a poll belongs to.
+type pollTarget struct {
+	messageID, authorID string
+	topicID, groupID    *string
+	isDeleted           bool
+}
+
+func (h *MessageHandler) pollTarget(ctx context.Context, pollID string) (*pollTarget, error) {
+	if _, err := uuid.Parse(pollID); err != nil {
+		return nil, sql.ErrNoRows
+	}
+	var t pollTarget
+	err := h.db.QueryRowContext(ctx, `
+		SELECT m.id, m.user_id, m.topic_id, m.group_id, COALESCE(m.is_deleted, false)
+		FROM polls p
+		JOIN messages m ON m.id = p.message_id
+		WHERE p.id = $1
+	`, pollID).Scan(&t.messageID, &t.authorID, &t.topicID, &t.groupID, &t.isDeleted)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// loadVotablePoll resolves the poll of a vote request and checks that the
+// caller may vote in its room, writing the error response otherwise.
+func (h *MessageHandler) loadVotablePoll(c *gin.Context, pollID string) (*pollTarget, bool) {
+	target, err := h.pollTarget(c.Request.Context(), pollID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return nil, false
+	}
+	if target.isDeleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Message was deleted"})
+		return nil, false
+	}
+	// Voting is for those who can post in the room
+	if err := h.checkCanPost(c.Request.Context(), c.GetString("user_id"), target.topicID, target.groupID); err != nil {
+		writeSendError(c, err, "Failed to vote")
+		return nil, false
+	}
+	return target, true
+}
+
+// VotePoll sets the caller's votes in a poll, replacing earlier ones.
+func (h *MessageHandler) VotePoll(c *gin.Context) {
+	userID := c.GetString("user_id")
+	pollID := c.Param("id")
+	ctx := c.Request.Context()
+
+	var req models.PollVoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	optionIDs := []string{}
+	seen := make(map[string]bool)
+	for _, id := range req.OptionIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown poll option"})
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			optionIDs = append(optionIDs, id)
+		}
+	}
+	if len(optionIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "option_ids must not be empty"})
+		return
+	}
+
+	target, ok := h.loadVotablePoll(c, pollID)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Locking the poll orders votes against each other and closing
+	var multipleChoice, closed bool
+	var known int
+	err = tx.QueryRowContext(ctx, `
+		SELECT p.multiple_choice, `+pollClosedSQL+`,
+		       (SELECT COUNT(*) FROM poll_options o WHERE o.poll_id = p.id AND o.id = ANY($2::uuid[]))
+		FROM polls p
+		WHERE p.id = $1
+		FOR UPDATE
+	`, pollID, pq.Array(optionIDs)).Scan(&multipleChoice, &closed, &known)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+	if closed {
+		c.JSON(http.StatusConflict, gin.H{"error": "Poll is closed"})
+		return
+	}
+	if known != len(optionIDs) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown poll option"})
+		return
+	}
+	if !multipleChoice && len(optionIDs) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This poll allows one choice"})
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM poll_votes WHERE poll_id = $1 AND user_id = $2", pollID, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO poll_votes (poll_id, option_id, user_id)
+		SELECT $1, unnest($2::uuid[]), $3
+	`, pollID, pq.Array(optionIDs), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+
+	h.broadcastPoll(ctx, pollID, target.topicID, target.groupID)
+	h.respondPoll(c, pollID, false)
+}
+
+// RetractVote withdraws the caller's votes from an open poll.
+func (h *MessageHandler) RetractVote(c *gin.Context) {
+	userID := c.GetString("user_id")
+	pollID := c.Param("id")
+	ctx := c.Request.Context()
+
+	target, ok := h.loadVotablePoll(c, pollID)
+	if !ok {
+		return
+	}
+
+	var open, retracted bool
+	err := h.db.QueryRowContext(ctx, `
+		WITH open AS (
+			SELECT p.id FROM polls p WHERE p.id = $1 AND NOT `+pollClosedSQL+`
+			FOR UPDATE
+		), deleted AS (
+			DELETE FROM poll_votes v USING open
+			WHERE v.poll_id = open.id AND v.user_id = $2
+			RETURNING 1
+		)
+		SELECT EXISTS (SELECT 1 FROM open), EXISTS (SELECT 1 FROM deleted)
+	`, pollID, userID).Scan(&open, &retracted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retract vote"})
+		return
+	}
+	if !open {
+		c.JSON(http.StatusConflict, gin.H{"error": "Poll is closed"})
+		return
+	}
+
+	if retracted {
+		h.broadcastPoll(ctx, pollID, target.topicID, target.groupID)
+	}
+	h.respondPoll(c, pollID, false)
+}
+
+// ClosePoll ends voting early. The poll's author, moderators of the group
+// and the topic's creator may close it.
+func (h *MessageHandler) ClosePoll(c *gin.Context) {
+	userID := c.GetString("user_id")
+	pollID := c.Param("id")
+	ctx := c.Request.Context()
+
+	target, err := h.pollTarget(ctx, pollID)
+	if err != nil || target.isDeleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return
+	}
+	allowed := target.authorID == userID
+	if !allowed {
+		allowed, err = h.canManageRoom(ctx, userID, c.GetString("user_role"), target.topicID, target.groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close poll"})
+			return
+		}
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the author and moderators can close this poll"})
+		return
+	}
+
+	res, err := h.db.ExecContext(ctx, `
+		UPDATE polls p SET closed_at = NOW() WHERE p.id = $1 AND NOT `+pollClosedSQL, pollID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close poll"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		h.broadcastPoll(ctx, pollID, target.topicID, target.groupID)
+	}
+	h.respondPoll(c, pollID, false)
+}
+
+// GetPoll returns a poll with the results the caller may see, and who
+// voted for what unless the poll is anonymous.
+func (h *MessageHandler) GetPoll(c *gin.Context) {
+	userID := c.GetString("user_id")
+	pollID := c.Param("id")
+	ctx := c.Request.Context()
+
+	target, err := h.pollTarget(ctx, pollID)
+	if err != nil || target.isDeleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return
+	}
+	canJoin, err := rooms.NewAuthorizer(h.db).CanJoin(ctx, userID, messageRoom(target.topicID, target.groupID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch poll"})
+		return
+	}
+	if !canJoin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	h.respondPoll(c, pollID, true)
+}
+
+// respondPoll writes the poll as the caller sees it, with voters if
+// withVoters and the poll is public.
+func (h *MessageHandler) respondPoll(c *gin.Context, pollID string, withVoters bool) {
+	ctx := c.Request.Context()
+	polls, err := queryPolls(ctx, h.db, c.GetString("user_id"), "p.id = $2", pollID)
+	if err != nil || len(polls) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch poll"})
+		return
+	}
+	poll := &polls[0]
+	redactPoll(poll)
+
+	if withVoters && poll.ResultsVisible && !poll.Anonymous {
+		rows, err := h.db.QueryContext(ctx, `
+			SELECT v.option_id, u.id, u.username, COALESCE(u.display_name, u.username), COALESCE(u.avatar_url, '')
+			FROM poll_votes v
+			JOIN users u ON u.id = v.user_id
+			WHERE v.poll_id = $1
+			ORDER BY v.created_at
+		`, pollID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch poll"})
+			return
+		}
+		defer rows.Close()
+
+		options := make(map[string]*models.PollOption)
+		for i := range poll.Options {
+			poll.Options[i].Voters = []models.User{}
+			options[poll.Options[i].ID] = &poll.Options[i]
+		}
+		for rows.Next() {
+			var optionID string
+			var u models.User
+			if err := rows.Scan(&optionID, &u.ID, &u.Username, &u.DisplayName, &u.AvatarURL); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch poll"})
+				return
+			}
+			if option := options[optionID]; option != nil {
+				option.Voters = append(option.Voters, u)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, poll)
+}
+
+// broadcastPoll pushes a poll's current tallies to its room. While the
+// results are hidden the room only gets the number of voters. For open
+// after_vote polls the tallies go to the whole room flagged
+// results_for_voters, and only clients whose user has voted show them;
+// anyone allowed to vote could see them by voting anyway.
+func (h *MessageHandler) broadcastPoll(ctx context.Context, pollID string, topicID, groupID *string) {
+	polls, err := queryPolls(ctx, h.db, "", "p.id = $2", pollID)
+	if err != nil || len(polls) == 0 {
+		log.Printf("Failed to load poll %s for broadcast: %v", pollID, err)
+		return
+	}
+	p := polls[0]
+
+	event := websocket.PollUpdatedEvent{
+		PollID:      p.ID,
+		MessageID:   p.MessageID,
+		TotalVoters: p.TotalVoters,
+		IsClosed:    p.IsClosed,
+	}
+	if p.IsClosed || p.Results != models.PollResultsAfterClose {
+		event.Tallies = make(map[string]int, len(p.Options))
+		for _, option := range p.Options {
+			event.Tallies[option.ID] = *option.Votes
+		}
+		event.ResultsForVoters = !p.IsClosed && p.Results == models.PollResultsAfterVote
+	}
+	h.hub.BroadcastToRoom(messageRoom(topicID, groupID), event)
+}
+
+// ClosePolls runs PollCloseJob.
+func (h *MessageHandler) ClosePolls(ctx context.Context, _ json.RawMessage) error {
+	rows, err := h.db.QueryContext(ctx, `
+		UPDATE polls p SET closed_at = p.closes_at
+		FROM messages m
+		WHERE m.id = p.message_id AND p.closed_at IS NULL AND p.closes_at <= NOW()
+		RETURNING p.id, m.topic_id, m.group_id, COALESCE(m.is_deleted, false)
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type closedPoll struct {
+		id               string
+		topicID, groupID *string
+	}
+	var closed []closedPoll
+	for rows.Next() {
+		var p closedPoll
+		var isDeleted bool
+		if err := rows.Scan(&p.id, &p.topicID, &p.groupID, &isDeleted); err != nil {
+			return err
+		}
+		if !isDeleted {
+			closed = append(closed, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, p := range closed {
+		h.broadcastPoll(ctx, p.id, p.topicID, p.groupID)
+	}
+	return nil
+}
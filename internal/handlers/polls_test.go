@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"psycho-platform/internal/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidatePoll(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+
+	tests := []struct {
+		name        string
+		req         models.CreatePollRequest
+		wantErr     bool
+		wantOptions []string
+		wantResults string
+	}{
+		{
+			name:        "defaults to always",
+			req:         models.CreatePollRequest{Options: []string{"Tuesday", "Thursday"}},
+			wantOptions: []string{"Tuesday", "Thursday"},
+			wantResults: models.PollResultsAlways,
+		},
+		{
+			name:        "options are trimmed",
+			req:         models.CreatePollRequest{Options: []string{"  yes ", "no"}, Results: models.PollResultsAfterVote},
+			wantOptions: []string{"yes", "no"},
+			wantResults: models.PollResultsAfterVote,
+		},
+		{
+			name:        "closes within a year",
+			req:         models.CreatePollRequest{Options: []string{"a", "b"}, Results: models.PollResultsAfterClose, ClosesAt: at(24 * time.Hour)},
+			wantOptions: []string{"a", "b"},
+			wantResults: models.PollResultsAfterClose,
+		},
+		{name: "one option", req: models.CreatePollRequest{Options: []string{"a"}}, wantErr: true},
+		{name: "eleven options", req: models.CreatePollRequest{Options: strings.Split("a b c d e f g h i j k", " ")}, wantErr: true},
+		{name: "blank option", req: models.CreatePollRequest{Options: []string{"a", "   "}}, wantErr: true},
+		{name: "long option", req: models.CreatePollRequest{Options: []string{"a", strings.Repeat("я", 201)}}, wantErr: true},
+		{name: "duplicate after trimming", req: models.CreatePollRequest{Options: []string{"a", " a"}}, wantErr: true},
+		{name: "unknown results", req: models.CreatePollRequest{Options: []string{"a", "b"}, Results: "never"}, wantErr: true},
+		{name: "closes in the past", req: models.CreatePollRequest{Options: []string{"a", "b"}, ClosesAt: at(-time.Minute)}, wantErr: true},
+		{name: "closes now", req: models.CreatePollRequest{Options: []string{"a", "b"}, ClosesAt: at(0)}, wantErr: true},
+		{name: "closes beyond a year", req: models.CreatePollRequest{Options: []string{"a", "b"}, ClosesAt: at(366 * 24 * time.Hour)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		err := validatePoll(&req, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			var se *sendError
+			if !errors.As(err, &se) || se.code != "invalid_poll" {
+				t.Errorf("%s: error = %#v, want an invalid_poll sendError", tt.name, err)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(req.Options, tt.wantOptions) || req.Results != tt.wantResults {
+			t.Errorf("%s: got options %q results %q, want %q %q", tt.name, req.Options, req.Results, tt.wantOptions, tt.wantResults)
+		}
+	}
+}
+
+func TestRedactPoll(t *testing.T) {
+	tests := []struct {
+		name    string
+		results string
+		closed  bool
+		myVotes []string
+		visible bool
+	}{
+		{"always", models.PollResultsAlways, false, nil, true},
+		{"after_vote before voting", models.PollResultsAfterVote, false, []string{}, false},
+		{"after_vote after voting", models.PollResultsAfterVote, false, []string{"o1"}, true},
+		{"after_vote closed", models.PollResultsAfterVote, true, []string{}, true},
+		{"after_close open", models.PollResultsAfterClose, false, []string{"o1"}, false},
+		{"after_close closed", models.PollResultsAfterClose, true, []string{}, true},
+	}
+	for _, tt := range tests {
+		votes := 3
+		p := models.Poll{
+			Results:  tt.results,
+			IsClosed: tt.closed,
+			MyVotes:  tt.myVotes,
+			Options: []models.PollOption{
+				{ID: "o1", Votes: &votes, Voters: []models.User{{ID: "u1"}}},
+			},
+		}
+		redactPoll(&p)
+
+		if p.ResultsVisible != tt.visible {
+			t.Errorf("%s: ResultsVisible = %v, want %v", tt.name, p.ResultsVisible, tt.visible)
+		}
+		hidden := p.Options[0].Votes == nil && p.Options[0].Voters == nil
+		if hidden == tt.visible {
+			t.Errorf("%s: option votes hidden = %v with results visible %v", tt.name, hidden, tt.visible)
+		}
+	}
+}
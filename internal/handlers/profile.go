@@ -3,16 +3,18 @@ package handlers
 import (
 	"database/sql"
 	"net/http"
+	"psycho-platform/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ProfileHandler struct {
-	db *sql.DB
+	db  *sql.DB
+	hub *websocket.Hub
 }
 
-func NewProfileHandler(db *sql.DB) *ProfileHandler {
-	return &ProfileHandler{db: db}
+func NewProfileHandler(db *sql.DB, hub *websocket.Hub) *ProfileHandler {
+	return &ProfileHandler{db: db, hub: hub}
 }
 
 type UpdateProfileRequest struct {
@@ -60,6 +62,7 @@ func (h *ProfileHandler) GetUserProfile(c *gin.Context) {
 		Status      string `json:"status"`
 		Role        string `json:"role"`
 		IsOnline    bool   `json:"is_online"`
+		Presence    string `json:"presence"`
 		LastSeen    string `json:"last_seen"`
 	}
 
@@ -67,6 +70,7 @@ func (h *ProfileHandler) GetUserProfile(c *gin.Context) {
 		SELECT u.id, u.username, u.display_name, u.avatar_url, u.bio,
 		       COALESCE(u.status, '') as status, u.role,
 		       COALESCE(us.is_online, false) as is_online,
+		       COALESCE(us.presence, 'offline') as presence,
 		       COALESCE(us.last_seen::text, '') as last_seen
 		FROM users u
 		LEFT JOIN user_status us ON u.id = us.user_id
@@ -74,7 +78,7 @@ func (h *ProfileHandler) GetUserProfile(c *gin.Context) {
 	`, userID).Scan(
 		&user.ID, &user.Username, &user.DisplayName, &user.AvatarURL,
 		&user.Bio, &user.Status, &user.Role,
-		&user.IsOnline, &user.LastSeen,
+		&user.IsOnline, &user.Presence, &user.LastSeen,
 	)
 
 	if err == sql.ErrNoRows {
@@ -160,6 +164,10 @@ func (h *ProfileHandler) BlockUser(c *gin.Context) {
 		return
 	}
 
+	// A block closes the shared conversation room for both sides
+	h.hub.RecheckUserRooms(userID)
+	h.hub.RecheckUserRooms(blockedUserID)
+
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
@@ -212,32 +220,24 @@ func (h *ProfileHandler) GetBlockedUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
+// SetOnlineStatus is kept for older clients. Presence now follows the
+// user's WebSocket connections, so ?online= is ignored and the derived
+// status is returned.
+//
+// Deprecated: clients report idle and away with the presence frame.
 func (h *ProfileHandler) SetOnlineStatus(c *gin.Context) {
 	userID := c.GetString("user_id")
-	isOnline := c.Query("online") == "true"
-
-	if isOnline {
-		_, err := h.db.Exec(`
-			INSERT INTO user_status (user_id, is_online, last_seen)
-			VALUES ($1, true, CURRENT_TIMESTAMP)
-			ON CONFLICT (user_id)
-			DO UPDATE SET is_online = true, last_seen = CURRENT_TIMESTAMP
-		`, userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status"})
-			return
-		}
-	} else {
-		_, err := h.db.Exec(`
-			UPDATE user_status
-			SET is_online = false, last_seen = CURRENT_TIMESTAMP
-			WHERE user_id = $1
-		`, userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update status"})
-			return
-		}
+
+	status := websocket.StatusOffline
+	current, err := h.hub.Presence(c.Request.Context(), []string{userID})
+	if p, ok := current[userID]; ok && err == nil {
+		status = p.Status
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	c.Header("Deprecation", "true")
+	c.JSON(http.StatusOK, gin.H{
+		"success":   true,
+		"is_online": status != websocket.StatusOffline,
+		"presence":  status,
+	})
 }
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"psycho-platform/internal/rooms"
+	"psycho-platform/internal/websocket"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxUnreadCount caps the unread and mention counts; clients show the cap
+// as "99+". Counting stops there, so busy rooms cost no more than quiet ones.
+const maxUnreadCount = 100
+
+// unreadSQL counts a room's messages posted by others after the reader's
+// cursor rc, and those among them mentioning the reader, each up to
+// maxUnreadCount. The reader is $1; roomExpr is the room's ID. Rooms where
+// tracked is false, such as ones the reader never joined or opened, count
+// nothing. It always yields one row, so it is joined with
+// CROSS JOIN LATERAL (...) unread.
+func unreadSQL(column, roomExpr, tracked string) string {
+	unread := `
+		FROM messages m
+		WHERE ` + tracked + `
+		  AND m.` + column + ` = ` + roomExpr + `
+		  AND m.user_id <> $1
+		  AND NOT COALESCE(m.is_deleted, false)
+		  AND (rc.last_read_at IS NULL OR m.created_at > rc.last_read_at)`
+	return `
+		SELECT (SELECT COUNT(*) FROM (SELECT 1 ` + unread + `
+		            LIMIT ` + strconv.Itoa(maxUnreadCount) + `) capped) AS unread_count,
+		       (SELECT COUNT(*) FROM (SELECT 1 ` + unread + `
+		            AND EXISTS (
+		                SELECT 1 FROM message_mentions mm WHERE mm.message_id = m.id AND mm.user_id = $1
+		            )
+		            LIMIT ` + strconv.Itoa(maxUnreadCount) + `) capped) AS mention_count`
+}
+
+// readRoom is a topic or group, named by the column that references it
+// from messages and room_read_cursors.
+type readRoom struct {
+	column string
+	id     string
+}
+
+func topicReadRoom(id string) readRoom { return readRoom{column: "topic_id", id: id} }
+func groupReadRoom(id string) readRoom { return readRoom{column: "group_id", id: id} }
+
+func (r readRoom) name() string {
+	if r.column == "group_id" {
+		return rooms.Group(r.id)
+	}
+	return rooms.Topic(r.id)
+}
+
+var errReadMessageNotFound = errors.New("message not found in room")
+
+// advanceReadCursor moves userID's cursor in room forward to messageID, or
+// to the room's latest message when messageID is empty. A cursor never
+// moves back. When it moves, the user's other connections get a read
+// event so they can clear their badges.
+func advanceReadCursor(ctx context.Context, db *sql.DB, hub *websocket.Hub, userID string, room readRoom, messageID string) (*websocket.ReadEvent, error) {
+	var target sql.NullString
+	var at time.Time
+	var err error
+	if messageID != "" {
+		err = db.QueryRowContext(ctx, `
+			SELECT id, created_at FROM messages
+			WHERE id = $1 AND `+room.column+` = $2
+		`, messageID, room.id).Scan(&target, &at)
+		if err == sql.ErrNoRows {
+			return nil, errReadMessageNotFound
+		}
+	} else {
+		err = db.QueryRowContext(ctx, `
+			SELECT id, created_at FROM messages
+			WHERE `+room.column+` = $1
+			ORDER BY created_at DESC
+			LIMIT 1
+		`, room.id).Scan(&target, &at)
+		if err == sql.ErrNoRows {
+			// Nothing posted yet; later messages are unread
+			target, at, err = sql.NullString{}, time.Now(), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO room_read_cursors (user_id, `+room.column+`, last_read_message_id, last_read_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, `+room.column+`) WHERE `+room.column+` IS NOT NULL
+		DO UPDATE SET last_read_message_id = EXCLUDED.last_read_message_id,
+		              last_read_at = EXCLUDED.last_read_at,
+		              updated_at = CURRENT_TIMESTAMP
+		WHERE room_read_cursors.last_read_at < EXCLUDED.last_read_at
+	`, userID, room.id, target, at)
+	if err != nil {
+		return nil, err
+	}
+
+	event, err := readCursor(ctx, db, userID, room)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		hub.BroadcastToRoom(rooms.User(userID), *event)
+	}
+	return event, nil
+}
+
+// readCursor returns userID's cursor in room with the counts left unread.
+func readCursor(ctx context.Context, db *sql.DB, userID string, room readRoom) (*websocket.ReadEvent, error) {
+	event := websocket.ReadEvent{Room: room.name()}
+	err := db.QueryRowContext(ctx, `
+		SELECT rc.last_read_message_id, rc.last_read_at, unread.unread_count, unread.mention_count
+		FROM room_read_cursors rc
+		CROSS JOIN LATERAL (`+unreadSQL(room.column, "$2", "true")+`) unread
+		WHERE rc.user_id = $1 AND rc.`+room.column+` = $2
+	`, userID, room.id).Scan(&event.LastReadMessageID, &event.LastReadAt, &event.UnreadCount, &event.MentionCount)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+type ReadHandler struct {
+	db  *sql.DB
+	hub *websocket.Hub
+}
+
+func NewReadHandler(db *sql.DB, hub *websocket.Hub) *ReadHandler {
+	return &ReadHandler{db: db, hub: hub}
+}
+
+type MarkReadRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+// MarkTopicRead advances the caller's read cursor in a topic.
+func (h *ReadHandler) MarkTopicRead(c *gin.Context) {
+	h.markRead(c, topicReadRoom(c.Param("id")))
+}
+
+// MarkGroupRead advances the caller's read cursor in a group.
+func (h *ReadHandler) MarkGroupRead(c *gin.Context) {
+	h.markRead(c, groupReadRoom(c.Param("id")))
+}
+
+func (h *ReadHandler) markRead(c *gin.Context, room readRoom) {
+	userID := c.GetString("user_id")
+
+	var req MarkReadRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.MessageID != "" {
+		if _, err := uuid.Parse(req.MessageID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+			return
+		}
+	}
+
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(c.Request.Context(), userID, room.name())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	event, err := advanceReadCursor(c.Request.Context(), h.db, h.hub, userID, room, req.MessageID)
+	if err == errReadMessageNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark as read"})
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
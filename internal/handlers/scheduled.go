@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"psycho-platform/internal/jobs"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/validation"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ScheduledDispatchJob delivers the scheduled messages that are due.
+const ScheduledDispatchJob = "messages.scheduled"
+
+const (
+	// maxScheduledPerUser caps a user's pending scheduled messages.
+	maxScheduledPerUser = 50
+	// maxScheduleAhead is how far ahead a message may be scheduled.
+	maxScheduleAhead = 365 * 24 * time.Hour
+	// minRecurrence is the shortest interval between recurring deliveries.
+	minRecurrence = time.Hour
+	// scheduledBatch caps the deliveries of one dispatcher run.
+	scheduledBatch = 100
+)
+
+type CreateScheduledMessageRequest struct {
+	Content    string     `json:"content" binding:"required"`
+	TopicID    *string    `json:"topic_id"`
+	GroupID    *string    `json:"group_id"`
+	SendAt     *time.Time `json:"send_at"`
+	Recurrence *string    `json:"recurrence"`
+	Timezone   string     `json:"timezone"`
+}
+
+type UpdateScheduledMessageRequest struct {
+	Content    *string    `json:"content"`
+	SendAt     *time.Time `json:"send_at"`
+	Recurrence *string    `json:"recurrence"`
+	Timezone   *string    `json:"timezone"`
+}
+
+// nextSendAt validates a schedule and returns the first delivery time: sendAt
+// if given, otherwise the recurrence's next activation after now in tz.
+// Recurrences are cron expressions evaluated in tz, such as "0 9 * * 1"
+// for Mondays at 9:00.
+func nextSendAt(sendAt *time.Time, recurrence *string, tz string, now time.Time) (time.Time, error) {
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %q", tz)
+	}
+
+	var next time.Time
+	if recurrence != nil {
+		spec, err := jobs.ParseSpec(*recurrence)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// Every pair of activations counts, not just the next two: "0,30 9 * * *"
+		// fires twice within an hour once a day
+		if spec.MinGap() < minRecurrence {
+			return time.Time{}, errors.New("recurrence must be at least an hour apart")
+		}
+		next = spec.Next(now.In(loc))
+	}
+	if sendAt != nil {
+		next = *sendAt
+	}
+
+	if next.IsZero() {
+		return time.Time{}, errors.New("send_at or recurrence is required")
+	}
+	if !next.After(now) {
+		return time.Time{}, errors.New("send_at must be in the future")
+	}
+	if next.Sub(now) > maxScheduleAhead {
+		return time.Time{}, errors.New("send_at must be within a year")
+	}
+	return next, nil
+}
+
+// authorizeSchedule checks userID may post to the room, and for recurring
+// messages that they manage it.
+func (h *MessageHandler) authorizeSchedule(ctx context.Context, userID, role string, topicID, groupID *string, recurring bool) error {
+	if err := h.checkCanPost(ctx, userID, topicID, groupID); err != nil {
+		return err
+	}
+	if !recurring {
+		return nil
+	}
+	allowed, err := h.canManageRoom(ctx, userID, role, topicID, groupID)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return &sendError{http.StatusForbidden, "forbidden", "Only moderators and the topic owner can schedule recurring messages"}
+	}
+	return nil
+}
+
+const scheduledColumns = `
+	id, user_id, topic_id, group_id, content, send_at, recurrence, timezone,
+	status, sent_count, last_sent_at, last_message_id, last_error, created_at, updated_at`
+
+func scanScheduled(row interface{ Scan(...interface{}) error }) (models.ScheduledMessage, error) {
+	var s models.ScheduledMessage
+	err := row.Scan(&s.ID, &s.UserID, &s.TopicID, &s.GroupID, &s.Content, &s.SendAt, &s.Recurrence,
+		&s.Timezone, &s.Status, &s.SentCount, &s.LastSentAt, &s.LastMessageID, &s.LastError,
+		&s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// CreateScheduledMessage schedules a topic or group message for later,
+// optionally repeating it.
+func (h *MessageHandler) CreateScheduledMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	var req CreateScheduledMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	content := validation.SanitizeString(req.Content)
+	if err := validation.ValidateContent(content, maxMessageLength); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.TopicID == nil) == (req.GroupID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of topic_id or group_id is required"})
+		return
+	}
+	if req.Timezone == "" {
+		req.Timezone = "UTC"
+	}
+	sendAt, err := nextSendAt(req.SendAt, req.Recurrence, req.Timezone, time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.authorizeSchedule(ctx, userID, c.GetString("user_role"), req.TopicID, req.GroupID, req.Recurrence != nil); err != nil {
+		writeSendError(c, err, "Failed to schedule message")
+		return
+	}
+
+	var pending int
+	err = h.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM scheduled_messages WHERE user_id = $1 AND status = 'scheduled'
+	`, userID).Scan(&pending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		return
+	}
+	if pending >= maxScheduledPerUser {
+		c.JSON(http.StatusConflict, gin.H{"error": "Too many scheduled messages"})
+		return
+	}
+
+	scheduled, err := scanScheduled(h.db.QueryRowContext(ctx, `
+		INSERT INTO scheduled_messages (user_id, topic_id, group_id, content, send_at, recurrence, timezone)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING `+scheduledColumns,
+		userID, req.TopicID, req.GroupID, content, sendAt, req.Recurrence, req.Timezone))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, scheduled)
+}
+
+// GetScheduledMessages lists the caller's scheduled messages, optionally
+// in one room (?topic_id= or ?group_id=) and with one ?status=. Moderators
+// of a group see everyone's messages scheduled there.
+func (h *MessageHandler) GetScheduledMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+	topicID := c.Query("topic_id")
+	groupID := c.Query("group_id")
+	status := c.Query("status")
+
+	for _, id := range []string{topicID, groupID} {
+		if id == "" {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid topic_id or group_id"})
+			return
+		}
+	}
+
+	everyone := false
+	if groupID != "" {
+		allowed, err := h.canModerate(ctx, userID, c.GetString("user_role"), &groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scheduled messages"})
+			return
+		}
+		everyone = allowed
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+scheduledColumns+`
+		FROM scheduled_messages
+		WHERE ($1 OR user_id = $2)
+		  AND ($3 = '' OR topic_id = $3::uuid)
+		  AND ($4 = '' OR group_id = $4::uuid)
+		  AND ($5 = '' OR status = $5)
+		ORDER BY send_at
+		LIMIT 200
+	`, everyone, userID, topicID, groupID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scheduled messages"})
+		return
+	}
+	defer rows.Close()
+
+	scheduled := []models.ScheduledMessage{}
+	for rows.Next() {
+		s, err := scanScheduled(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read scheduled messages"})
+			return
+		}
+		scheduled = append(scheduled, s)
+	}
+
+	c.JSON(http.StatusOK, scheduled)
+}
+
+// UpdateScheduledMessage changes the content or timing of the caller's
+// pending scheduled message. An empty recurrence makes it one-off.
+func (h *MessageHandler) UpdateScheduledMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	var req UpdateScheduledMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+		return
+	}
+	defer tx.Rollback()
+
+	s, err := scanScheduled(tx.QueryRowContext(ctx, `
+		SELECT `+scheduledColumns+` FROM scheduled_messages WHERE id = $1 FOR UPDATE
+	`, id))
+	if err == sql.ErrNoRows || err == nil && s.UserID != userID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+		return
+	}
+	if s.Status != "scheduled" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Scheduled message is no longer pending"})
+		return
+	}
+
+	if req.Content != nil {
+		content := validation.SanitizeString(*req.Content)
+		if err := validation.ValidateContent(content, maxMessageLength); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.Content = content
+	}
+	if req.Timezone != nil {
+		s.Timezone = *req.Timezone
+	}
+	if req.Recurrence != nil {
+		s.Recurrence = req.Recurrence
+		if *req.Recurrence == "" {
+			s.Recurrence = nil
+		}
+	}
+	if req.SendAt != nil || req.Recurrence != nil || req.Timezone != nil {
+		sendAt := req.SendAt
+		if sendAt == nil && s.Recurrence == nil {
+			// A one-off message keeps its delivery time
+			sendAt = &s.SendAt
+		}
+		next, err := nextSendAt(sendAt, s.Recurrence, s.Timezone, time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		s.SendAt = next
+	}
+
+	if err := h.authorizeSchedule(ctx, userID, c.GetString("user_role"), s.TopicID, s.GroupID, s.Recurrence != nil); err != nil {
+		writeSendError(c, err, "Failed to update scheduled message")
+		return
+	}
+
+	s, err = scanScheduled(tx.QueryRowContext(ctx, `
+		UPDATE scheduled_messages
+		SET content = $2, send_at = $3, recurrence = $4, timezone = $5, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING `+scheduledColumns,
+		id, s.Content, s.SendAt, s.Recurrence, s.Timezone))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+// CancelScheduledMessage stops a pending scheduled message. Its author and
+// the moderators of its group may cancel it.
+func (h *MessageHandler) CancelScheduledMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	if _, err := uuid.Parse(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+
+	var ownerID, status string
+	var groupID *string
+	err := h.db.QueryRowContext(ctx, `
+		SELECT user_id, group_id, status FROM scheduled_messages WHERE id = $1
+	`, id).Scan(&ownerID, &groupID, &status)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if ownerID != userID {
+		allowed, err := h.canModerate(ctx, userID, c.GetString("user_role"), groupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scheduled message"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+			return
+		}
+	}
+
+	res, err := h.db.ExecContext(ctx, `
+		UPDATE scheduled_messages
+		SET status = 'cancelled', updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND status = 'scheduled'
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scheduled message"})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Scheduled message is no longer pending"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// DispatchScheduled delivers the scheduled messages that are due through
+// createMessage, so they are checked and broadcast like any other. Each
+// delivery carries a client_msg_id derived from the message and its due
+// time, so a retried run never posts twice. Recurring messages move to
+// their next activation; missed ones are not made up for.
+func (h *MessageHandler) DispatchScheduled(ctx context.Context, _ json.RawMessage) error {
+	for i := 0; i < scheduledBatch; i++ {
+		done, err := h.dispatchNext(ctx)
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *MessageHandler) dispatchNext(ctx context.Context) (done bool, err error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	s, err := scanScheduled(tx.QueryRowContext(ctx, `
+		SELECT `+scheduledColumns+`
+		FROM scheduled_messages
+		WHERE status = 'scheduled' AND send_at <= NOW()
+		ORDER BY send_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`))
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var active bool
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(is_active, true) FROM users WHERE id = $1", s.UserID).Scan(&active); err != nil {
+		return false, err
+	}
+
+	status, next := "sent", s.SendAt
+	var messageID, lastError *string
+	if !active {
+		status, lastError = "failed", strPtr("Author is deactivated")
+	} else {
+		message, _, err := h.createMessage(ctx, s.UserID, models.CreateMessageRequest{
+			Content:     s.Content,
+			TopicID:     s.TopicID,
+			GroupID:     s.GroupID,
+			ClientMsgID: fmt.Sprintf("sched-%s-%d", s.ID, s.SendAt.Unix()),
+		})
+		var sendErr *sendError
+		switch {
+		case errors.As(err, &sendErr):
+			// The author can no longer post there
+			status, lastError = "failed", &sendErr.message
+		case err != nil:
+			return false, err
+		default:
+			messageID = &message.ID
+		}
+	}
+
+	if status == "sent" && s.Recurrence != nil {
+		if next, err = recurAfter(*s.Recurrence, s.Timezone, s.SendAt, time.Now()); err != nil {
+			status, lastError = "failed", strPtr(err.Error())
+		} else {
+			status = "scheduled"
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE scheduled_messages
+		SET status = $2, send_at = $3, last_error = $4,
+		    last_message_id = COALESCE($5, last_message_id),
+		    last_sent_at = CASE WHEN $5::uuid IS NULL THEN last_sent_at ELSE CURRENT_TIMESTAMP END,
+		    sent_count = sent_count + CASE WHEN $5::uuid IS NULL THEN 0 ELSE 1 END,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`, s.ID, status, next, lastError, messageID)
+	if err != nil {
+		return false, err
+	}
+	if lastError != nil {
+		log.Printf("Scheduled message %s failed: %s", s.ID, *lastError)
+	}
+	return false, tx.Commit()
+}
+
+// recurAfter returns the recurrence's first activation after both the last
+// due time and now.
+func recurAfter(recurrence, tz string, last, now time.Time) (time.Time, error) {
+	spec, err := jobs.ParseSpec(recurrence)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return time.Time{}, err
+	}
+	from := last
+	if now.After(from) {
+		from = now
+	}
+	return spec.Next(from.In(loc)), nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
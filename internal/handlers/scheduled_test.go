@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextSendAt(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name       string
+		sendAt     *time.Time
+		recurrence *string
+		tz         string
+		want       time.Time
+		wantErr    bool
+	}{
+		{name: "send_at", sendAt: at(time.Hour), tz: "UTC", want: now.Add(time.Hour)},
+		{name: "recurrence", recurrence: str("0 9 * * *"), tz: "UTC", want: time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC)},
+		{name: "recurrence in timezone", recurrence: str("0 13 * * *"), tz: "Europe/Kyiv", want: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{name: "send_at overrides recurrence start", sendAt: at(2 * time.Hour), recurrence: str("0 9 * * *"), tz: "UTC", want: now.Add(2 * time.Hour)},
+		{name: "two hourly slots", recurrence: str("0 9,17 * * *"), tz: "UTC", want: time.Date(2024, 1, 1, 17, 0, 0, 0, time.UTC)},
+		{name: "neither", tz: "UTC", wantErr: true},
+		{name: "past", sendAt: at(-time.Minute), tz: "UTC", wantErr: true},
+		{name: "now", sendAt: at(0), tz: "UTC", wantErr: true},
+		{name: "beyond a year", sendAt: at(366 * 24 * time.Hour), tz: "UTC", wantErr: true},
+		{name: "unknown timezone", sendAt: at(time.Hour), tz: "Mars/Olympus", wantErr: true},
+		{name: "invalid recurrence", recurrence: str("0 25 * * *"), tz: "UTC", wantErr: true},
+		{name: "every minute", recurrence: str("* * * * *"), tz: "UTC", wantErr: true},
+		{name: "half-hour pair once a day", recurrence: str("0,30 9 * * *"), tz: "UTC", wantErr: true},
+		{name: "gap only late in the day", recurrence: str("0 8,12,23 * * *"), tz: "UTC", want: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "across midnight", recurrence: str("30 0,23 * * *"), tz: "UTC", want: time.Date(2024, 1, 1, 23, 30, 0, 0, time.UTC)},
+		{name: "short gap across midnight", recurrence: str("50,10 0,23 * * *"), tz: "UTC", wantErr: true},
+		{name: "exactly an hour apart", recurrence: str("0 10,11 * * *"), tz: "UTC", want: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		// The next two activations, 10:50 and 10:00 tomorrow, are far apart
+		{name: "short gap later in the cycle", recurrence: str("0,50 10 * * *"), tz: "UTC", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := nextSendAt(tt.sendAt, tt.recurrence, tt.tz, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
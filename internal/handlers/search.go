@@ -1,17 +1,19 @@
 package handlers
 
 import (
-	"database/sql"
 	"net/http"
+	"psycho-platform/internal/database"
 
 	"github.com/gin-gonic/gin"
 )
 
+// SearchHandler only reads, so its queries go to a read replica when one
+// is configured.
 type SearchHandler struct {
-	db *sql.DB
+	db *database.DB
 }
 
-func NewSearchHandler(db *sql.DB) *SearchHandler {
+func NewSearchHandler(db *database.DB) *SearchHandler {
 	return &SearchHandler{db: db}
 }
 
@@ -31,6 +33,7 @@ func (h *SearchHandler) GlobalSearch(c *gin.Context) {
 
 	userID := c.GetString("user_id")
 	limit := c.DefaultQuery("limit", "10")
+	reader := h.db.Reader(userID)
 	results := SearchResults{
 		Messages: []map[string]interface{}{},
 		Topics:   []map[string]interface{}{},
@@ -39,7 +42,7 @@ func (h *SearchHandler) GlobalSearch(c *gin.Context) {
 	}
 
 	// Search messages
-	messageRows, _ := h.db.Query(`
+	messageRows, _ := reader.Query(`
 		SELECT m.id, m.content, m.created_at, u.username, u.display_name
 		FROM messages m
 		JOIN users u ON m.user_id = u.id
@@ -66,7 +69,7 @@ func (h *SearchHandler) GlobalSearch(c *gin.Context) {
 	}
 
 	// Search topics
-	topicRows, _ := h.db.Query(`
+	topicRows, _ := reader.Query(`
 		SELECT id, title, description, votes_count, messages_count
 		FROM topics
 		WHERE (title ILIKE '%' || $1 || '%' OR description ILIKE '%' || $1 || '%')
@@ -92,7 +95,7 @@ func (h *SearchHandler) GlobalSearch(c *gin.Context) {
 	}
 
 	// Search groups
-	groupRows, _ := h.db.Query(`
+	groupRows, _ := reader.Query(`
 		SELECT id, name, description, members_count
 		FROM groups
 		WHERE (name ILIKE '%' || $1 || '%' OR description ILIKE '%' || $1 || '%')
@@ -117,7 +120,7 @@ func (h *SearchHandler) GlobalSearch(c *gin.Context) {
 	}
 
 	// Search users
-	userRows, _ := h.db.Query(`
+	userRows, _ := reader.Query(`
 		SELECT id, username, display_name, bio, role
 		FROM users
 		WHERE (username ILIKE '%' || $1 || '%' OR display_name ILIKE '%' || $1 || '%' OR bio ILIKE '%' || $1 || '%')
@@ -166,7 +169,7 @@ func (h *SearchHandler) SearchMessages(c *gin.Context) {
 		LIMIT $4
 	`
 
-	rows, err := h.db.Query(sqlQuery, query, topicID, groupID, limit)
+	rows, err := h.db.Reader(c.GetString("user_id")).Query(sqlQuery, query, topicID, groupID, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed"})
 		return
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxMessageLength caps message content, in bytes
+	maxMessageLength     = 10000
+	maxClientMsgIDLength = 64
+)
+
+// sendError is a validation or permission failure shared by the REST and
+// WebSocket send paths. It satisfies websocket.CodedError.
+type sendError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *sendError) Error() string { return e.message }
+func (e *sendError) Code() string  { return e.code }
+
+// writeSendError answers a REST request with err, hiding unexpected errors
+// behind fallback.
+func writeSendError(c *gin.Context, err error, fallback string) {
+	var se *sendError
+	if errors.As(err, &se) {
+		c.JSON(se.status, gin.H{"error": se.message, "code": se.code})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+}
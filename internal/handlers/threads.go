@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"psycho-platform/internal/jobs"
+	"psycho-platform/internal/models"
+	"psycho-platform/internal/rooms"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ThreadNotifyJob notifies a thread's followers of a new reply. Its
+// payload is ThreadNotifyPayload.
+const ThreadNotifyJob = "threads.notify"
+
+type ThreadNotifyPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// threadRoot resolves a message to the root of its thread and the root's
+// room. Threads are one level deep: replies point at the root.
+func (h *MessageHandler) threadRoot(ctx context.Context, messageID string) (rootID string, topicID, groupID *string, err error) {
+	err = h.db.QueryRowContext(ctx, `
+		SELECT r.id, r.topic_id, r.group_id
+		FROM messages m
+		JOIN messages r ON r.id = COALESCE(m.parent_id, m.id)
+		WHERE m.id = $1
+	`, messageID).Scan(&rootID, &topicID, &groupID)
+	return rootID, topicID, groupID, err
+}
+
+// authorizeThread resolves the thread of the :id message and checks the
+// caller may read its room, answering the request on failure.
+func (h *MessageHandler) authorizeThread(c *gin.Context) (rootID string, ok bool) {
+	userID := c.GetString("user_id")
+	messageID := c.Param("id")
+	if _, err := uuid.Parse(messageID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return "", false
+	}
+
+	rootID, topicID, groupID, err := h.threadRoot(c.Request.Context(), messageID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return "", false
+	}
+
+	allowed, err := rooms.NewAuthorizer(h.db).CanJoin(c.Request.Context(), userID, messageRoom(topicID, groupID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return "", false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return "", false
+	}
+	return rootID, true
+}
+
+// GetThread returns the thread containing a message: its root and a page
+// of replies, oldest first. ?after= takes the previous page's next_cursor.
+func (h *MessageHandler) GetThread(c *gin.Context) {
+	userID := c.GetString("user_id")
+	ctx := c.Request.Context()
+
+	var after *string
+	if cursor := c.Query("after"); cursor != "" {
+		if _, err := uuid.Parse(cursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		after = &cursor
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	rootID, ok := h.authorizeThread(c)
+	if !ok {
+		return
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.id = $1
+	`, rootID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+	roots, err := scanMessages(rows)
+	rows.Close()
+	if err != nil || len(roots) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+
+	rows, err = h.db.QueryContext(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.parent_id = $1
+		  AND ($2::uuid IS NULL OR (m.created_at, m.id) > (SELECT created_at, id FROM messages WHERE id = $2))
+		ORDER BY m.created_at, m.id
+		LIMIT $3
+	`, rootID, after, limit+1)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+	replies, err := scanMessages(rows)
+	rows.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read messages"})
+		return
+	}
+
+	thread := models.Thread{Root: roots[0], Replies: replies}
+	if len(replies) > limit {
+		thread.Replies = replies[:limit]
+		thread.NextCursor = &thread.Replies[limit-1].ID
+	}
+
+	all := append([]models.Message{thread.Root}, thread.Replies...)
+	if err := hydrateMessages(h.db, userID, all); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load message details"})
+		return
+	}
+	thread.Root = all[0]
+	copy(thread.Replies, all[1:])
+
+	err = h.db.QueryRowContext(ctx, `
+		SELECT COALESCE((
+			SELECT following FROM thread_follows WHERE user_id = $1 AND message_id = $2
+		), false)
+	`, userID, rootID).Scan(&thread.Following)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch thread"})
+		return
+	}
+
+	c.JSON(http.StatusOK, thread)
+}
+
+// FollowThread subscribes the caller to notifications about new replies.
+func (h *MessageHandler) FollowThread(c *gin.Context) {
+	h.setFollowing(c, true)
+}
+
+// UnfollowThread stops them. The choice sticks: replying to the thread
+// again does not re-follow it.
+func (h *MessageHandler) UnfollowThread(c *gin.Context) {
+	h.setFollowing(c, false)
+}
+
+func (h *MessageHandler) setFollowing(c *gin.Context, following bool) {
+	userID := c.GetString("user_id")
+
+	rootID, ok := h.authorizeThread(c)
+	if !ok {
+		return
+	}
+
+	_, err := h.db.ExecContext(c.Request.Context(), `
+		INSERT INTO thread_follows (user_id, message_id, following)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, message_id) DO UPDATE SET following = EXCLUDED.following
+	`, userID, rootID, following)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update thread subscription"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message_id": rootID, "following": following})
+}
+
+// onThreadReply follows the thread on behalf of the replier and the root's
+// author, unless they unfollowed it, and queues notifications for the
+// other followers.
+func (h *MessageHandler) onThreadReply(ctx context.Context, reply *models.Message) {
+	_, err := h.db.ExecContext(ctx, `
+		INSERT INTO thread_follows (user_id, message_id)
+		SELECT DISTINCT u, $1::uuid
+		FROM unnest(ARRAY[$2::uuid, (SELECT user_id FROM messages WHERE id = $1)]) AS u
+		ON CONFLICT (user_id, message_id) DO NOTHING
+	`, *reply.ParentID, reply.UserID)
+	if err != nil {
+		log.Printf("Failed to follow thread %s: %v", *reply.ParentID, err)
+	}
+
+	if _, err := h.jobs.Enqueue(ctx, ThreadNotifyJob, ThreadNotifyPayload{MessageID: reply.ID}, jobs.EnqueueOptions{
+		DedupeKey: ThreadNotifyJob + ":" + reply.ID,
+	}); err != nil {
+		log.Printf("Failed to queue thread notifications for %s: %v", reply.ID, err)
+	}
+}
+
+// NotifyThreadReply notifies the followers of a reply's thread, except its
+// author, anyone who can no longer see the group and those the reply
+// mentions, who are notified of the mention instead.
+func (h *NotificationHandler) NotifyThreadReply(ctx context.Context, payload json.RawMessage) error {
+	var p ThreadNotifyPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return err
+	}
+
+	var rootID, authorID, author, content string
+	var topicID, groupID *string
+	err := h.db.QueryRowContext(ctx, `
+		SELECT m.parent_id, m.user_id, COALESCE(u.display_name, u.username), m.content, m.topic_id, m.group_id
+		FROM messages m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.id = $1 AND m.parent_id IS NOT NULL AND NOT COALESCE(m.is_deleted, false)
+	`, p.MessageID).Scan(&rootID, &authorID, &author, &content, &topicID, &groupID)
+	if err == sql.ErrNoRows {
+		// Deleted before the job ran
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := h.db.QueryContext(ctx, `
+		SELECT f.user_id
+		FROM thread_follows f
+		WHERE f.message_id = $1 AND f.following AND f.user_id <> $2
+		  AND ($3::uuid IS NULL OR EXISTS (
+		      SELECT 1 FROM group_members gm WHERE gm.group_id = $3 AND gm.user_id = f.user_id
+		  ))
+		  AND NOT EXISTS (
+		      SELECT 1 FROM user_blocks b WHERE b.user_id = f.user_id AND b.blocked_user_id = $2
+		  )
+		  AND NOT EXISTS (
+		      SELECT 1 FROM message_mentions mm WHERE mm.message_id = $4 AND mm.user_id = f.user_id
+		  )
+	`, rootID, authorID, groupID, p.MessageID)
+	if err != nil {
+		return err
+	}
+	var followers []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		followers = append(followers, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	link := messageLink(topicID, groupID, rootID)
+	for _, userID := range followers {
+		if err := h.CreateNotification(userID, "thread_reply", "Нова відповідь у гілці", author+": "+notificationSnippet(content), link); err != nil {
+			log.Printf("Failed to notify %s of reply %s: %v", userID, p.MessageID, err)
+		}
+	}
+	return nil
+}
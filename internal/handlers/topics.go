@@ -50,10 +50,13 @@ func (h *TopicHandler) GetTopics(c *gin.Context) {
 	query := `
 		SELECT t.id, t.title, t.description, t.is_public, t.created_by, t.votes_count, t.messages_count,
 		       t.created_at, t.updated_at, u.username, u.display_name, u.avatar_url,
-		       COALESCE(tv.vote_type, '') as user_vote
+		       COALESCE(tv.vote_type, '') as user_vote,
+		       unread.unread_count, unread.mention_count
 		FROM topics t
 		JOIN users u ON t.created_by = u.id
 		LEFT JOIN topic_votes tv ON t.id = tv.topic_id AND tv.user_id = $1
+		LEFT JOIN room_read_cursors rc ON rc.user_id = $1 AND rc.topic_id = t.id
+		CROSS JOIN LATERAL (` + unreadSQL("topic_id", "t.id", "rc.user_id IS NOT NULL") + `) unread
 		WHERE ($2 = false OR t.is_public = true)
 		ORDER BY t.votes_count DESC, t.created_at DESC
 	`
@@ -74,7 +77,7 @@ func (h *TopicHandler) GetTopics(c *gin.Context) {
 			&topic.CreatedBy, &topic.VotesCount, &topic.MessagesCount,
 			&topic.CreatedAt, &topic.UpdatedAt,
 			&user.Username, &user.DisplayName, &user.AvatarURL,
-			&topic.UserVote,
+			&topic.UserVote, &topic.UnreadCount, &topic.MentionCount,
 		)
 		if err != nil {
 			continue
@@ -96,44 +99,68 @@ func (h *TopicHandler) VoteTopic(c *gin.Context) {
 		voteType = "up"
 	}
 
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the topic first: a first vote has no topic_votes row to lock, so
+	// this is what serialises a user's concurrent votes. The trigger
+	// updates this row anyway, so taking it up front also fixes lock order.
+	var lockedID string
+	err = tx.QueryRow("SELECT id FROM topics WHERE id = $1 FOR UPDATE", topicID).Scan(&lockedID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Topic not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+
 	// Check if already voted
 	var existingVote string
-	err := h.db.QueryRow("SELECT vote_type FROM topic_votes WHERE topic_id = $1 AND user_id = $2", topicID, userID).Scan(&existingVote)
+	err = tx.QueryRow("SELECT vote_type FROM topic_votes WHERE topic_id = $1 AND user_id = $2", topicID, userID).Scan(&existingVote)
 
 	if err == sql.ErrNoRows {
 		// New vote
-		_, err = h.db.Exec("INSERT INTO topic_votes (topic_id, user_id, vote_type) VALUES ($1, $2, $3)", topicID, userID, voteType)
+		_, err = tx.Exec("INSERT INTO topic_votes (topic_id, user_id, vote_type) VALUES ($1, $2, $3)", topicID, userID, voteType)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
 			return
 		}
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
 	} else if existingVote == voteType {
 		// Remove vote
-		_, err = h.db.Exec("DELETE FROM topic_votes WHERE topic_id = $1 AND user_id = $2", topicID, userID)
+		_, err = tx.Exec("DELETE FROM topic_votes WHERE topic_id = $1 AND user_id = $2", topicID, userID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove vote"})
 			return
 		}
 	} else {
 		// Update vote
-		_, err = h.db.Exec("UPDATE topic_votes SET vote_type = $1 WHERE topic_id = $2 AND user_id = $3", voteType, topicID, userID)
+		_, err = tx.Exec("UPDATE topic_votes SET vote_type = $1 WHERE topic_id = $2 AND user_id = $3", voteType, topicID, userID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vote"})
 			return
 		}
 	}
 
-	// Update vote count
+	// votes_count is maintained by the topic_votes trigger inside this transaction
 	var votesCount int
-	h.db.QueryRow(`
-		UPDATE topics SET votes_count = (
-			SELECT COUNT(*) FROM topic_votes WHERE topic_id = $1 AND vote_type = 'up'
-		) - (
-			SELECT COUNT(*) FROM topic_votes WHERE topic_id = $1 AND vote_type = 'down'
-		)
-		WHERE id = $1
-		RETURNING votes_count
-	`, topicID).Scan(&votesCount)
+	if err := tx.QueryRow("SELECT votes_count FROM topics WHERE id = $1", topicID).Scan(&votesCount); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to vote"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"votes_count": votesCount})
 }
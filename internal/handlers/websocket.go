@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"psycho-platform/internal/auth"
+	"psycho-platform/internal/websocket"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// ticketProtocol is offered as the first subprotocol by browsers passing
+// the ticket in Sec-WebSocket-Protocol, followed by the ticket itself.
+const ticketProtocol = "ws-ticket"
+
+type WebSocketHandler struct {
+	db        *sql.DB
+	hub       *websocket.Hub
+	tickets   auth.TicketStore
+	jwtSecret string
+	origins   []string
+	upgrader  gorilla.Upgrader
+}
+
+func NewWebSocketHandler(db *sql.DB, hub *websocket.Hub, tickets auth.TicketStore, jwtSecret string, allowedOrigins []string) *WebSocketHandler {
+	h := &WebSocketHandler{
+		db:        db,
+		hub:       hub,
+		tickets:   tickets,
+		jwtSecret: jwtSecret,
+		origins:   allowedOrigins,
+	}
+	h.upgrader = gorilla.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     h.checkOrigin,
+	}
+	return h
+}
+
+// checkOrigin accepts the configured origins and same-host pages (the SPA
+// is also served by this API). Requests without an Origin header come from
+// non-browser clients, which authenticate the same way.
+func (h *WebSocketHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	for _, allowed := range h.origins {
+		if strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// IssueTicket hands out a single-use ticket for opening a WebSocket.
+func (h *WebSocketHandler) IssueTicket(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var isActive bool
+	err := h.db.QueryRow("SELECT is_active FROM users WHERE id = $1", userID).Scan(&isActive)
+	if err != nil || !isActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return
+	}
+
+	ticket, err := h.tickets.Issue(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue ticket"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ticket":     ticket,
+		"expires_in": int(auth.TicketTTL.Seconds()),
+	})
+}
+
+// handshake is what a client offered in Sec-WebSocket-Protocol.
+type handshake struct {
+	ticket    string
+	proto     websocket.Protocol
+	selected  string // the negotiated psycho.vN.* protocol
+	versioned bool   // any psycho.vN.* protocol was offered
+}
+
+// parseSubprotocols picks the first supported protocol in the client's
+// order of preference and extracts a ticket passed as "ws-ticket",
+// "<ticket>". Clients offering no version get the default protocol.
+func parseSubprotocols(offered []string) handshake {
+	hs := handshake{proto: websocket.DefaultProtocol}
+	for i := 0; i < len(offered); i++ {
+		if offered[i] == ticketProtocol && i+1 < len(offered) {
+			hs.ticket = offered[i+1]
+			i++
+			continue
+		}
+		proto, ok := websocket.ParseProtocol(offered[i])
+		if !ok {
+			continue
+		}
+		hs.versioned = true
+		if hs.selected == "" && proto.Supported() {
+			hs.proto, hs.selected = proto, offered[i]
+		}
+	}
+	return hs
+}
+
+// Serve upgrades the connection after authenticating it with a ticket
+// from ?ticket= or the Sec-WebSocket-Protocol header. Native clients may
+// send a Bearer token instead. The protocol version and encoding are
+// negotiated through the same header.
+func (h *WebSocketHandler) Serve(c *gin.Context) {
+	if !h.checkOrigin(c.Request) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+		return
+	}
+
+	hs := parseSubprotocols(gorilla.Subprotocols(c.Request))
+	if hs.versioned && hs.selected == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "Unsupported protocol version",
+			"supported": websocket.SupportedProtocols(),
+		})
+		return
+	}
+
+	ticket := c.Query("ticket")
+	if hs.ticket != "" {
+		ticket = hs.ticket
+	}
+	userID, ok := h.authenticate(c, ticket)
+	if !ok {
+		return
+	}
+
+	// The handshake fails unless the server picks one offered protocol
+	var responseHeader http.Header
+	if hs.selected != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {hs.selected}}
+	} else if hs.ticket != "" {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": {ticketProtocol}}
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, responseHeader)
+	if err != nil {
+		return
+	}
+	websocket.ServeWs(h.hub, conn, userID, hs.proto)
+}
+
+// authenticate resolves the connecting user from a ticket or a Bearer
+// token and checks the account is active. On failure it has already
+// responded.
+func (h *WebSocketHandler) authenticate(c *gin.Context, ticket string) (string, bool) {
+	var userID string
+	switch {
+	case ticket != "":
+		id, err := h.tickets.Redeem(c.Request.Context(), ticket)
+		if err == auth.ErrInvalidTicket {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired ticket"})
+			return "", false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify ticket"})
+			return "", false
+		}
+		userID = id
+	case strings.HasPrefix(c.GetHeader("Authorization"), "Bearer "):
+		claims, err := auth.ValidateToken(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "), h.jwtSecret)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return "", false
+		}
+		userID = claims.UserID
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Ticket required"})
+		return "", false
+	}
+
+	var isActive bool
+	err := h.db.QueryRow("SELECT is_active FROM users WHERE id = $1", userID).Scan(&isActive)
+	if err != nil || !isActive {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled"})
+		return "", false
+	}
+
+	return userID, true
+}
+
+// Events is the Server-Sent Events fallback for networks that block
+// WebSocket upgrades. It delivers the same events; ?rooms= lists the rooms
+// to subscribe to besides the personal ones, and Last-Event-ID (or
+// ?last_event_id= for clients reconnecting by hand with a new ticket)
+// resumes where the previous stream stopped. Tickets stay single-use: the
+// stream sends a fresh one to reconnect with.
+func (h *WebSocketHandler) Events(c *gin.Context) {
+	userID, ok := h.authenticate(c, c.Query("ticket"))
+	if !ok {
+		return
+	}
+
+	var roomIDs []string
+	for _, roomID := range strings.Split(c.Query("rooms"), ",") {
+		if roomID = strings.TrimSpace(roomID); roomID != "" {
+			roomIDs = append(roomIDs, roomID)
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	issue := func(ctx context.Context) (string, time.Duration, error) {
+		ticket, err := h.tickets.Issue(ctx, userID)
+		return ticket, auth.TicketTTL, err
+	}
+	websocket.ServeSSE(c.Request.Context(), h.hub, c.Writer, userID, roomIDs, websocket.ParseCursor(lastEventID), issue)
+}
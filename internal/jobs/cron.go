@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Spec is a parsed schedule: either a five-field cron expression
+// (minute hour day-of-month month day-of-week) or a fixed "@every" interval.
+type Spec struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+	every                         time.Duration
+}
+
+var cronAliases = map[string]string{
+	"@yearly":  "0 0 1 1 *",
+	"@monthly": "0 0 1 * *",
+	"@weekly":  "0 0 * * 0",
+	"@daily":   "0 0 * * *",
+	"@hourly":  "0 * * * *",
+}
+
+// ParseSpec parses expressions such as "*/5 * * * *", "0 9 * * 1",
+// "@daily" or "@every 10m".
+func ParseSpec(expr string) (*Spec, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(expr, "@every ")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", expr, err)
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("interval %q is shorter than a second", expr)
+		}
+		return &Spec{every: d}, nil
+	}
+	if alias, ok := cronAliases[expr]; ok {
+		expr = alias
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	var spec Spec
+	var err error
+	if spec.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if spec.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if spec.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if spec.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if spec.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// Both 0 and 7 mean Sunday
+	if spec.dow&(1<<7) != 0 {
+		spec.dow |= 1
+	}
+	spec.domStar = fields[2] == "*"
+	spec.dowStar = fields[4] == "*"
+	if !spec.fires() {
+		return nil, fmt.Errorf("cron expression %q never fires", expr)
+	}
+
+	return &spec, nil
+}
+
+// daysInMonth is the longest each month gets, February in leap years.
+var daysInMonth = [13]int{0, 31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+
+// fires reports whether some date matches. Only a day-of-month restricted
+// on its own can miss every allowed month, as in "0 0 31 2 *".
+func (s *Spec) fires() bool {
+	if s.domStar || !s.dowStar {
+		return true
+	}
+	for m := 1; m <= 12; m++ {
+		if s.month&(1<<uint(m)) == 0 {
+			continue
+		}
+		for d := 1; d <= daysInMonth[m]; d++ {
+			if s.dom&(1<<uint(d)) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseField turns "*", "*/n", "a", "a-b", "a-b/n" and comma lists of
+// those into a bitmask of allowed values.
+func parseField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", field)
+			}
+			step = n
+			part = part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", field)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid range in %q", field)
+				}
+			} else if step > 1 {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value out of range in %q", field)
+		}
+
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// Next returns the first activation strictly after t, evaluated in t's
+// location. ParseSpec only accepts specs that fire, and every date recurs
+// within five years, so the zero time returned past that bound is never
+// reached.
+func (s *Spec) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// MinGap returns the shortest time between two activations, taking every
+// day as firing; a day's last activation is followed by the next day's
+// first. Daylight saving shifts are not accounted for.
+func (s *Spec) MinGap() time.Duration {
+	if s.every > 0 {
+		return s.every
+	}
+
+	const day = 24 * 60
+	gap, first, prev := day, -1, -1
+	for h := 0; h < 24; h++ {
+		if s.hour&(1<<uint(h)) == 0 {
+			continue
+		}
+		for m := 0; m < 60; m++ {
+			if s.minute&(1<<uint(m)) == 0 {
+				continue
+			}
+			at := h*60 + m
+			if first < 0 {
+				first = at
+			} else if at-prev < gap {
+				gap = at - prev
+			}
+			prev = at
+		}
+	}
+	if first+day-prev < gap {
+		gap = first + day - prev
+	}
+	return time.Duration(gap) * time.Minute
+}
+
+// dayMatches follows cron semantics: when both day-of-month and
+// day-of-week are restricted, either one matching is enough.
+func (s *Spec) dayMatches(t time.Time) bool {
+	domOK := s.dom&(1<<uint(t.Day())) != 0
+	dowOK := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domOK && dowOK
+	}
+	return domOK || dowOK
+}
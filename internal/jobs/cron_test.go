@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/5 * * * *", false},
+		{"0 9 * * 1-5", false},
+		{"0,30 8-18/2 1,15 * 0", false},
+		{"0 0 29 2 *", false},
+		{"0 0 31 2 1", false}, // either field matching is enough
+		{"@daily", false},
+		{"@every 10m", false},
+		{"", true},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+		{"0 0 31 2 *", true},
+		{"0 0 30,31 2 *", true},
+		{"0 0 31 4,6,9,11 *", true},
+		{"@every 1ms", true},
+		{"@every soon", true},
+	}
+	for _, tt := range tests {
+		_, err := ParseSpec(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSpec(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSpecNext(t *testing.T) {
+	kyiv, err := time.LoadLocation("Europe/Kyiv")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	at := func(s string, loc *time.Location) time.Time {
+		v, err := time.ParseInLocation("2006-01-02 15:04", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", at("2024-01-01 10:00", time.UTC), at("2024-01-01 10:01", time.UTC)},
+		{"*/15 * * * *", at("2024-01-01 10:07", time.UTC), at("2024-01-01 10:15", time.UTC)},
+		{"0 9 * * 1", at("2024-01-01 09:00", time.UTC), at("2024-01-08 09:00", time.UTC)},
+		{"30 23 31 * *", at("2024-02-01 00:00", time.UTC), at("2024-03-31 23:30", time.UTC)},
+		{"0 0 29 2 *", at("2024-03-01 00:00", time.UTC), at("2028-02-29 00:00", time.UTC)},
+		{"0 0 1 * 0", at("2024-01-01 00:00", time.UTC), at("2024-01-07 00:00", time.UTC)},
+		{"0 9 * * *", at("2024-03-30 12:00", kyiv), at("2024-03-31 09:00", kyiv)},
+		{"@every 90s", at("2024-01-01 10:00", time.UTC), at("2024-01-01 10:00", time.UTC).Add(90 * time.Second)},
+	}
+	for _, tt := range tests {
+		spec, err := ParseSpec(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseSpec(%q): %v", tt.expr, err)
+		}
+		if got := spec.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q.Next(%v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestSpecMinGap(t *testing.T) {
+	tests := []struct {
+		expr string
+		want time.Duration
+	}{
+		{"* * * * *", time.Minute},
+		{"0 * * * *", time.Hour},
+		{"0 9 * * 1", 24 * time.Hour},
+		{"0 9,17 * * *", 8 * time.Hour},
+		{"0 9 * * *", 24 * time.Hour},
+		{"0,30 9 * * *", 30 * time.Minute},
+		{"0 0,10 * * *", 10 * time.Hour},
+		{"50 23 * * *", 24 * time.Hour},
+		{"10,50 0,23 * * *", 20 * time.Minute},
+		{"0 */2 * * *", 2 * time.Hour},
+		{"@every 90m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		spec, err := ParseSpec(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseSpec(%q): %v", tt.expr, err)
+		}
+		if got := spec.MinGap(); got != tt.want {
+			t.Errorf("%q.MinGap() = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusDead    = "dead"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is a single unit of background work stored in the jobs table.
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedBy    *string         `json:"locked_by,omitempty"`
+	LastError   *string         `json:"last_error,omitempty"`
+	DedupeKey   *string         `json:"dedupe_key,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+}
+
+// EnqueueOptions tune a single Enqueue call. The zero value runs the job
+// as soon as possible with the default retry budget.
+type EnqueueOptions struct {
+	RunAt       time.Time
+	MaxAttempts int
+	// DedupeKey makes Enqueue a no-op when a job with the same key exists,
+	// which is how schedules avoid double-enqueueing across replicas.
+	DedupeKey string
+}
+
+const defaultMaxAttempts = 5
+
+// Queue is a durable Postgres-backed job queue. Workers on any number of
+// API replicas claim jobs with FOR UPDATE SKIP LOCKED, so each job is
+// executed by exactly one worker at a time.
+type Queue struct {
+	db *sql.DB
+}
+
+func NewQueue(db *sql.DB) *Queue {
+	return &Queue{db: db}
+}
+
+// Enqueue stores a job. It returns the job ID, or an empty string when the
+// dedupe key already exists.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload interface{}, opts EnqueueOptions) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	var runAt interface{}
+	if !opts.RunAt.IsZero() {
+		runAt = opts.RunAt
+	}
+
+	var dedupeKey interface{}
+	if opts.DedupeKey != "" {
+		dedupeKey = opts.DedupeKey
+	}
+
+	var id string
+	err = q.db.QueryRowContext(ctx, `
+		INSERT INTO jobs (kind, payload, max_attempts, run_at, dedupe_key)
+		VALUES ($1, $2, $3, COALESCE($4::timestamptz, NOW()), $5)
+		ON CONFLICT (dedupe_key) DO NOTHING
+		RETURNING id
+	`, kind, data, maxAttempts, runAt, dedupeKey).Scan(&id)
+
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// claim locks the next runnable job for workerID. Jobs whose lease expired
+// (the worker crashed mid-run) are picked up again while they have attempts
+// left, and move to the dead-letter state otherwise, so a job that keeps
+// crashing its worker is not retried forever.
+func (q *Queue) claim(ctx context.Context, workerID string, lease time.Duration) (*Job, error) {
+	var job Job
+	err := q.db.QueryRowContext(ctx, `
+		WITH exhausted AS (
+			UPDATE jobs
+			SET status = 'dead', locked_by = NULL, locked_until = NULL,
+			    last_error = 'lease expired on the final attempt',
+			    finished_at = NOW(), updated_at = NOW()
+			WHERE status = 'running' AND locked_until < NOW() AND attempts >= max_attempts
+		)
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_by = $1,
+		    locked_until = NOW() + make_interval(secs => $2), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' AND run_at <= NOW())
+			   OR (status = 'running' AND locked_until < NOW() AND attempts < max_attempts)
+			ORDER BY run_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, kind, payload, status, attempts, max_attempts, run_at, created_at
+	`, workerID, lease.Seconds()).Scan(
+		&job.ID, &job.Kind, &job.Payload, &job.Status,
+		&job.Attempts, &job.MaxAttempts, &job.RunAt, &job.CreatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *Queue) complete(ctx context.Context, job *Job, workerID string) error {
+	_, err := q.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = 'done', locked_by = NULL, locked_until = NULL,
+		    last_error = NULL, finished_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2
+	`, job.ID, workerID)
+	return err
+}
+
+// fail schedules a retry after backoff, or moves the job to the dead-letter
+// state once its attempts are exhausted.
+func (q *Queue) fail(ctx context.Context, job *Job, workerID string, jobErr error, backoff time.Duration) error {
+	_, err := q.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'pending' END,
+		    run_at = NOW() + make_interval(secs => $3),
+		    finished_at = CASE WHEN attempts >= max_attempts THEN NOW() ELSE NULL END,
+		    locked_by = NULL, locked_until = NULL,
+		    last_error = $4, updated_at = NOW()
+		WHERE id = $1 AND locked_by = $2
+	`, job.ID, workerID, backoff.Seconds(), jobErr.Error())
+	return err
+}
+
+// List returns the most recent jobs, optionally filtered by status and kind.
+func (q *Queue) List(ctx context.Context, status, kind string, limit int) ([]Job, error) {
+	rows, err := q.db.QueryContext(ctx, `
+		SELECT id, kind, payload, status, attempts, max_attempts, run_at,
+		       locked_by, last_error, dedupe_key, created_at, finished_at
+		FROM jobs
+		WHERE ($1 = '' OR status = $1)
+		  AND ($2 = '' OR kind = $2)
+		ORDER BY created_at DESC
+		LIMIT $3
+	`, status, kind, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		var job Job
+		if err := rows.Scan(
+			&job.ID, &job.Kind, &job.Payload, &job.Status, &job.Attempts,
+			&job.MaxAttempts, &job.RunAt, &job.LockedBy, &job.LastError,
+			&job.DedupeKey, &job.CreatedAt, &job.FinishedAt,
+		); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// Retry resets a dead or pending job so it runs again immediately with a
+// fresh retry budget.
+func (q *Queue) Retry(ctx context.Context, id string) error {
+	res, err := q.db.ExecContext(ctx, `
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, run_at = NOW(),
+		    finished_at = NULL, locked_by = NULL, locked_until = NULL, updated_at = NOW()
+		WHERE id = $1 AND status IN ('dead', 'pending')
+	`, id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// Prune deletes finished jobs older than the retention period. Dead jobs
+// are kept until an admin retries or removes them.
+func (q *Queue) Prune(ctx context.Context, retention time.Duration) (int64, error) {
+	res, err := q.db.ExecContext(ctx, `
+		DELETE FROM jobs
+		WHERE status = 'done' AND finished_at < NOW() - make_interval(secs => $1)
+	`, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,347 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
+// HandlerFunc processes one job. Returning an error schedules a retry.
+// The context is cancelled when the job's lease runs out or on shutdown.
+type HandlerFunc func(ctx context.Context, payload json.RawMessage) error
+
+// Schedule enqueues a job of Kind whenever Spec fires.
+type Schedule struct {
+	Name    string      `json:"name"`
+	Spec    string      `json:"spec"`
+	Kind    string      `json:"kind"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// RunnerOptions configure a Runner. Zero values fall back to defaults.
+type RunnerOptions struct {
+	Workers      int
+	PollInterval time.Duration
+	Lease        time.Duration
+}
+
+// Runner executes queued jobs with a pool of workers and enqueues
+// scheduled jobs. Several replicas can run a Runner against the same
+// database: claims use SKIP LOCKED and schedule ticks are deduplicated.
+type Runner struct {
+	db       *sql.DB
+	queue    *Queue
+	workerID string
+	opts     RunnerOptions
+
+	mu        sync.RWMutex
+	handlers  map[string]HandlerFunc
+	schedules []Schedule
+}
+
+func NewRunner(db *sql.DB, queue *Queue, opts RunnerOptions) *Runner {
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = 5 * time.Minute
+	}
+
+	hostname, _ := os.Hostname()
+	return &Runner{
+		db:       db,
+		queue:    queue,
+		workerID: fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		opts:     opts,
+		handlers: make(map[string]HandlerFunc),
+	}
+}
+
+// Register binds a handler to a job kind.
+func (r *Runner) Register(kind string, handler HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[kind] = handler
+}
+
+// Schedule registers a recurring job. The schedule row is upserted when
+// the runner starts, so changing a spec in code takes effect on deploy.
+func (r *Runner) Schedule(s Schedule) error {
+	if _, err := ParseSpec(s.Spec); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedules = append(r.schedules, s)
+	return nil
+}
+
+// Run starts the workers and the scheduler and blocks until ctx is done
+// and every in-flight job has returned.
+func (r *Runner) Run(ctx context.Context) {
+	if err := r.syncSchedules(ctx); err != nil {
+		log.Printf("Failed to sync job schedules: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.opts.Workers; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			r.work(ctx, fmt.Sprintf("%s/%d", r.workerID, n))
+		}(i)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.schedule(ctx)
+	}()
+
+	wg.Wait()
+}
+
+func (r *Runner) work(ctx context.Context, workerID string) {
+	for {
+		job, err := r.queue.claim(ctx, workerID, r.opts.Lease)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Job claim failed: %v", err)
+		}
+
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(r.opts.PollInterval):
+			}
+			continue
+		}
+
+		r.execute(ctx, job, workerID)
+	}
+}
+
+func (r *Runner) execute(ctx context.Context, job *Job, workerID string) {
+	r.mu.RLock()
+	handler, ok := r.handlers[job.Kind]
+	r.mu.RUnlock()
+
+	// Finish bookkeeping even when shutdown cancelled ctx mid-job
+	bookkeeping := context.Background()
+
+	var jobErr error
+	if !ok {
+		jobErr = fmt.Errorf("no handler registered for %q", job.Kind)
+	} else {
+		jobCtx, cancel := context.WithTimeout(ctx, r.opts.Lease)
+		jobErr = runSafely(jobCtx, handler, job.Payload)
+		cancel()
+	}
+
+	if jobErr == nil {
+		if err := r.queue.complete(bookkeeping, job, workerID); err != nil {
+			log.Printf("Failed to complete job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	if job.Attempts >= job.MaxAttempts {
+		log.Printf("Job %s (%s) moved to dead letters after %d attempts: %v", job.ID, job.Kind, job.Attempts, jobErr)
+	} else {
+		log.Printf("Job %s (%s) failed, attempt %d/%d: %v", job.ID, job.Kind, job.Attempts, job.MaxAttempts, jobErr)
+	}
+	if err := r.queue.fail(bookkeeping, job, workerID, jobErr, backoff(job.Attempts)); err != nil {
+		log.Printf("Failed to record job failure %s: %v", job.ID, err)
+	}
+}
+
+func runSafely(ctx context.Context, handler HandlerFunc, payload json.RawMessage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return handler(ctx, payload)
+}
+
+// backoff grows exponentially from 10 seconds to at most an hour, with
+// jitter so retries from a failed batch spread out.
+func backoff(attempt int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempt && d < time.Hour; i++ {
+		d *= 2
+	}
+	if d > time.Hour {
+		d = time.Hour
+	}
+	return d + time.Duration(rand.Int63n(int64(d/4)+1))
+}
+
+// syncSchedules upserts the registered schedules. next_run_at is only
+// recomputed when the spec changed, so restarts don't skip or repeat ticks.
+func (r *Runner) syncSchedules(ctx context.Context) error {
+	r.mu.RLock()
+	schedules := append([]Schedule(nil), r.schedules...)
+	r.mu.RUnlock()
+
+	now := time.Now().UTC()
+	for _, s := range schedules {
+		spec, _ := ParseSpec(s.Spec)
+		payload, err := json.Marshal(s.Payload)
+		if err != nil {
+			return err
+		}
+
+		_, err = r.db.ExecContext(ctx, `
+			INSERT INTO job_schedules (name, spec, kind, payload, next_run_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (name) DO UPDATE
+			SET kind = EXCLUDED.kind,
+			    payload = EXCLUDED.payload,
+			    next_run_at = CASE WHEN job_schedules.spec = EXCLUDED.spec
+			                       THEN job_schedules.next_run_at
+			                       ELSE EXCLUDED.next_run_at END,
+			    spec = EXCLUDED.spec,
+			    updated_at = NOW()
+		`, s.Name, s.Spec, s.Kind, payload, spec.Next(now))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Runner) schedule(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.enqueueDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Job scheduler failed: %v", err)
+		}
+	}
+}
+
+// enqueueDue enqueues one job per due schedule. The schedule row is locked
+// for the duration of the transaction and the job carries a dedupe key of
+// name@tick, so a tick is enqueued once no matter how many replicas run.
+func (r *Runner) enqueueDue(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT name, spec, kind, payload, next_run_at
+		FROM job_schedules
+		WHERE next_run_at <= NOW()
+		FOR UPDATE SKIP LOCKED
+	`)
+	if err != nil {
+		return err
+	}
+
+	type due struct {
+		name, spec, kind string
+		payload          []byte
+		tick             time.Time
+	}
+	var dues []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.name, &d.spec, &d.kind, &d.payload, &d.tick); err != nil {
+			rows.Close()
+			return err
+		}
+		dues = append(dues, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, d := range dues {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO jobs (kind, payload, dedupe_key)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (dedupe_key) DO NOTHING
+		`, d.kind, d.payload, d.name+"@"+d.tick.UTC().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+
+		// Missed ticks (e.g. during downtime) collapse into this one run
+		var next time.Time
+		if spec, err := ParseSpec(d.spec); err == nil {
+			next = spec.Next(now)
+		} else {
+			log.Printf("Invalid schedule %s: %v", d.name, err)
+			next = now.Add(24 * time.Hour)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE job_schedules SET next_run_at = $1, last_run_at = NOW(), updated_at = NOW()
+			WHERE name = $2
+		`, next, d.name); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Schedules lists the stored schedules with their next activation.
+func (r *Runner) Schedules(ctx context.Context) ([]map[string]interface{}, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT name, spec, kind, next_run_at, last_run_at
+		FROM job_schedules
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []map[string]interface{}{}
+	for rows.Next() {
+		var name, spec, kind string
+		var nextRunAt time.Time
+		var lastRunAt sql.NullTime
+		if err := rows.Scan(&name, &spec, &kind, &nextRunAt, &lastRunAt); err != nil {
+			return nil, err
+		}
+
+		schedule := map[string]interface{}{
+			"name":        name,
+			"spec":        spec,
+			"kind":        kind,
+			"next_run_at": nextRunAt,
+			"last_run_at": nil,
+		}
+		if lastRunAt.Valid {
+			schedule["last_run_at"] = lastRunAt.Time
+		}
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules, rows.Err()
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"psycho-platform/internal/database"
+	"psycho-platform/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadYourWrites pins a user's reads to the primary for a short window
+// after any successful write request, so replica lag never hides their
+// own changes.
+func ReadYourWrites(db *database.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return
+		}
+
+		if c.Writer.Status() < http.StatusBadRequest {
+			db.MarkWrite(c.GetString("user_id"))
+		}
+	}
+}
+
+// ReadYourWritesFrame does the same for a WebSocket frame handler that
+// writes, such as send_message.
+func ReadYourWritesFrame(db *database.DB, fn websocket.FrameHandler) websocket.FrameHandler {
+	return func(ctx context.Context, userID string, payload json.RawMessage) (interface{}, error) {
+		result, err := fn(ctx, userID, payload)
+		if err == nil {
+			db.MarkWrite(userID)
+		}
+		return result, err
+	}
+}
@@ -3,17 +3,32 @@ package models
 import "time"
 
 type Group struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	Description  string    `json:"description"`
-	AvatarURL    string    `json:"avatar_url"`
-	IsPrivate    bool      `json:"is_private"`
-	CreatedBy    string    `json:"created_by"`
-	MembersCount int       `json:"members_count"`
-	IsMember     bool      `json:"is_member,omitempty"`
-	Role         string    `json:"role,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	AvatarURL     string `json:"avatar_url"`
+	IsPrivate     bool   `json:"is_private"`
+	CreatedBy     string `json:"created_by"`
+	MembersCount  int    `json:"members_count"`
+	MessagesCount int    `json:"messages_count"`
+	IsMember      bool   `json:"is_member,omitempty"`
+	Role          string `json:"role,omitempty"`
+	UnreadCount   int    `json:"unread_count"`
+	MentionCount  int    `json:"mention_count"`
+	// AllowedReactions restricts the emoji members may react with; empty
+	// means any emoji.
+	AllowedReactions    []string  `json:"allowed_reactions,omitempty"`
+	LinkPreviewsEnabled bool      `json:"link_previews_enabled"`
+	CreatedAt           time.Time `json:"created_at"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+type SetAllowedReactionsRequest struct {
+	Allowed []string `json:"allowed"`
+}
+
+type SetLinkPreviewsRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
 }
 
 type CreateGroupRequest struct {
@@ -3,19 +3,85 @@ package models
 import "time"
 
 type Message struct {
-	ID              string     `json:"id"`
-	Content         string     `json:"content"`
-	TopicID         *string    `json:"topic_id,omitempty"`
-	GroupID         *string    `json:"group_id,omitempty"`
-	UserID          string     `json:"user_id"`
-	User            *User      `json:"user,omitempty"`
-	ParentID        *string    `json:"parent_id,omitempty"`
-	QuotedMessageID *string    `json:"quoted_message_id,omitempty"`
-	QuotedMessage   *Message   `json:"quoted_message,omitempty"`
-	IsEdited        bool       `json:"is_edited"`
-	EditedAt        *time.Time `json:"edited_at,omitempty"`
-	Reactions       []Reaction `json:"reactions,omitempty"`
-	CreatedAt       time.Time  `json:"created_at"`
+	ID              string            `json:"id"`
+	Content         string            `json:"content"`
+	TopicID         *string           `json:"topic_id,omitempty"`
+	GroupID         *string           `json:"group_id,omitempty"`
+	UserID          string            `json:"user_id"`
+	User            *User             `json:"user,omitempty"`
+	ParentID        *string           `json:"parent_id,omitempty"`
+	QuotedMessageID *string           `json:"quoted_message_id,omitempty"`
+	QuotedMessage   *Message          `json:"quoted_message,omitempty"`
+	IsEdited        bool              `json:"is_edited"`
+	EditedAt        *time.Time        `json:"edited_at,omitempty"`
+	IsDeleted       bool              `json:"is_deleted"`
+	Reactions       []ReactionSummary `json:"reactions"`
+	Attachments     []Attachment      `json:"attachments"`
+	LinkPreviews    []LinkPreview     `json:"link_previews"`
+	Poll            *Poll             `json:"poll,omitempty"`
+	ReplyCount      int               `json:"reply_count"`
+	LastReplyAt     *time.Time        `json:"last_reply_at,omitempty"`
+	ClientMsgID     string            `json:"client_msg_id,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+}
+
+// MessageRevision is one version of a message's content. The first is
+// the original; EditedBy is whoever wrote that version.
+type MessageRevision struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"message_id"`
+	Content   string    `json:"content"`
+	EditedBy  *string   `json:"edited_by"`
+	Editor    *User     `json:"editor,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MessageDeletion keeps the content of a deleted message for moderators.
+type MessageDeletion struct {
+	MessageID string    `json:"message_id"`
+	Content   string    `json:"content"`
+	Reason    *string   `json:"reason"`
+	DeletedBy *string   `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// PinnedMessage is a message pinned in a topic or group, at Position in
+// the room's pins counting from 0.
+type PinnedMessage struct {
+	Message  Message   `json:"message"`
+	Position int       `json:"position"`
+	PinnedBy *string   `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// ScheduledMessage is a topic or group message to be posted at SendAt, and
+// again at each activation of Recurrence, a cron expression evaluated in
+// Timezone. Status is scheduled, sent, cancelled or failed.
+type ScheduledMessage struct {
+	ID            string     `json:"id"`
+	UserID        string     `json:"user_id"`
+	TopicID       *string    `json:"topic_id,omitempty"`
+	GroupID       *string    `json:"group_id,omitempty"`
+	Content       string     `json:"content"`
+	SendAt        time.Time  `json:"send_at"`
+	Recurrence    *string    `json:"recurrence"`
+	Timezone      string     `json:"timezone"`
+	Status        string     `json:"status"`
+	SentCount     int        `json:"sent_count"`
+	LastSentAt    *time.Time `json:"last_sent_at,omitempty"`
+	LastMessageID *string    `json:"last_message_id,omitempty"`
+	LastError     *string    `json:"last_error,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Thread is a root message with a page of its replies, oldest first.
+// NextCursor is passed as ?after= to fetch the next page.
+type Thread struct {
+	Root       Message   `json:"root"`
+	Replies    []Message `json:"replies"`
+	NextCursor *string   `json:"next_cursor"`
+	Following  bool      `json:"following"`
 }
 
 type CreateMessageRequest struct {
@@ -24,6 +90,66 @@ type CreateMessageRequest struct {
 	GroupID         *string `json:"group_id"`
 	ParentID        *string `json:"parent_id"`
 	QuotedMessageID *string `json:"quoted_message_id"`
+	// ClientMsgID is chosen by the client to deduplicate retried sends
+	ClientMsgID string `json:"client_msg_id"`
+	// Poll makes the message a poll; Content is its question.
+	Poll *CreatePollRequest `json:"poll"`
+}
+
+// Poll results visibility.
+const (
+	PollResultsAlways     = "always"
+	PollResultsAfterVote  = "after_vote"
+	PollResultsAfterClose = "after_close"
+)
+
+// Poll is attached to the message that asks its question. Votes are only
+// filled in when ResultsVisible, per Results; voters are never shown for
+// anonymous polls.
+type Poll struct {
+	ID             string       `json:"id"`
+	MessageID      string       `json:"message_id"`
+	MultipleChoice bool         `json:"multiple_choice"`
+	Anonymous      bool         `json:"anonymous"`
+	Results        string       `json:"results"`
+	ClosesAt       *time.Time   `json:"closes_at,omitempty"`
+	ClosedAt       *time.Time   `json:"closed_at,omitempty"`
+	IsClosed       bool         `json:"is_closed"`
+	ResultsVisible bool         `json:"results_visible"`
+	TotalVoters    int          `json:"total_voters"`
+	MyVotes        []string     `json:"my_votes"`
+	Options        []PollOption `json:"options"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+type PollOption struct {
+	ID     string `json:"id"`
+	Text   string `json:"text"`
+	Votes  *int   `json:"votes,omitempty"`
+	Voters []User `json:"voters,omitempty"`
+}
+
+type CreatePollRequest struct {
+	Options        []string `json:"options"`
+	MultipleChoice bool     `json:"multiple_choice"`
+	Anonymous      bool     `json:"anonymous"`
+	// Results is always (the default), after_vote or after_close
+	Results  string     `json:"results"`
+	ClosesAt *time.Time `json:"closes_at"`
+}
+
+type PollVoteRequest struct {
+	OptionIDs []string `json:"option_ids" binding:"required"`
+}
+
+type DirectMessage struct {
+	ID             string    `json:"id"`
+	ConversationID string    `json:"conversation_id"`
+	SenderID       string    `json:"sender_id"`
+	Content        string    `json:"content"`
+	IsRead         bool      `json:"is_read"`
+	ClientMsgID    string    `json:"client_msg_id,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
 }
 
 type Reaction struct {
@@ -35,6 +161,33 @@ type Reaction struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// ReactionSummary aggregates all reactions with the same emoji on a message.
+type ReactionSummary struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reacted_by_me"`
+	SampleUsers []User `json:"sample_users"`
+}
+
+// LinkPreview is the card shown for a link in a message.
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+type Attachment struct {
+	ID           string    `json:"id"`
+	MessageID    string    `json:"message_id"`
+	OriginalName string    `json:"original_name"`
+	FileType     string    `json:"file_type"`
+	FileSize     int64     `json:"file_size"`
+	FileURL      string    `json:"file_url"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
 type AddReactionRequest struct {
 	Emoji string `json:"emoji" binding:"required"`
 }
@@ -12,6 +12,8 @@ type Topic struct {
 	VotesCount    int       `json:"votes_count"`
 	MessagesCount int       `json:"messages_count"`
 	UserVote      string    `json:"user_vote,omitempty"`
+	UnreadCount   int       `json:"unread_count"`
+	MentionCount  int       `json:"mention_count"`
 	CreatedAt     time.Time `json:"created_at"`
 	UpdatedAt     time.Time `json:"updated_at"`
 }
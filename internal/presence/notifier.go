@@ -0,0 +1,134 @@
+package presence
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"psycho-platform/internal/websocket"
+	"time"
+)
+
+// Notifier records presence changes in user_status and pushes presence
+// events to the users who can see them: conversation partners and fellow
+// group members, minus anyone on either side of a block.
+type Notifier struct {
+	db  *sql.DB
+	hub *websocket.Hub
+}
+
+func NewNotifier(db *sql.DB, hub *websocket.Hub) *Notifier {
+	return &Notifier{db: db, hub: hub}
+}
+
+// Changed is registered with Hub.OnPresenceChange.
+func (n *Notifier) Changed(userID string, p websocket.UserPresence) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var lastSeen time.Time
+	err := n.db.QueryRowContext(ctx, `
+		INSERT INTO user_status (user_id, is_online, presence, last_seen, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE
+		SET is_online = EXCLUDED.is_online,
+		    presence = EXCLUDED.presence,
+		    last_seen = CURRENT_TIMESTAMP,
+		    updated_at = CURRENT_TIMESTAMP
+		RETURNING last_seen
+	`, userID, p.Status != websocket.StatusOffline, p.Status).Scan(&lastSeen)
+	if err != nil {
+		log.Printf("Failed to record presence for %s: %v", userID, err)
+		return
+	}
+
+	watchers, err := n.watchers(ctx, userID)
+	if err != nil {
+		log.Printf("Failed to load presence watchers for %s: %v", userID, err)
+		return
+	}
+	if len(watchers) == 0 {
+		return
+	}
+
+	event := websocket.PresenceEvent{UserID: userID, Status: p.Status, LastSeen: lastSeen}
+	// Only watchers with an open connection can receive the event
+	online := n.hub.Online(ctx, watchers)
+	ids := make([]string, 0, len(online))
+	for id := range online {
+		ids = append(ids, id)
+	}
+	n.hub.BroadcastToUsers(ids, event)
+}
+
+func (n *Notifier) watchers(ctx context.Context, userID string) ([]string, error) {
+	rows, err := n.db.QueryContext(ctx, `
+		SELECT related.id FROM (
+			SELECT CASE WHEN c.user1_id = $1 THEN c.user2_id ELSE c.user1_id END AS id
+			FROM conversations c
+			WHERE c.user1_id = $1 OR c.user2_id = $1
+			UNION
+			SELECT other.user_id
+			FROM group_members mine
+			JOIN group_members other ON other.group_id = mine.group_id
+			WHERE mine.user_id = $1 AND other.user_id != $1
+		) related
+		WHERE NOT EXISTS (
+			SELECT 1 FROM user_blocks b
+			WHERE (b.user_id = $1 AND b.blocked_user_id = related.id)
+			   OR (b.user_id = related.id AND b.blocked_user_id = $1)
+		)
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Reconcile marks users offline whose connections vanished without a
+// disconnect, e.g. when their node crashed. It runs as the
+// presence.reconcile background job.
+func (n *Notifier) Reconcile(ctx context.Context) error {
+	rows, err := n.db.QueryContext(ctx, `SELECT user_id FROM user_status WHERE is_online = true`)
+	if err != nil {
+		return err
+	}
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	current, err := n.hub.Presence(ctx, ids)
+	if err != nil {
+		// A partial view would mark users on other nodes offline
+		return err
+	}
+
+	for _, id := range ids {
+		if _, ok := current[id]; !ok {
+			n.Changed(id, websocket.UserPresence{Status: websocket.StatusOffline})
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package rooms
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Room name prefixes used by the WebSocket hub.
+const (
+	TopicPrefix        = "topic_"
+	GroupPrefix        = "group_"
+	ConversationPrefix = "conversation_"
+	UserPrefix         = "user_"
+	DMPrefix           = "dm_"
+)
+
+func Topic(id string) string        { return TopicPrefix + id }
+func Group(id string) string        { return GroupPrefix + id }
+func Conversation(id string) string { return ConversationPrefix + id }
+func User(id string) string         { return UserPrefix + id }
+func DM(id string) string           { return DMPrefix + id }
+
+// Parse splits a room name into its prefix and UUID. ok is false for
+// unknown prefixes and malformed IDs.
+func Parse(room string) (prefix, id string, ok bool) {
+	for _, p := range []string{TopicPrefix, GroupPrefix, ConversationPrefix, UserPrefix, DMPrefix} {
+		if strings.HasPrefix(room, p) {
+			id = strings.TrimPrefix(room, p)
+			if _, err := uuid.Parse(id); err != nil {
+				return "", "", false
+			}
+			return p, id, true
+		}
+	}
+	return "", "", false
+}
+
+// Authorizer checks room membership against the database before a
+// WebSocket client may join a room.
+type Authorizer struct {
+	db *sql.DB
+}
+
+func NewAuthorizer(db *sql.DB) *Authorizer {
+	return &Authorizer{db: db}
+}
+
+// CanJoin reports whether userID may receive events for room:
+//   - user_<id> and dm_<id>: only the user themself
+//   - topic_<id>: public topics, or private topics the user created
+//   - group_<id>: group members
+//   - conversation_<id>: both participants, unless either blocked the other
+func (a *Authorizer) CanJoin(ctx context.Context, userID, room string) (bool, error) {
+	prefix, id, ok := Parse(room)
+	if !ok {
+		return false, nil
+	}
+
+	var allowed bool
+	var err error
+	switch prefix {
+	case UserPrefix, DMPrefix:
+		return id == userID, nil
+
+	case TopicPrefix:
+		err = a.db.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM topics
+				WHERE id = $1 AND (COALESCE(is_public, true) OR created_by = $2)
+			)
+		`, id, userID).Scan(&allowed)
+
+	case GroupPrefix:
+		err = a.db.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM group_members
+				WHERE group_id = $1 AND user_id = $2
+			)
+		`, id, userID).Scan(&allowed)
+
+	case ConversationPrefix:
+		err = a.db.QueryRowContext(ctx, `
+			SELECT EXISTS(
+				SELECT 1 FROM conversations c
+				WHERE c.id = $1 AND (c.user1_id = $2 OR c.user2_id = $2)
+				  AND NOT EXISTS (
+				      SELECT 1 FROM user_blocks b
+				      WHERE (b.user_id = c.user1_id AND b.blocked_user_id = c.user2_id)
+				         OR (b.user_id = c.user2_id AND b.blocked_user_id = c.user1_id)
+				  )
+			)
+		`, id, userID).Scan(&allowed)
+	}
+
+	return allowed, err
+}
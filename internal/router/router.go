@@ -1,31 +1,28 @@
 package router
 
 import (
-	"database/sql"
-	"net/http"
+	"expvar"
+	"psycho-platform/internal/auth"
 	"psycho-platform/internal/config"
+	"psycho-platform/internal/database"
 	"psycho-platform/internal/handlers"
+	"psycho-platform/internal/jobs"
 	"psycho-platform/internal/middleware"
 	"psycho-platform/internal/websocket"
 
 	"github.com/gin-gonic/gin"
-	gorilla "github.com/gorilla/websocket"
 	"github.com/redis/go-redis/v9"
 )
 
-var upgrader = gorilla.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
-
-func Setup(db *sql.DB, redis *redis.Client, hub *websocket.Hub, cfg *config.Config) *gin.Engine {
+func Setup(cluster *database.DB, redis *redis.Client, hub *websocket.Hub, jobQueue *jobs.Queue, jobRunner *jobs.Runner, cfg *config.Config) *gin.Engine {
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Most handlers read and write the primary; the read-heavy ones below
+	// take the cluster and route their queries to replicas.
+	db := cluster.DB
+
 	r := gin.New()
 	r.Use(middleware.Recovery())
 	r.Use(middleware.Logger())
@@ -46,36 +43,54 @@ func Setup(db *sql.DB, redis *redis.Client, hub *websocket.Hub, cfg *config.Conf
 	})
 
 	// Health checks
-	healthHandler := handlers.NewHealthHandler(db, redis)
+	healthHandler := handlers.NewHealthHandler(cluster, redis, hub)
 	r.GET("/health", healthHandler.Check)
 	r.GET("/ready", healthHandler.Ready)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, cfg)
 	topicHandler := handlers.NewTopicHandler(db)
-	messageHandler := handlers.NewMessageHandler(db, hub)
-	groupHandler := handlers.NewGroupHandler(db)
+	messageHandler := handlers.NewMessageHandler(db, hub, jobQueue)
+	groupHandler := handlers.NewGroupHandler(db, hub)
 	sessionHandler := handlers.NewSessionHandler(db, cfg)
 	appointmentHandler := handlers.NewAppointmentHandler(db)
-	adminHandler := handlers.NewAdminHandler(db)
-	profileHandler := handlers.NewProfileHandler(db)
+	adminHandler := handlers.NewAdminHandler(cluster)
+	profileHandler := handlers.NewProfileHandler(db, hub)
 	dmHandler := handlers.NewDMHandler(db, hub)
 	notificationHandler := handlers.NewNotificationHandler(db, hub)
 	fileHandler := handlers.NewFileHandler(db)
-	searchHandler := handlers.NewSearchHandler(db)
+	searchHandler := handlers.NewSearchHandler(cluster)
 	bookmarkHandler := handlers.NewBookmarkHandler(db)
-	activityHandler := handlers.NewActivityHandler(db)
+	activityHandler := handlers.NewActivityHandler(cluster)
+	jobHandler := handlers.NewJobHandler(jobQueue, jobRunner)
+	readHandler := handlers.NewReadHandler(db, hub)
+	mentionHandler := handlers.NewMentionHandler(db)
+
+	// Tickets must be redeemable on any node when there is more than one
+	var tickets auth.TicketStore = auth.NewMemoryTicketStore()
+	if redis != nil {
+		tickets = auth.NewRedisTicketStore(redis)
+	}
+	hub.HandleFrame("send_message", middleware.ReadYourWritesFrame(cluster, messageHandler.HandleSendFrame))
+	hub.HandleFrame("send_dm", middleware.ReadYourWritesFrame(cluster, dmHandler.HandleSendFrame))
+	wsHandler := handlers.NewWebSocketHandler(db, hub, tickets, cfg.JWTSecret, cfg.WSAllowedOrigins)
 
 	// Public routes
 	api := r.Group("/api")
 	{
 		api.POST("/auth/register", authHandler.Register)
 		api.POST("/auth/login", authHandler.Login)
+
+		// WebSocket and its SSE fallback authenticate with a ticket, see
+		// WebSocketHandler.Serve
+		api.GET("/ws", wsHandler.Serve)
+		api.GET("/events", wsHandler.Events)
 	}
 
 	// Protected routes
 	protected := api.Group("")
 	protected.Use(middleware.AuthMiddleware(cfg.JWTSecret))
+	protected.Use(middleware.ReadYourWrites(cluster))
 	{
 		// Auth
 		protected.GET("/auth/me", authHandler.GetMe)
@@ -87,7 +102,7 @@ func Setup(db *sql.DB, redis *redis.Client, hub *websocket.Hub, cfg *config.Conf
 		protected.POST("/users/:id/block", profileHandler.BlockUser)
 		protected.DELETE("/users/:id/block", profileHandler.UnblockUser)
 		protected.GET("/users/blocked", profileHandler.GetBlockedUsers)
-		protected.POST("/status/online", profileHandler.SetOnlineStatus)
+		protected.POST("/status/online", profileHandler.SetOnlineStatus) // deprecated, see SetOnlineStatus
 
 		// Direct Messages
 		protected.GET("/conversations", dmHandler.GetConversations)
@@ -99,31 +114,57 @@ func Setup(db *sql.DB, redis *redis.Client, hub *websocket.Hub, cfg *config.Conf
 		protected.GET("/topics", topicHandler.GetTopics)
 		protected.POST("/topics", topicHandler.CreateTopic)
 		protected.POST("/topics/:id/vote", topicHandler.VoteTopic)
+		protected.POST("/topics/:id/read", readHandler.MarkTopicRead)
 
 		// Messages
 		protected.GET("/messages", messageHandler.GetMessages)
 		protected.POST("/messages", messageHandler.CreateMessage)
 		protected.PATCH("/messages/:id", messageHandler.EditMessage)
+		protected.GET("/messages/:id/revisions", messageHandler.GetRevisions)
+		protected.GET("/messages/:id/thread", messageHandler.GetThread)
+		protected.POST("/messages/:id/follow", messageHandler.FollowThread)
+		protected.DELETE("/messages/:id/follow", messageHandler.UnfollowThread)
 		protected.DELETE("/messages/:id", messageHandler.DeleteMessage)
+		protected.POST("/messages/:id/restore", messageHandler.RestoreMessage)
+		protected.GET("/messages/:id/deletion", messageHandler.GetDeletion)
+		protected.POST("/messages/bulk-delete", messageHandler.BulkDeleteMessages)
+		protected.POST("/messages/:id/pin", messageHandler.PinMessage)
+		protected.DELETE("/messages/:id/pin", messageHandler.UnpinMessage)
 		protected.POST("/messages/:id/reactions", messageHandler.AddReaction)
 		protected.DELETE("/messages/:id/reactions", messageHandler.RemoveReaction)
 		protected.POST("/messages/:id/read", messageHandler.MarkAsRead)
 		protected.POST("/messages/typing/start", messageHandler.StartTyping)
 		protected.POST("/messages/typing/stop", messageHandler.StopTyping)
+		protected.GET("/mentions/suggest", mentionHandler.Suggest)
+		protected.GET("/scheduled-messages", messageHandler.GetScheduledMessages)
+		protected.POST("/scheduled-messages", messageHandler.CreateScheduledMessage)
+		protected.PATCH("/scheduled-messages/:id", messageHandler.UpdateScheduledMessage)
+		protected.DELETE("/scheduled-messages/:id", messageHandler.CancelScheduledMessage)
+
+		// Polls are created with a "poll" in POST /messages
+		protected.GET("/polls/:id", messageHandler.GetPoll)
+		protected.POST("/polls/:id/vote", messageHandler.VotePoll)
+		protected.DELETE("/polls/:id/vote", messageHandler.RetractVote)
+		protected.POST("/polls/:id/close", messageHandler.ClosePoll)
 
 		// Groups
 		protected.GET("/groups", groupHandler.GetGroups)
 		protected.POST("/groups", groupHandler.CreateGroup)
 		protected.POST("/groups/:id/join", groupHandler.JoinGroup)
 		protected.POST("/groups/:id/leave", groupHandler.LeaveGroup)
+		protected.POST("/groups/:id/read", readHandler.MarkGroupRead)
+		protected.GET("/groups/:id/pins", messageHandler.GetGroupPins)
 		protected.POST("/groups/:id/invite", groupHandler.CreateInvitation)
 		protected.POST("/groups/join/:code", groupHandler.JoinByInvitation)
 		protected.PATCH("/groups/:id/members/:member_id/role", groupHandler.UpdateMemberRole)
 		protected.DELETE("/groups/:id/members/:member_id", groupHandler.RemoveMember)
+		protected.PUT("/groups/:id/reactions", groupHandler.SetAllowedReactions)
+		protected.PUT("/groups/:id/link-previews", groupHandler.SetLinkPreviews)
 
 		// Topics enhanced
 		protected.POST("/topics/:id/pin", groupHandler.PinTopic)
 		protected.DELETE("/topics/:id/pin", groupHandler.UnpinTopic)
+		protected.GET("/topics/:id/pins", messageHandler.GetTopicPins)
 
 		// Sessions
 		protected.GET("/sessions", sessionHandler.GetSessions)
@@ -163,25 +204,23 @@ func Setup(db *sql.DB, redis *redis.Client, hub *websocket.Hub, cfg *config.Conf
 		protected.GET("/trending", activityHandler.GetTrendingTopics)
 
 		// WebSocket
-		protected.GET("/ws", func(c *gin.Context) {
-			userID := c.GetString("user_id")
-			conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-			if err != nil {
-				return
-			}
-			websocket.ServeWs(hub, conn, userID)
-		})
+		protected.POST("/ws/ticket", wsHandler.IssueTicket)
 	}
 
 	// Admin routes
 	admin := api.Group("/admin")
 	admin.Use(middleware.AuthMiddleware(cfg.JWTSecret))
 	admin.Use(middleware.AdminOnly())
+	admin.Use(middleware.ReadYourWrites(cluster))
 	{
 		admin.GET("/stats", adminHandler.GetStats)
 		admin.GET("/users", adminHandler.GetUsers)
 		admin.PATCH("/users/:id/status", adminHandler.ToggleUserStatus)
 		admin.PATCH("/users/:id/role", adminHandler.UpdateUserRole)
+		admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+		admin.GET("/jobs", jobHandler.GetJobs)
+		admin.GET("/jobs/schedules", jobHandler.GetSchedules)
+		admin.POST("/jobs/:id/retry", jobHandler.RetryJob)
 	}
 
 	return r
@@ -0,0 +1,337 @@
+// Package unfurl fetches the OpenGraph and Twitter card metadata of web
+// pages for link previews. Every connection is checked after DNS
+// resolution, so hostnames that resolve to private or otherwise internal
+// addresses are refused, including through redirects.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	ErrBlockedAddress = errors.New("unfurl: address not allowed")
+	ErrUnsupportedURL = errors.New("unfurl: unsupported URL")
+	ErrNotHTML        = errors.New("unfurl: not an HTML page")
+	ErrNoMetadata     = errors.New("unfurl: page has no preview metadata")
+)
+
+// MaxURLLength bounds the URLs taken from messages.
+const MaxURLLength = 2048
+
+// Limits on the stored preview fields, in runes.
+const (
+	maxTitle       = 300
+	maxDescription = 1000
+	maxSiteName    = 100
+)
+
+// Preview is what a link preview shows.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+type Options struct {
+	// Timeout bounds a whole fetch, redirects included.
+	Timeout time.Duration
+	// MaxBytes is how much of a page is read looking for metadata.
+	MaxBytes int64
+	// MaxRedirects is how many redirects are followed.
+	MaxRedirects int
+	UserAgent    string
+	// AllowAddr reports whether an address may be connected to; nil means
+	// PublicAddr. Tests against a local server allow loopback here.
+	AllowAddr func(netip.Addr) bool
+}
+
+type Unfurler struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+}
+
+func New(opts Options) *Unfurler {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 512 << 10
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = 3
+	}
+	if opts.UserAgent == "" {
+		opts.UserAgent = "psycho-platform-unfurl/1.0"
+	}
+	allow := opts.AllowAddr
+	if allow == nil {
+		allow = PublicAddr
+	}
+
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+		// Control runs on the resolved address of every connection attempt,
+		// so DNS answers cannot smuggle in an internal address
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil || !allow(addr.Unmap()) {
+				return ErrBlockedAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// No proxy: the address check must apply to the page's host
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    opts.Timeout,
+		ResponseHeaderTimeout:  opts.Timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		MaxIdleConns:           10,
+		IdleConnTimeout:        30 * time.Second,
+	}
+
+	return &Unfurler{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > opts.MaxRedirects {
+					return fmt.Errorf("unfurl: stopped after %d redirects", opts.MaxRedirects)
+				}
+				return checkURL(req.URL)
+			},
+		},
+		maxBytes:  opts.MaxBytes,
+		userAgent: opts.UserAgent,
+	}
+}
+
+// blockedPrefixes are special-purpose ranges netip's predicates miss.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("2001::/23"),
+	netip.MustParsePrefix("2001:db8::/32"),
+	netip.MustParsePrefix("2002::/16"),
+}
+
+// PublicAddr reports whether addr is a public unicast address: not
+// loopback, private, link-local, multicast or reserved.
+func PublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || u.User != nil {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+// Unfurl fetches rawURL and returns its preview. Pages without a title are
+// reported as ErrNoMetadata.
+func (u *Unfurler) Unfurl(ctx context.Context, rawURL string) (*Preview, error) {
+	if len(rawURL) > MaxURLLength {
+		return nil, ErrUnsupportedURL
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrUnsupportedURL
+	}
+	if err := checkURL(target); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", u.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl: status %d", resp.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	preview := parse(io.LimitReader(resp.Body, u.maxBytes), resp.Request.URL)
+	if preview.Title == "" {
+		return nil, ErrNoMetadata
+	}
+	preview.URL = rawURL
+	return preview, nil
+}
+
+// parse reads metadata from the document head, preferring OpenGraph over
+// Twitter card tags over plain HTML. Pages are assumed to be UTF-8.
+func parse(r io.Reader, base *url.URL) *Preview {
+	meta := make(map[string]string)
+	var title string
+	inTitle := false
+
+	z := html.NewTokenizer(r)
+scan:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break scan
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "body":
+				break scan
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						if key == "" {
+							key = strings.ToLower(string(v))
+						}
+					case "content":
+						content = string(v)
+					}
+				}
+				if _, seen := meta[key]; key != "" && !seen {
+					meta[key] = content
+				}
+			}
+		case html.TextToken:
+			if inTitle {
+				title = string(z.Text())
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				break scan
+			}
+			inTitle = false
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, k := range keys {
+			if v := strings.TrimSpace(meta[k]); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	p := &Preview{
+		Title:       clean(first("og:title", "twitter:title"), maxTitle),
+		Description: clean(first("og:description", "twitter:description", "description"), maxDescription),
+		SiteName:    clean(first("og:site_name"), maxSiteName),
+	}
+	if p.Title == "" {
+		p.Title = clean(title, maxTitle)
+	}
+	if image := first("og:image:secure_url", "og:image", "twitter:image", "twitter:image:src"); image != "" {
+		if ref, err := base.Parse(image); err == nil && checkURL(ref) == nil && len(ref.String()) <= MaxURLLength {
+			p.ImageURL = ref.String()
+		}
+	}
+	return p
+}
+
+// clean collapses whitespace, drops invalid UTF-8 and truncates to max
+// runes.
+func clean(s string, max int) string {
+	s = strings.Join(strings.Fields(strings.ToValidUTF8(s, "")), " ")
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:max-1])) + "…"
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+
+// ExtractURLs returns up to max distinct http(s) URLs in content, in order,
+// without trailing punctuation such as a sentence's full stop.
+func ExtractURLs(content string, max int) []string {
+	urls := []string{}
+	seen := make(map[string]bool)
+	for _, raw := range urlPattern.FindAllString(content, -1) {
+		raw = trimURL(raw)
+		if len(raw) > MaxURLLength || seen[raw] {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || checkURL(u) != nil {
+			continue
+		}
+		if len(urls) == max {
+			break
+		}
+		seen[raw] = true
+		urls = append(urls, raw)
+	}
+	return urls
+}
+
+// trimURL drops trailing punctuation, keeping a closing parenthesis that
+// balances one inside the URL as in Wikipedia links.
+func trimURL(s string) string {
+	for s != "" {
+		last := s[len(s)-1]
+		switch {
+		case strings.IndexByte(".,;:!?'\"]}", last) >= 0:
+		case last == ')' && strings.Count(s, "(") < strings.Count(s, ")"):
+		default:
+			return s
+		}
+		s = s[:len(s)-1]
+	}
+	return s
+}
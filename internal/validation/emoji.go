@@ -0,0 +1,118 @@
+package validation
+
+import (
+	"errors"
+	"unicode"
+)
+
+var ErrInvalidEmoji = errors.New("reaction must be a single emoji")
+
+// MaxEmojiRunes bounds the code points of one emoji; the longest standard
+// ZWJ and tag sequences fit comfortably.
+const MaxEmojiRunes = 16
+
+// pictographic approximates the Unicode Extended_Pictographic property:
+// the code points emoji are built from.
+var pictographic = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{0x00A9, 0x00AE, 5},
+		{0x203C, 0x2049, 13},
+		{0x2122, 0x2139, 23},
+		{0x2194, 0x2199, 1},
+		{0x21A9, 0x21AA, 1},
+		{0x231A, 0x231B, 1},
+		{0x2328, 0x23CF, 167},
+		{0x23E9, 0x23F3, 1},
+		{0x23F8, 0x23FA, 1},
+		{0x24C2, 0x25AA, 232},
+		{0x25AB, 0x25B6, 11},
+		{0x25C0, 0x25FB, 59},
+		{0x25FC, 0x25FE, 1},
+		{0x2600, 0x27BF, 1},
+		{0x2934, 0x2935, 1},
+		{0x2B05, 0x2B07, 1},
+		{0x2B1B, 0x2B1C, 1},
+		{0x2B50, 0x2B55, 5},
+		{0x3030, 0x303D, 13},
+		{0x3297, 0x3299, 2},
+	},
+	R32: []unicode.Range32{
+		{0x1F000, 0x1F1E5, 1},
+		{0x1F200, 0x1F3FA, 1},
+		{0x1F400, 0x1FAFF, 1},
+	},
+	LatinOffset: 1,
+}
+
+const (
+	zwj           = 0x200D
+	variation16   = 0xFE0F
+	keycap        = 0x20E3
+	tagEnd        = 0xE007F
+	regionalFirst = 0x1F1E6
+	regionalLast  = 0x1F1FF
+	skinToneFirst = 0x1F3FB
+	skinToneLast  = 0x1F3FF
+	tagFirst      = 0xE0020
+	tagLast       = 0xE007E
+)
+
+func isRegional(r rune) bool { return r >= regionalFirst && r <= regionalLast }
+
+// ValidateEmoji accepts exactly one emoji: a pictograph with optional
+// presentation selector, skin tone and tags, ZWJ sequences of those, a
+// keycap such as 1️⃣, or a flag made of two regional indicators.
+func ValidateEmoji(s string) error {
+	runes := []rune(s)
+	if len(runes) == 0 || len(runes) > MaxEmojiRunes {
+		return ErrInvalidEmoji
+	}
+
+	if isRegional(runes[0]) {
+		if len(runes) == 2 && isRegional(runes[1]) {
+			return nil
+		}
+		return ErrInvalidEmoji
+	}
+
+	if r := runes[0]; r == '#' || r == '*' || r >= '0' && r <= '9' {
+		rest := runes[1:]
+		if len(rest) > 0 && rest[0] == variation16 {
+			rest = rest[1:]
+		}
+		if len(rest) == 1 && rest[0] == keycap {
+			return nil
+		}
+		return ErrInvalidEmoji
+	}
+
+	i := 0
+	for {
+		if i >= len(runes) || !unicode.Is(pictographic, runes[i]) {
+			return ErrInvalidEmoji
+		}
+		i++
+		if i < len(runes) && runes[i] == variation16 {
+			i++
+		}
+		if i < len(runes) && runes[i] >= skinToneFirst && runes[i] <= skinToneLast {
+			i++
+		}
+		if i < len(runes) && runes[i] >= tagFirst && runes[i] <= tagLast {
+			for i < len(runes) && runes[i] >= tagFirst && runes[i] <= tagLast {
+				i++
+			}
+			if i >= len(runes) || runes[i] != tagEnd {
+				return ErrInvalidEmoji
+			}
+			i++
+		}
+		if i == len(runes) {
+			return nil
+		}
+		if runes[i] != zwj {
+			return ErrInvalidEmoji
+		}
+		i++
+	}
+}
@@ -0,0 +1,41 @@
+package validation
+
+import "testing"
+
+func TestValidateEmoji(t *testing.T) {
+	tests := []struct {
+		name  string
+		emoji string
+		valid bool
+	}{
+		{"pictograph", "👍", true},
+		{"heart with presentation selector", "❤️", true},
+		{"skin tone", "👍🏽", true},
+		{"zwj family", "👨‍👩‍👧‍👦", true},
+		{"zwj with skin tones", "🧑🏻‍🤝‍🧑🏿", true},
+		{"rainbow flag", "🏳️‍🌈", true},
+		{"country flag", "🇺🇦", true},
+		{"subdivision flag", "🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"keycap", "1️⃣", true},
+		{"keycap without selector", "#⃣", true},
+		{"copyright sign", "©️", true},
+		{"empty", "", false},
+		{"text", "ok", false},
+		{"digit", "1", false},
+		{"two emoji", "👍👍", false},
+		{"emoji and text", "👍a", false},
+		{"lone regional indicator", "🇺", false},
+		{"three regional indicators", "🇺🇦🇺", false},
+		{"trailing zwj", "👍‍", false},
+		{"leading zwj", "‍👍", false},
+		{"lone skin tone", "🏽", false},
+		{"unterminated tags", "🏴󠁧󠁢", false},
+		{"too long", "👨‍👩‍👧‍👦‍👨‍👩‍👧‍👦‍👨", false},
+		{"html", "<b>", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateEmoji(tt.emoji); (err == nil) != tt.valid {
+			t.Errorf("%s: ValidateEmoji(%q) = %v, want valid %v", tt.name, tt.emoji, err, tt.valid)
+		}
+	}
+}
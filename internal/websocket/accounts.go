@@ -0,0 +1,94 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// CloseAccountDisabled tells the client not to reconnect with the same
+// credentials.
+const CloseAccountDisabled = 4403
+
+// AccountValidator reports which of userIDs may stay connected.
+type AccountValidator interface {
+	ActiveUsers(ctx context.Context, userIDs []string) (map[string]bool, error)
+}
+
+// DBAccountValidator treats users with is_active = true as valid.
+type DBAccountValidator struct {
+	db *sql.DB
+}
+
+func NewAccountValidator(db *sql.DB) *DBAccountValidator {
+	return &DBAccountValidator{db: db}
+}
+
+func (v *DBAccountValidator) ActiveUsers(ctx context.Context, userIDs []string) (map[string]bool, error) {
+	active := make(map[string]bool, len(userIDs))
+	if len(userIDs) == 0 {
+		return active, nil
+	}
+
+	rows, err := v.db.QueryContext(ctx, `
+		SELECT id FROM users WHERE id = ANY($1::uuid[]) AND is_active = true
+	`, pq.Array(userIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		active[id] = true
+	}
+
+	return active, rows.Err()
+}
+
+// SetAccountValidator makes the hub re-check connected accounts every
+// interval and drop those that were deactivated since they connected.
+// Must be called before Run.
+func (h *Hub) SetAccountValidator(v AccountValidator, interval time.Duration) {
+	h.validator = v
+	h.revalidateEvery = interval
+}
+
+func (h *Hub) revalidate(ctx context.Context) {
+	ticker := time.NewTicker(h.revalidateEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		users := h.localUsers()
+		if len(users) == 0 {
+			continue
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		active, err := h.validator.ActiveUsers(checkCtx, users)
+		cancel()
+		if err != nil {
+			// Keep connections open rather than dropping everyone on a DB hiccup
+			log.Printf("WebSocket account revalidation failed: %v", err)
+			continue
+		}
+
+		for _, userID := range users {
+			if !active[userID] {
+				h.disconnectUser(userID, CloseAccountDisabled, "account disabled")
+			}
+		}
+	}
+}
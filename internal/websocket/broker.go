@@ -0,0 +1,51 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Broker carries hub events between API nodes. Every node publishes the
+// events it produces and receives everyone else's, so a message posted on
+// one replica reaches clients connected to any replica.
+type Broker interface {
+	Publish(ctx context.Context, data []byte) error
+	// Subscribe calls deliver for every published event until ctx is done.
+	Subscribe(ctx context.Context, deliver func(data []byte)) error
+	Close() error
+}
+
+// Envelope kinds. Broadcasts carry a pre-encoded frame and user
+// broadcasts an event payload for several user rooms; the others ask each
+// node to apply a membership change to its local connections.
+const (
+	envelopeBroadcast = "broadcast"
+	envelopeUsers     = "users"
+	envelopeRevoke    = "revoke"
+	envelopeRecheck   = "recheck"
+)
+
+// envelope is what travels through the broker. Node is the publisher, so
+// a node can skip its own events: it already delivered them locally.
+type envelope struct {
+	Node  string          `json:"node"`
+	Kind  string          `json:"kind"`
+	Room  string          `json:"room,omitempty"`
+	User  string          `json:"user,omitempty"`
+	Users []string        `json:"users,omitempty"`
+	Type  string          `json:"type,omitempty"`
+	Key   string          `json:"key,omitempty"`
+	Seq   int64           `json:"seq,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// DefaultNodeID identifies this process when NODE_ID is not configured.
+func DefaultNodeID() string {
+	if id := os.Getenv("RAILWAY_REPLICA_ID"); id != "" {
+		return id
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
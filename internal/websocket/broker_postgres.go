@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	postgresBrokerChannel = "ws_events"
+
+	// NOTIFY payloads are limited to 8000 bytes by default
+	maxNotifyPayload = 7900
+
+	// spilledPrefix marks a notification that only carries the id of a
+	// ws_broker_payloads row. Envelopes are JSON objects, so they never
+	// start with it.
+	spilledPrefix = "#"
+)
+
+// PostgresBroker fans hub events out with LISTEN/NOTIFY, for deployments
+// without Redis. Events larger than the NOTIFY payload limit are stored in
+// ws_broker_payloads and only their id is notified; receivers load them
+// from there. Old rows are removed by the ws.events.prune job.
+type PostgresBroker struct {
+	db       *sql.DB
+	listener *pq.Listener
+}
+
+// NewPostgresBroker publishes through db and listens on a dedicated
+// connection opened from url.
+func NewPostgresBroker(db *sql.DB, url string) *PostgresBroker {
+	listener := pq.NewListener(url, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("WebSocket broker listener: %v", err)
+		}
+	})
+	return &PostgresBroker{db: db, listener: listener}
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, data []byte) error {
+	if len(data) <= maxNotifyPayload {
+		_, err := b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, postgresBrokerChannel, string(data))
+		return err
+	}
+
+	// The row and the notification commit together, so receivers always
+	// find the row
+	_, err := b.db.ExecContext(ctx, `
+		WITH spilled AS (
+			INSERT INTO ws_broker_payloads (data) VALUES ($2) RETURNING id
+		)
+		SELECT pg_notify($1, $3 || spilled.id) FROM spilled
+	`, postgresBrokerChannel, string(data), spilledPrefix)
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(ctx context.Context, deliver func(data []byte)) error {
+	if err := b.listener.Listen(postgresBrokerChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n, ok := <-b.listener.Notify:
+			if !ok {
+				return nil
+			}
+			// A nil notification means the connection was re-established
+			// and events may have been missed in between
+			if n == nil {
+				continue
+			}
+			if !strings.HasPrefix(n.Extra, spilledPrefix) {
+				deliver([]byte(n.Extra))
+				continue
+			}
+			data, err := b.load(ctx, strings.TrimPrefix(n.Extra, spilledPrefix))
+			if err != nil {
+				log.Printf("Failed to load broker event %s: %v", n.Extra, err)
+				continue
+			}
+			deliver(data)
+		case <-time.After(90 * time.Second):
+			go b.listener.Ping()
+		}
+	}
+}
+
+// load reads a spilled event.
+func (b *PostgresBroker) load(ctx context.Context, id string) ([]byte, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var data string
+	err = b.db.QueryRowContext(ctx, `SELECT data FROM ws_broker_payloads WHERE id = $1`, n).Scan(&data)
+	return []byte(data), err
+}
+
+func (b *PostgresBroker) Close() error {
+	return b.listener.Close()
+}
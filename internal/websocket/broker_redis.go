@@ -0,0 +1,53 @@
+package websocket
+
+import (
+	"context"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisBrokerChannel = "ws:events"
+
+// RedisBroker fans hub events out over a single Redis pub/sub channel.
+// Pub/sub is fire-and-forget: nodes that are disconnected from Redis miss
+// events published in the meantime.
+type RedisBroker struct {
+	client *redis.Client
+}
+
+func NewRedisBroker(client *redis.Client) *RedisBroker {
+	return &RedisBroker{client: client}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, data []byte) error {
+	return b.client.Publish(ctx, redisBrokerChannel, data).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, deliver func(data []byte)) error {
+	sub := b.client.Subscribe(ctx, redisBrokerChannel)
+	defer sub.Close()
+
+	// Wait for the subscription to be confirmed so a failure surfaces here
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	// Channel reconnects on its own after network errors
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			deliver([]byte(msg.Payload))
+		}
+	}
+}
+
+// Close is a no-op: the Redis client is shared and closed by main.
+func (b *RedisBroker) Close() error {
+	return nil
+}
@@ -2,7 +2,11 @@ package websocket
 
 import (
 	"encoding/json"
+	"expvar"
 	"log"
+	"psycho-platform/internal/rooms"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -15,22 +19,207 @@ const (
 	maxMessageSize = 512 * 1024
 )
 
+// CloseSlowConsumer closes connections that could not keep up with their
+// events. Clients reconnect and resume to catch up.
+const CloseSlowConsumer = 4429
+
+var (
+	droppedFrames   = expvar.NewInt("ws_dropped_frames")
+	slowDisconnects = expvar.NewInt("ws_slow_disconnects")
+)
+
+// transport puts frames on the wire. WebSocket and SSE connections share
+// the Client queueing and lifecycle and differ only here.
+type transport interface {
+	writeFrame(f *frame) error
+	heartbeat() error
+	heartbeatInterval() time.Duration
+	// writeClose tells the peer why the stream ends; finish releases the
+	// connection.
+	writeClose(code int, reason string)
+	finish()
+}
+
+// batchWriter is implemented by transports that handle a resume batch
+// differently from live frames.
+type batchWriter interface {
+	writeBatch(batch []*frame) error
+}
+
+func writeBatch(out transport, batch []*frame) error {
+	if bw, ok := out.(batchWriter); ok {
+		return bw.writeBatch(batch)
+	}
+	for _, f := range batch {
+		if err := out.writeFrame(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type Client struct {
 	hub    *Hub
-	conn   *websocket.Conn
-	send   chan []byte
+	out    transport
+	send   chan *frame
 	userID string
+	proto  Protocol
+
+	// conn is set for WebSocket clients, which also read frames from it.
+	conn *websocket.Conn
+
+	// replay carries resume batches to writePump; stopped is closed when
+	// writePump exits so a pending handoff gives up.
+	replay  chan []*frame
+	stopped chan struct{}
+
+	// status is the presence status this connection last reported,
+	// guarded by the hub's mutex.
+	status string
+
+	// mu guards the rooms this client joined, the rooms it is typing in
+	// and the ephemeral frames parked while send was full; wake tells
+	// writePump about the latter.
+	mu        sync.Mutex
+	rooms     map[string]bool
+	typing    map[string]bool
+	coalesced map[string]*frame
+	wake      chan struct{}
+
+	// drops counts events missed since the queue last drained.
+	drops atomic.Int32
+
+	// closing is closed exactly once, by close; send is never closed, so
+	// queuing to a client that is going away is always safe. closeCode and
+	// closeReason are set first and tell writePump which close frame to
+	// send.
+	closing     chan struct{}
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+}
+
+// close makes writePump send a close frame and exit, which in turn ends
+// readPump and unregisters the client. Only the first call has an effect.
+func (c *Client) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		close(c.closing)
+	})
+}
+
+// enqueue queues f without blocking. When the queue is full, ephemeral
+// frames are parked, replacing the previous one with the same key; other
+// frames are dropped, and a client that keeps missing them is closed.
+func (c *Client) enqueue(f *frame) {
+	select {
+	case <-c.closing:
+		return
+	default:
+	}
+
+	select {
+	case c.send <- f:
+		return
+	default:
+	}
+
+	if f.key != "" {
+		c.mu.Lock()
+		c.coalesced[f.key] = f
+		c.mu.Unlock()
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+		return
+	}
+
+	droppedFrames.Add(1)
+	if int(c.drops.Add(1)) >= c.hub.maxDrops {
+		slowDisconnects.Add(1)
+		log.Printf("Disconnecting slow client %s", c.userID)
+		c.close(CloseSlowConsumer, "too slow")
+	}
+}
+
+// takeCoalesced returns the parked ephemeral frames.
+func (c *Client) takeCoalesced() []*frame {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	frames := make([]*frame, 0, len(c.coalesced))
+	for key, f := range c.coalesced {
+		frames = append(frames, f)
+		delete(c.coalesced, key)
+	}
+	return frames
+}
+
+func (c *Client) rememberRoom(roomID string) {
+	c.mu.Lock()
+	c.rooms[roomID] = true
+	c.mu.Unlock()
+}
+
+func (c *Client) forgetRoom(roomID string) {
+	c.mu.Lock()
+	delete(c.rooms, roomID)
+	c.mu.Unlock()
+}
+
+func (c *Client) joinedRooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	joined := make([]string, 0, len(c.rooms))
+	for roomID := range c.rooms {
+		joined = append(joined, roomID)
+	}
+	return joined
+}
+
+// setTyping records whether the client is typing in roomID and reports
+// whether that changed.
+func (c *Client) setTyping(roomID string, typing bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.typing[roomID] == typing {
+		return false
+	}
+	if typing {
+		c.typing[roomID] = true
+	} else {
+		delete(c.typing, roomID)
+	}
+	return true
+}
+
+// takeTyping returns and forgets the rooms the client is typing in.
+func (c *Client) takeTyping() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	roomIDs := make([]string, 0, len(c.typing))
+	for roomID := range c.typing {
+		roomIDs = append(roomIDs, roomID)
+	}
+	c.typing = make(map[string]bool)
+	return roomIDs
 }
 
-type Message struct {
-	Type    string      `json:"type"`
-	Room    string      `json:"room,omitempty"`
-	Payload interface{} `json:"payload"`
+// inboundFrame is a frame received from the client; the payload is decoded
+// by whoever handles the frame type.
+type inboundFrame struct {
+	Type    string          `json:"type"`
+	Room    string          `json:"room,omitempty"`
+	Payload json.RawMessage `json:"payload"`
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.unregister(c)
 		c.conn.Close()
 	}()
 
@@ -41,15 +230,25 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		if messageType == websocket.BinaryMessage {
+			if c.proto.Encoding != EncodingMsgpack {
+				c.hub.sendToClient(c, "", ErrorEvent{Code: "unsupported_encoding"})
+				continue
+			}
+			if message, err = msgpackToJSON(message); err != nil {
+				c.hub.sendToClient(c, "", ErrorEvent{Code: "invalid_frame"})
+				continue
+			}
+		}
 
-		var msg Message
+		var msg inboundFrame
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Printf("error unmarshaling message: %v", err)
 			continue
@@ -57,71 +256,205 @@ func (c *Client) readPump() {
 
 		switch msg.Type {
 		case "join_room":
-			if msg.Room != "" {
+			if msg.Room == "" {
+				continue
+			}
+			if c.hub.authorize(c.userID, msg.Room) {
 				c.hub.JoinRoom(c, msg.Room)
+			} else {
+				c.hub.sendToClient(c, msg.Room, ErrorEvent{Frame: msg.Type, Room: msg.Room, Code: "forbidden"})
 			}
+		case "resume":
+			c.resume(msg.Payload)
 		case "leave_room":
 			if msg.Room != "" {
 				c.hub.LeaveRoom(c, msg.Room)
+				if c.setTyping(msg.Room, false) {
+					c.hub.StopTyping(c.userID, msg.Room)
+				}
 			}
-		case "message":
-			if msg.Room != "" {
-				c.hub.BroadcastToRoom(msg.Room, msg)
+		case "typing":
+			c.typingFrame(msg.Room, msg.Payload)
+		case "presence":
+			var payload struct {
+				Status string `json:"status"`
+			}
+			json.Unmarshal(msg.Payload, &payload)
+			if ValidClientStatus(payload.Status) {
+				c.hub.setClientStatus(c, payload.Status)
+			}
+		default:
+			// Everything else, e.g. send_message, goes through a registered
+			// handler that validates and persists it before anyone sees it
+			if handler := c.hub.frameHandler(msg.Type); handler != nil {
+				c.dispatchFrame(handler, msg.Type, msg.Payload)
+			} else {
+				c.hub.sendToClient(c, "", ErrorEvent{Frame: msg.Type, Code: "unknown_frame"})
 			}
 		}
 	}
 }
 
+// typingFrame handles {"type": "typing", "room": ..., "payload":
+// {"is_typing": bool}}. Only members of a room may type in it.
+func (c *Client) typingFrame(roomID string, payload json.RawMessage) {
+	var req struct {
+		IsTyping bool `json:"is_typing"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		c.hub.sendToClient(c, "", ErrorEvent{Frame: "typing", Code: "invalid_payload"})
+		return
+	}
+
+	if !TypingRoom(roomID) {
+		c.hub.sendToClient(c, roomID, ErrorEvent{Frame: "typing", Room: roomID, Code: "invalid_target"})
+		return
+	}
+	// Stops are checked too, so nobody can send typing events into rooms
+	// they are not in
+	if !c.hub.inRoom(c, roomID) {
+		c.hub.sendToClient(c, roomID, ErrorEvent{Frame: "typing", Room: roomID, Code: "forbidden"})
+		return
+	}
+	if !req.IsTyping {
+		c.setTyping(roomID, false)
+		c.hub.StopTyping(c.userID, roomID)
+		return
+	}
+	c.setTyping(roomID, true)
+	c.hub.StartTyping(c.userID, roomID)
+}
+
+// writePump is the only writer to the client's transport.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.out.heartbeatInterval())
 	defer func() {
 		ticker.Stop()
-		c.conn.Close()
+		c.out.finish()
+		close(c.stopped)
+		c.hub.pumps.Done()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
+		case <-c.closing:
+			c.out.writeClose(c.closeCode, c.closeReason)
+			return
 
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+		case f := <-c.send:
+			if err := c.out.writeFrame(f); err != nil {
 				return
 			}
-			w.Write(message)
+			if len(c.send) == 0 {
+				c.drops.Store(0)
+			}
 
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
+		case <-c.wake:
+			for _, f := range c.takeCoalesced() {
+				if err := c.out.writeFrame(f); err != nil {
+					return
+				}
 			}
 
-			if err := w.Close(); err != nil {
+		case batch := <-c.replay:
+			if err := writeBatch(c.out, batch); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.out.heartbeat(); err != nil {
 				return
 			}
 		}
 	}
 }
 
-func ServeWs(hub *Hub, conn *websocket.Conn, userID string) {
-	client := &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
+// wsTransport writes each event as its own WebSocket message in the
+// connection's encoding.
+type wsTransport struct {
+	conn     *websocket.Conn
+	encoding string
+}
+
+func (t *wsTransport) writeFrame(f *frame) error {
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if t.encoding != EncodingMsgpack {
+		return t.conn.WriteMessage(websocket.TextMessage, f.data)
+	}
+
+	data, err := f.msgpack()
+	if err != nil {
+		log.Printf("Error encoding frame as MessagePack: %v", err)
+		return nil
+	}
+	return t.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (t *wsTransport) heartbeat() error {
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t *wsTransport) heartbeatInterval() time.Duration {
+	return pingPeriod
+}
+
+func (t *wsTransport) writeClose(code int, reason string) {
+	t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	payload := []byte{}
+	if code != 0 {
+		payload = websocket.FormatCloseMessage(code, reason)
+	}
+	t.conn.WriteMessage(websocket.CloseMessage, payload)
+}
+
+func (t *wsTransport) finish() {
+	t.conn.Close()
+}
+
+func newClient(hub *Hub, out transport, userID string, proto Protocol) *Client {
+	return &Client{
+		hub:       hub,
+		out:       out,
+		send:      make(chan *frame, hub.queueSize),
+		userID:    userID,
+		proto:     proto,
+		status:    StatusOnline,
+		replay:    make(chan []*frame),
+		stopped:   make(chan struct{}),
+		rooms:     make(map[string]bool),
+		typing:    make(map[string]bool),
+		coalesced: make(map[string]*frame),
+		wake:      make(chan struct{}, 1),
+		closing:   make(chan struct{}),
 	}
+}
+
+// start greets a registered client and joins its personal rooms, so every
+// connection receives its user's notifications and DMs.
+func (c *Client) start() {
+	c.hub.sendToClient(c, "", HelloEvent{
+		Protocol: c.proto.Version,
+		Encoding: c.proto.Encoding,
+		Resume:   c.hub.replay != nil,
+	})
+	c.hub.JoinRoom(c, rooms.User(c.userID))
+	c.hub.JoinRoom(c, rooms.DM(c.userID))
+}
+
+// ServeWs runs a connection speaking proto, which the caller negotiated
+// during the upgrade.
+func ServeWs(hub *Hub, conn *websocket.Conn, userID string, proto Protocol) {
+	client := newClient(hub, &wsTransport{conn: conn, encoding: proto.Encoding}, userID, proto)
+	client.conn = conn
 
-	client.hub.register <- client
+	if !hub.register(client) {
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "reconnect"))
+		conn.Close()
+		return
+	}
+	client.start()
 
 	go client.writePump()
 	go client.readPump()
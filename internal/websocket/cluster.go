@@ -0,0 +1,299 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"time"
+)
+
+var publishFailures = expvar.NewInt("ws_broker_publish_failures")
+
+// publish relays an event to the other nodes. Failures are logged, not
+// returned: local clients already have the event.
+func (h *Hub) publish(env envelope) {
+	if h.broker == nil {
+		return
+	}
+
+	env.Node = h.nodeID
+	data, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("Error marshaling broker event: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := h.broker.Publish(ctx, data); err != nil {
+		publishFailures.Add(1)
+		log.Printf("Failed to publish %s event for room %s: %v", env.Kind, env.Room, err)
+	}
+}
+
+// subscribe applies events from the other nodes until ctx is done,
+// resubscribing after broker errors.
+func (h *Hub) subscribe(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := h.broker.Subscribe(ctx, h.handleEnvelope); err != nil && ctx.Err() == nil {
+			log.Printf("WebSocket broker subscription failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-h.done:
+			return
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func (h *Hub) handleEnvelope(data []byte) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		log.Printf("Invalid broker event: %v", err)
+		return
+	}
+	if env.Node == h.nodeID {
+		return
+	}
+
+	switch env.Kind {
+	case envelopeBroadcast:
+		h.deliver(env.Room, &frame{data: env.Data, key: env.Key, room: env.Room, seq: env.Seq})
+	case envelopeUsers:
+		h.deliverToUsers(env.Users, env.Type, env.Key, env.Data)
+	case envelopeRevoke:
+		h.removeLocal(env.User, env.Room)
+	case envelopeRecheck:
+		h.recheckLocal(env.User)
+	}
+}
+
+// markChanged queues userID for the next presence sync. Callers hold the
+// lock.
+func (h *Hub) markChanged(userID string) {
+	h.changed[userID] = true
+	select {
+	case h.presenceDirty <- struct{}{}:
+	default:
+	}
+}
+
+// setClientStatus records the status one connection reported, e.g. idle
+// after the tab went to the background.
+func (h *Hub) setClientStatus(client *Client, status string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if !h.clients[client] || client.status == status {
+		return
+	}
+	client.status = status
+	h.markChanged(client.userID)
+}
+
+// OnPresenceChange registers fn to be called when a user's cluster-wide
+// status changes as a result of connections on this node. Must be called
+// before Run.
+func (h *Hub) OnPresenceChange(fn func(userID string, p UserPresence)) {
+	h.onPresence = fn
+}
+
+// syncPresence publishes this node's connected users whenever they change
+// and on every heartbeat, announces status changes, and withdraws the
+// node's entry on shutdown.
+func (h *Hub) syncPresence(ctx context.Context) {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		h.syncOnce(ctx)
+
+		select {
+		case <-h.done:
+			if h.presence != nil {
+				leaveCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				if err := h.presence.Leave(leaveCtx, h.nodeID); err != nil {
+					log.Printf("Failed to withdraw WebSocket presence: %v", err)
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		case <-h.presenceDirty:
+		}
+	}
+}
+
+// syncOnce publishes this node's connected users and announces the
+// status changes since the previous call.
+func (h *Hub) syncOnce(ctx context.Context) {
+	h.mutex.Lock()
+	local := h.localPresence()
+	changed := h.changed
+	h.changed = make(map[string]bool)
+	h.mutex.Unlock()
+
+	if h.presence != nil {
+		syncCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		if err := h.presence.Sync(syncCtx, h.nodeID, local); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to sync WebSocket presence: %v", err)
+		}
+		cancel()
+	}
+
+	if len(changed) > 0 && h.onPresence != nil {
+		h.announce(ctx, changed)
+	}
+}
+
+// announce reports the users whose cluster-wide status differs from what
+// this node last reported. Another device on another node keeps a user
+// online, so a local disconnect is not necessarily an offline event.
+//
+// A node only remembers what it announced while it holds some of the
+// user's connections: once the last one is gone, the node that sees the
+// user go offline is another one, and a later reconnect here must be
+// announced again.
+func (h *Hub) announce(ctx context.Context, changed map[string]bool) {
+	userIDs := make([]string, 0, len(changed))
+	for id := range changed {
+		userIDs = append(userIDs, id)
+	}
+
+	h.mutex.RLock()
+	gone := make(map[string]bool)
+	for _, id := range userIDs {
+		if len(h.users[id]) == 0 {
+			gone[id] = true
+		}
+	}
+	h.mutex.RUnlock()
+
+	lookupCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	current, _ := h.Presence(lookupCtx, userIDs)
+	cancel()
+
+	for _, id := range userIDs {
+		p := current[id]
+		if p.Connections == 0 {
+			p.Status = StatusOffline
+		}
+		if h.announced[id] != p.Status && (h.announced[id] != "" || p.Status != StatusOffline) {
+			h.announced[id] = p.Status
+			h.onPresence(id, p)
+		}
+		if gone[id] || p.Status == StatusOffline {
+			delete(h.announced, id)
+		}
+	}
+}
+
+// localPresence aggregates this node's connections per user. Callers hold
+// the lock.
+func (h *Hub) localPresence() map[string]UserPresence {
+	users := make(map[string]UserPresence, len(h.users))
+	for userID, clients := range h.users {
+		var p UserPresence
+		for client := range clients {
+			p = p.merge(UserPresence{Status: client.status, Connections: 1})
+		}
+		users[userID] = p
+	}
+	return users
+}
+
+func (h *Hub) localUsers() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	users := make([]string, 0, len(h.users))
+	for userID := range h.users {
+		users = append(users, userID)
+	}
+	return users
+}
+
+// Presence returns the status of those userIDs connected to any node;
+// absent users are offline. The shared store already includes this node,
+// so it is authoritative when configured. If it fails, the local view is
+// returned along with the error.
+func (h *Hub) Presence(ctx context.Context, userIDs []string) (map[string]UserPresence, error) {
+	if h.presence != nil {
+		found, err := h.presence.Lookup(ctx, userIDs)
+		if err == nil {
+			return found, nil
+		}
+		log.Printf("Presence lookup failed: %v", err)
+		return h.localLookup(userIDs), err
+	}
+	return h.localLookup(userIDs), nil
+}
+
+func (h *Hub) localLookup(userIDs []string) map[string]UserPresence {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	local := h.localPresence()
+	found := make(map[string]UserPresence, len(userIDs))
+	for _, id := range userIDs {
+		if p, ok := local[id]; ok {
+			found[id] = p
+		}
+	}
+	return found
+}
+
+// Online reports which of userIDs have a connection on any node.
+func (h *Hub) Online(ctx context.Context, userIDs []string) map[string]bool {
+	found, _ := h.Presence(ctx, userIDs)
+	online := make(map[string]bool, len(found))
+	for id := range found {
+		online[id] = true
+	}
+	return online
+}
+
+// IsOnline reports whether userID has a connection on any node.
+func (h *Hub) IsOnline(ctx context.Context, userID string) bool {
+	return h.Online(ctx, []string{userID})[userID]
+}
+
+// HubStats is reported by the health endpoint.
+type HubStats struct {
+	Node        string `json:"node"`
+	Clustered   bool   `json:"clustered"`
+	Connections int    `json:"connections"`
+	Users       int    `json:"users"`
+	Rooms       int    `json:"rooms"`
+	Nodes       int    `json:"nodes"`
+	Dropped     int64  `json:"dropped_frames"`
+	SlowClosed  int64  `json:"slow_disconnects"`
+	// PublishFailures counts events that never reached the other nodes
+	PublishFailures int64 `json:"publish_failures"`
+}
+
+func (h *Hub) Stats(ctx context.Context) HubStats {
+	h.mutex.RLock()
+	stats := HubStats{
+		Node:            h.nodeID,
+		Clustered:       h.broker != nil,
+		Connections:     len(h.clients),
+		Users:           len(h.users),
+		Nodes:           1,
+		Dropped:         droppedFrames.Value(),
+		SlowClosed:      slowDisconnects.Value(),
+		PublishFailures: publishFailures.Value(),
+	}
+	h.mutex.RUnlock()
+	stats.Rooms = h.roomCount()
+
+	if h.presence != nil {
+		if n, err := h.presence.Nodes(ctx); err == nil && n > 0 {
+			stats.Nodes = n
+		}
+	}
+	return stats
+}
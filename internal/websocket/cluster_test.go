@@ -0,0 +1,117 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// memoryPresence is a Presence shared by hubs in the same test.
+type memoryPresence struct {
+	mu    sync.Mutex
+	nodes map[string]map[string]UserPresence
+}
+
+func newMemoryPresence() *memoryPresence {
+	return &memoryPresence{nodes: make(map[string]map[string]UserPresence)}
+}
+
+func (p *memoryPresence) Sync(ctx context.Context, nodeID string, users map[string]UserPresence) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nodes[nodeID] = users
+	return nil
+}
+
+func (p *memoryPresence) Lookup(ctx context.Context, userIDs []string) (map[string]UserPresence, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	found := make(map[string]UserPresence)
+	for _, users := range p.nodes {
+		for _, id := range userIDs {
+			if u, ok := users[id]; ok {
+				found[id] = found[id].merge(u)
+			}
+		}
+	}
+	return found, nil
+}
+
+func (p *memoryPresence) Nodes(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.nodes), nil
+}
+
+func (p *memoryPresence) Leave(ctx context.Context, nodeID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.nodes, nodeID)
+	return nil
+}
+
+// presenceNode is a hub that records the statuses it announces.
+type presenceNode struct {
+	hub       *Hub
+	announced []string
+}
+
+func newPresenceNode(nodeID string, presence Presence) *presenceNode {
+	n := &presenceNode{hub: NewHub()}
+	n.hub.UseBroker(nodeID, nil, presence)
+	n.hub.OnPresenceChange(func(userID string, p UserPresence) {
+		n.announced = append(n.announced, p.Status)
+	})
+	return n
+}
+
+func (n *presenceNode) connect(t *testing.T, userID string) *Client {
+	t.Helper()
+	c := newClient(n.hub, &recordingTransport{discard: true}, userID, DefaultProtocol)
+	if !n.hub.register(c) {
+		t.Fatal("hub refused the client")
+	}
+	n.hub.syncOnce(context.Background())
+	return c
+}
+
+func (n *presenceNode) disconnect(c *Client) {
+	n.hub.unregister(c)
+	n.hub.syncOnce(context.Background())
+}
+
+func (n *presenceNode) last() string {
+	if len(n.announced) == 0 {
+		return ""
+	}
+	return n.announced[len(n.announced)-1]
+}
+
+func TestPresenceReconnectAfterOfflineElsewhere(t *testing.T) {
+	presence := newMemoryPresence()
+	a := newPresenceNode("a", presence)
+	b := newPresenceNode("b", presence)
+
+	onA := a.connect(t, "u1")
+	onB := b.connect(t, "u1")
+	if a.last() != StatusOnline {
+		t.Fatalf("node a announced %v, want online", a.announced)
+	}
+
+	// Still online through b, so a stays quiet
+	a.disconnect(onA)
+	if a.last() != StatusOnline || len(a.announced) != 1 {
+		t.Fatalf("node a announced %v after a local disconnect", a.announced)
+	}
+
+	b.disconnect(onB)
+	if b.last() != StatusOffline {
+		t.Fatalf("node b announced %v, want offline last", b.announced)
+	}
+
+	a.connect(t, "u1")
+	if len(a.announced) != 2 || a.last() != StatusOnline {
+		t.Fatalf("node a announced %v, want the reconnect announced as online", a.announced)
+	}
+}
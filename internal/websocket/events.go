@@ -0,0 +1,287 @@
+package websocket
+
+import (
+	"encoding/json"
+	"psycho-platform/internal/models"
+	"time"
+)
+
+// Every frame is an envelope {"seq", "type", "room", "payload"}; seq is
+// only present on room events when a replay log is configured. The schema
+// of each payload is documented in WEBSOCKET_PROTOCOL.md and mirrored by
+// the event types below.
+type Message struct {
+	Type    string      `json:"type"`
+	Room    string      `json:"room,omitempty"`
+	Payload interface{} `json:"payload"`
+}
+
+// Event is the payload of a server frame.
+type Event interface {
+	EventType() string
+}
+
+// Server event types.
+const (
+	EventHello           = "hello"
+	EventAck             = "ack"
+	EventError           = "error"
+	EventResync          = "resync"
+	EventResumed         = "resumed"
+	EventRoomRevoked     = "room_revoked"
+	EventClosed          = "closed"
+	EventTicket          = "ticket"
+	EventNewMessage      = "new_message"
+	EventNewDM           = "new_dm"
+	EventTyping          = "typing"
+	EventNotification    = "notification"
+	EventPresence        = "presence"
+	EventRead            = "read"
+	EventMessageEdited   = "message_edited"
+	EventMessageDeleted  = "message_deleted"
+	EventMessageRestored = "message_restored"
+	EventMessagePinned   = "message_pinned"
+	EventMessageUnpinned = "message_unpinned"
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+	EventMessageUpdated  = "message_updated"
+	EventPollUpdated     = "poll_updated"
+)
+
+// HelloEvent is the first frame of every connection and confirms the
+// negotiated protocol.
+type HelloEvent struct {
+	Protocol int    `json:"protocol"`
+	Encoding string `json:"encoding"`
+	Resume   bool   `json:"resume"`
+}
+
+func (HelloEvent) EventType() string { return EventHello }
+
+// AckEvent answers a request frame such as send_message.
+type AckEvent struct {
+	ClientMsgID string      `json:"client_msg_id"`
+	Frame       string      `json:"frame"`
+	Data        interface{} `json:"data"`
+}
+
+func (AckEvent) EventType() string { return EventAck }
+
+// ErrorEvent reports a rejected frame. ClientMsgID and Frame identify the
+// request when there is one; Room is set for join and resume failures.
+type ErrorEvent struct {
+	ClientMsgID string `json:"client_msg_id,omitempty"`
+	Frame       string `json:"frame,omitempty"`
+	Room        string `json:"room,omitempty"`
+	Code        string `json:"code"`
+	Error       string `json:"error,omitempty"`
+}
+
+func (ErrorEvent) EventType() string { return EventError }
+
+// ResyncEvent tells a resuming client to refetch a room.
+type ResyncEvent struct {
+	Room   string `json:"room"`
+	Reason string `json:"reason"`
+}
+
+func (ResyncEvent) EventType() string { return EventResync }
+
+// ResumedEvent ends a resume batch and lists the rooms replayed in full.
+type ResumedEvent struct {
+	Rooms []string `json:"rooms"`
+}
+
+func (ResumedEvent) EventType() string { return EventResumed }
+
+// RoomRevokedEvent is sent when the user was removed from a room.
+type RoomRevokedEvent struct {
+	Room string `json:"room"`
+}
+
+func (RoomRevokedEvent) EventType() string { return EventRoomRevoked }
+
+// ClosedEvent ends an SSE stream, which has no close frame, with the
+// close code a WebSocket connection would have received.
+type ClosedEvent struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason,omitempty"`
+}
+
+func (ClosedEvent) EventType() string { return EventClosed }
+
+// TicketEvent hands an SSE stream a fresh single-use ticket to reconnect
+// with.
+type TicketEvent struct {
+	Ticket    string `json:"ticket"`
+	ExpiresIn int    `json:"expires_in"`
+}
+
+func (TicketEvent) EventType() string { return EventTicket }
+
+type NewMessageEvent struct {
+	*models.Message
+}
+
+func (NewMessageEvent) EventType() string { return EventNewMessage }
+
+// MessageEditedEvent carries the new content of an edited topic or group
+// message.
+type MessageEditedEvent struct {
+	ID       string    `json:"id"`
+	Content  string    `json:"content"`
+	EditedBy string    `json:"edited_by"`
+	EditedAt time.Time `json:"edited_at"`
+}
+
+func (MessageEditedEvent) EventType() string { return EventMessageEdited }
+
+// MessageUpdatedEvent carries details of a message filled in after it was
+// sent, currently its link previews.
+type MessageUpdatedEvent struct {
+	ID           string               `json:"id"`
+	LinkPreviews []models.LinkPreview `json:"link_previews"`
+}
+
+func (MessageUpdatedEvent) EventType() string { return EventMessageUpdated }
+
+// MessageDeletedEvent tells a room a message was deleted, by its author or,
+// when Moderated is set, by a moderator.
+type MessageDeletedEvent struct {
+	ID        string    `json:"id"`
+	Moderated bool      `json:"moderated"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func (MessageDeletedEvent) EventType() string { return EventMessageDeleted }
+
+// MessageRestoredEvent brings back a deleted message's content.
+type MessageRestoredEvent struct {
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+func (MessageRestoredEvent) EventType() string { return EventMessageRestored }
+
+// MessagePinnedEvent announces a pinned message with the room's new pin
+// order.
+type MessagePinnedEvent struct {
+	ID       string   `json:"id"`
+	PinnedBy string   `json:"pinned_by"`
+	Pins     []string `json:"pins"`
+}
+
+func (MessagePinnedEvent) EventType() string { return EventMessagePinned }
+
+// MessageUnpinnedEvent announces an unpinned message with the pins left.
+type MessageUnpinnedEvent struct {
+	ID   string   `json:"id"`
+	Pins []string `json:"pins"`
+}
+
+func (MessageUnpinnedEvent) EventType() string { return EventMessageUnpinned }
+
+// PollUpdatedEvent tells a room a poll got votes or closed. Tallies maps
+// option IDs to votes and is left out while the results are hidden from
+// everyone; with ResultsForVoters only users who voted should be shown
+// them.
+type PollUpdatedEvent struct {
+	PollID           string         `json:"poll_id"`
+	MessageID        string         `json:"message_id"`
+	TotalVoters      int            `json:"total_voters"`
+	IsClosed         bool           `json:"is_closed"`
+	Tallies          map[string]int `json:"tallies,omitempty"`
+	ResultsForVoters bool           `json:"results_for_voters,omitempty"`
+}
+
+func (PollUpdatedEvent) EventType() string { return EventPollUpdated }
+
+// ReactionEvent describes a user's reaction to a message with the emoji's
+// count on it afterwards.
+type ReactionEvent struct {
+	MessageID string `json:"message_id"`
+	UserID    string `json:"user_id"`
+	Emoji     string `json:"emoji"`
+	Count     int    `json:"count"`
+}
+
+type ReactionAddedEvent struct {
+	ReactionEvent
+}
+
+func (ReactionAddedEvent) EventType() string { return EventReactionAdded }
+
+type ReactionRemovedEvent struct {
+	ReactionEvent
+}
+
+func (ReactionRemovedEvent) EventType() string { return EventReactionRemoved }
+
+type NewDMEvent struct {
+	*models.DirectMessage
+}
+
+func (NewDMEvent) EventType() string { return EventNewDM }
+
+type TypingEvent struct {
+	UserID   string `json:"user_id"`
+	IsTyping bool   `json:"is_typing"`
+}
+
+func (TypingEvent) EventType() string { return EventTyping }
+
+func (e TypingEvent) coalesceKey() string { return "typing:" + e.UserID }
+
+type NotificationEvent struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	Link    string `json:"link"`
+}
+
+func (NotificationEvent) EventType() string { return EventNotification }
+
+type PresenceEvent struct {
+	UserID   string    `json:"user_id"`
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func (PresenceEvent) EventType() string { return EventPresence }
+
+func (e PresenceEvent) coalesceKey() string { return "presence:" + e.UserID }
+
+// ReadEvent is sent to a user's personal room when their read cursor in a
+// topic or group moves, so their other devices can update unread badges.
+type ReadEvent struct {
+	Room              string    `json:"room"`
+	LastReadMessageID *string   `json:"last_read_message_id"`
+	LastReadAt        time.Time `json:"last_read_at"`
+	UnreadCount       int       `json:"unread_count"`
+	MentionCount      int       `json:"mention_count"`
+}
+
+func (ReadEvent) EventType() string { return EventRead }
+
+// ephemeral events describe transient state: a client that falls behind
+// only needs the latest one per key, and they are never replayed.
+type ephemeral interface {
+	coalesceKey() string
+}
+
+// coalesceKey identifies which queued frames an event supersedes, or is
+// empty for events that must all be delivered.
+func coalesceKey(roomID string, e Event) string {
+	if eph, ok := e.(ephemeral); ok {
+		return roomID + "|" + eph.coalesceKey()
+	}
+	return ""
+}
+
+// encodeEvent builds the JSON frame for an event. Frames are always
+// encoded as JSON first; binary clients get them transcoded on the way
+// out.
+func encodeEvent(roomID string, e Event) ([]byte, error) {
+	return json.Marshal(Message{Type: e.EventType(), Room: roomID, Payload: e})
+}
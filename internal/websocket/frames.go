@@ -0,0 +1,71 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+)
+
+// FrameHandler processes a client frame on behalf of userID. The result is
+// returned to the client in an ack frame; an error becomes an error frame.
+type FrameHandler func(ctx context.Context, userID string, payload json.RawMessage) (interface{}, error)
+
+// CodedError lets a FrameHandler report a machine-readable error code
+// instead of the generic "internal".
+type CodedError interface {
+	error
+	Code() string
+}
+
+// HandleFrame routes client frames of frameType to fn.
+func (h *Hub) HandleFrame(frameType string, fn FrameHandler) {
+	h.frameMu.Lock()
+	defer h.frameMu.Unlock()
+	h.frameHandlers[frameType] = fn
+}
+
+func (h *Hub) frameHandler(frameType string) FrameHandler {
+	h.frameMu.RLock()
+	defer h.frameMu.RUnlock()
+	return h.frameHandlers[frameType]
+}
+
+// dispatchFrame runs the handler for a request frame and answers with an
+// ack or error frame carrying the client's client_msg_id, so the client can
+// match the reply to its pending send and retry safely.
+func (c *Client) dispatchFrame(handler FrameHandler, frameType string, payload json.RawMessage) {
+	var ref struct {
+		ClientMsgID string `json:"client_msg_id"`
+	}
+	json.Unmarshal(payload, &ref)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := handler(ctx, c.userID, payload)
+	if err != nil {
+		code, text := "internal", "Internal error"
+		var coded CodedError
+		if errors.As(err, &coded) {
+			code, text = coded.Code(), coded.Error()
+		} else {
+			log.Printf("WebSocket %s from %s failed: %v", frameType, c.userID, err)
+		}
+
+		c.hub.sendToClient(c, "", ErrorEvent{
+			ClientMsgID: ref.ClientMsgID,
+			Frame:       frameType,
+			Code:        code,
+			Error:       text,
+		})
+		return
+	}
+
+	c.hub.sendToClient(c, "", AckEvent{
+		ClientMsgID: ref.ClientMsgID,
+		Frame:       frameType,
+		Data:        result,
+	})
+}
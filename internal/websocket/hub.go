@@ -1,111 +1,424 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"psycho-platform/internal/rooms"
 	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
 )
 
+// RoomAuthorizer decides whether a user may join a room. Without one the
+// hub refuses every join.
+type RoomAuthorizer interface {
+	CanJoin(ctx context.Context, userID, roomID string) (bool, error)
+}
+
 type Hub struct {
+	// mutex guards the client and user indexes. Room membership lives in
+	// shards with their own locks, so broadcasts never take it.
 	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	rooms      map[string]map[*Client]bool
+	shards     [roomShardCount]roomShard
 	mutex      sync.RWMutex
+	authorizer RoomAuthorizer
+
+	// queueSize is each connection's send buffer; a connection that drops
+	// maxDrops events in a row without catching up is disconnected.
+	queueSize int
+	maxDrops  int
+
+	validator       AccountValidator
+	revalidateEvery time.Duration
+
+	frameMu       sync.RWMutex
+	frameHandlers map[string]FrameHandler
+
+	replay ReplayLog
+
+	// typingSeen holds when each "room|user" typing signal was last acted
+	// upon, for throttling.
+	typing     TypingStore
+	typingMu   sync.Mutex
+	typingSeen map[string]time.Time
+
+	// Without a broker the hub only reaches clients connected to this
+	// process; with one, events are also relayed to the other nodes.
+	nodeID     string
+	broker     Broker
+	presence   Presence
+	background sync.WaitGroup
+
+	// users indexes this node's connections by user. changed collects users
+	// whose connections changed since the last presence sync; announced is
+	// the last status reported to onPresence.
+	users         map[string]map[*Client]bool
+	changed       map[string]bool
+	announced     map[string]string
+	presenceDirty chan struct{}
+	onPresence    func(userID string, p UserPresence)
+
+	// done is closed by Shutdown; pumps tracks running writePumps so
+	// Shutdown can wait for close frames to be flushed.
+	done     chan struct{}
+	stopOnce sync.Once
+	pumps    sync.WaitGroup
 }
 
 func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		rooms:      make(map[string]map[*Client]bool),
+	h := &Hub{
+		clients:       make(map[*Client]bool),
+		queueSize:     256,
+		maxDrops:      8,
+		frameHandlers: make(map[string]FrameHandler),
+		typing:        NewMemoryTypingStore(),
+		typingSeen:    make(map[string]time.Time),
+		nodeID:        DefaultNodeID(),
+		users:         make(map[string]map[*Client]bool),
+		changed:       make(map[string]bool),
+		announced:     make(map[string]string),
+		presenceDirty: make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	for i := range h.shards {
+		h.shards[i].rooms = make(map[string]map[*Client]bool)
 	}
+	return h
 }
 
+// SetQueueLimits sets the per-connection send buffer and how many events
+// in a row a connection may miss before it is disconnected. Non-positive
+// values keep the defaults. Must be called before Run.
+func (h *Hub) SetQueueLimits(queueSize, maxDrops int) {
+	if queueSize > 0 {
+		h.queueSize = queueSize
+	}
+	if maxDrops > 0 {
+		h.maxDrops = maxDrops
+	}
+}
+
+// UseBroker connects the hub to the other nodes. presence may be nil, in
+// which case only local connections count as online. Must be called
+// before Run.
+func (h *Hub) UseBroker(nodeID string, broker Broker, presence Presence) {
+	if nodeID != "" {
+		h.nodeID = nodeID
+	}
+	h.broker = broker
+	h.presence = presence
+}
+
+// NodeID identifies this hub among the nodes sharing a broker.
+func (h *Hub) NodeID() string {
+	return h.nodeID
+}
+
+// Run starts the hub's background work and blocks until Shutdown.
 func (h *Hub) Run() {
-	for {
-		select {
-		case client := <-h.register:
-			h.mutex.Lock()
-			h.clients[client] = true
-			h.mutex.Unlock()
-
-		case client := <-h.unregister:
-			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-
-				// Remove from all rooms
-				for roomID := range h.rooms {
-					delete(h.rooms[roomID], client)
-				}
-			}
-			h.mutex.Unlock()
-
-		case message := <-h.broadcast:
-			h.mutex.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-			h.mutex.RUnlock()
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if h.broker != nil {
+		h.background.Add(1)
+		go func() {
+			defer h.background.Done()
+			h.subscribe(ctx)
+		}()
+	}
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		h.syncPresence(ctx)
+	}()
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		h.expireTyping(ctx)
+	}()
+	if h.validator != nil && h.revalidateEvery > 0 {
+		h.background.Add(1)
+		go func() {
+			defer h.background.Done()
+			h.revalidate(ctx)
+		}()
 	}
+
+	<-h.done
 }
 
-func (h *Hub) JoinRoom(client *Client, roomID string) {
+// register adds a connection and accounts for its writePump. It fails once
+// Shutdown has started.
+func (h *Hub) register(client *Client) bool {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	if h.rooms[roomID] == nil {
-		h.rooms[roomID] = make(map[*Client]bool)
+	select {
+	case <-h.done:
+		return false
+	default:
 	}
-	h.rooms[roomID][client] = true
 
-	log.Printf("Client %s joined room %s", client.userID, roomID)
+	h.clients[client] = true
+	if h.users[client.userID] == nil {
+		h.users[client.userID] = make(map[*Client]bool)
+	}
+	h.users[client.userID][client] = true
+	h.markChanged(client.userID)
+	h.pumps.Add(1)
+	return true
 }
 
-func (h *Hub) LeaveRoom(client *Client, roomID string) {
+// unregister forgets a connection whose pumps are exiting and clears the
+// typing indicators it left behind.
+func (h *Hub) unregister(client *Client) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	h.removeClient(client)
+	h.mutex.Unlock()
+
+	select {
+	case <-h.done:
+		// Left to expire; the broker may already be gone
+		return
+	default:
+	}
+	for _, roomID := range client.takeTyping() {
+		h.StopTyping(client.userID, roomID)
+	}
+}
+
+// removeClient closes client and forgets it. It reports whether that was
+// the user's last connection on this node. Callers hold the lock.
+func (h *Hub) removeClient(client *Client) bool {
+	if !h.clients[client] {
+		return false
+	}
+
+	delete(h.clients, client)
+	client.close(0, "")
+	h.leaveAll(client)
+
+	h.markChanged(client.userID)
+	delete(h.users[client.userID], client)
+	if len(h.users[client.userID]) > 0 {
+		return false
+	}
+	delete(h.users, client.userID)
+	return true
+}
+
+// disconnectUser closes every local connection of userID with the given
+// close frame.
+func (h *Hub) disconnectUser(userID string, code int, reason string) {
+	h.mutex.Lock()
+	last := false
+	for client := range h.users[userID] {
+		client.close(code, reason)
+		last = h.removeClient(client) || last
+	}
+	h.mutex.Unlock()
+
+	if last {
+		log.Printf("Disconnected %s: %s", userID, reason)
+	}
+}
+
+// CloseConnections stops the hub from accepting clients and closes every
+// connection with a "service restart" close frame so clients reconnect to
+// another instance. SSE streams end with it too; register it with
+// http.Server.RegisterOnShutdown so they do not hold up the HTTP drain.
+func (h *Hub) CloseConnections() {
+	h.stopOnce.Do(func() {
+		close(h.done)
+
+		h.mutex.Lock()
+		for client := range h.clients {
+			client.close(websocket.CloseServiceRestart, "reconnect")
+			h.removeClient(client)
+		}
+		h.mutex.Unlock()
+	})
+}
+
+// Shutdown stops the hub, closing its connections if CloseConnections has
+// not yet, and waits for the close frames to be written or for ctx to
+// expire.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.CloseConnections()
 
-	if h.rooms[roomID] != nil {
-		delete(h.rooms[roomID], client)
-		if len(h.rooms[roomID]) == 0 {
-			delete(h.rooms, roomID)
+	finished := make(chan struct{})
+	go func() {
+		h.pumps.Wait()
+		h.background.Wait()
+		if h.broker != nil {
+			h.broker.Close()
 		}
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	log.Printf("Client %s left room %s", client.userID, roomID)
+// SetAuthorizer installs the membership check used for join_room.
+func (h *Hub) SetAuthorizer(a RoomAuthorizer) {
+	h.authorizer = a
 }
 
-func (h *Hub) BroadcastToRoom(roomID string, message interface{}) {
+func (h *Hub) authorize(userID, roomID string) bool {
+	if h.authorizer == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ok, err := h.authorizer.CanJoin(ctx, userID, roomID)
+	if err != nil {
+		log.Printf("Room authorization failed for %s in %s: %v", userID, roomID, err)
+		return false
+	}
+	return ok
+}
+
+// RemoveUserFromRoom evicts every connection of userID from roomID on
+// every node and sends them a room_revoked event, e.g. after leaving or
+// removal from a group.
+func (h *Hub) RemoveUserFromRoom(userID, roomID string) {
+	h.removeLocal(userID, roomID)
+	h.publish(envelope{Kind: envelopeRevoke, Room: roomID, User: userID})
+}
+
+func (h *Hub) removeLocal(userID, roomID string) {
+	f, _ := eventFrame(roomID, RoomRevokedEvent{Room: roomID})
+
+	s := h.shard(roomID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	room := s.rooms[roomID]
+	for client := range room {
+		if client.userID != userID {
+			continue
+		}
+		delete(room, client)
+		client.forgetRoom(roomID)
+		client.enqueue(f)
+		log.Printf("Client %s removed from room %s", userID, roomID)
+	}
+	if room != nil && len(room) == 0 {
+		delete(s.rooms, roomID)
+	}
+}
+
+// RecheckUserRooms re-authorizes every room userID is currently in, on
+// every node, and evicts them from the ones they may no longer see, e.g.
+// after a block.
+func (h *Hub) RecheckUserRooms(userID string) {
+	h.recheckLocal(userID)
+	h.publish(envelope{Kind: envelopeRecheck, User: userID})
+}
+
+func (h *Hub) recheckLocal(userID string) {
 	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+	joined := make(map[string]bool)
+	for client := range h.users[userID] {
+		for _, roomID := range client.joinedRooms() {
+			joined[roomID] = true
+		}
+	}
+	h.mutex.RUnlock()
 
-	data, err := json.Marshal(message)
+	for roomID := range joined {
+		if !h.authorize(userID, roomID) {
+			h.removeLocal(userID, roomID)
+		}
+	}
+}
+
+// sendToClient queues an event for a single connection. roomID is empty
+// for events that are not about a room.
+func (h *Hub) sendToClient(client *Client, roomID string, e Event) {
+	f, err := eventFrame(roomID, e)
+	if err != nil {
+		log.Printf("Error marshaling %s event: %v", e.EventType(), err)
+		return
+	}
+	client.enqueue(f)
+}
+
+// BroadcastToRoom sends an event to the room's clients on this node and
+// relays it through the broker to the other nodes. With a replay log the
+// event carries the room's next sequence number; ephemeral events such as
+// typing are neither numbered nor replayed.
+func (h *Hub) BroadcastToRoom(roomID string, e Event) {
+	data, err := encodeEvent(roomID, e)
+	if err != nil {
+		log.Printf("Error marshaling %s event: %v", e.EventType(), err)
+		return
+	}
+
+	f := &frame{data: data, key: coalesceKey(roomID, e), room: roomID}
+	if h.replay != nil && f.key == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		seq, sequenced, err := h.replay.Append(ctx, roomID, data[1:])
+		cancel()
+		if err != nil {
+			// Still deliver live; only resuming clients miss out
+			log.Printf("Failed to log event for room %s: %v", roomID, err)
+		} else {
+			f.data, f.seq = sequenced, seq
+		}
+	}
+
+	h.deliver(roomID, f)
+	h.publish(envelope{Kind: envelopeBroadcast, Room: roomID, Key: f.key, Seq: f.seq, Data: f.data})
+}
+
+// BroadcastToUsers sends an ephemeral event to the user rooms of userIDs
+// with a single broker publish, instead of one per user. Other events are
+// numbered per room, so they still go out room by room.
+func (h *Hub) BroadcastToUsers(userIDs []string, e Event) {
+	eph, ok := e.(ephemeral)
+	if !ok {
+		for _, id := range userIDs {
+			h.BroadcastToRoom(rooms.User(id), e)
+		}
+		return
+	}
+	if len(userIDs) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(e)
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+		log.Printf("Error marshaling %s event: %v", e.EventType(), err)
 		return
 	}
+	if !h.deliverToUsers(userIDs, e.EventType(), eph.coalesceKey(), payload) {
+		return
+	}
+	h.publish(envelope{Kind: envelopeUsers, Users: userIDs, Type: e.EventType(), Key: eph.coalesceKey(), Data: payload})
+}
 
-	if room, ok := h.rooms[roomID]; ok {
-		for client := range room {
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-				delete(room, client)
-			}
+// deliverToUsers queues an encoded event payload for the local clients in
+// the user rooms of userIDs. It reports false if the frames could not be
+// built.
+func (h *Hub) deliverToUsers(userIDs []string, eventType, key string, payload json.RawMessage) bool {
+	for _, id := range userIDs {
+		roomID := rooms.User(id)
+		data, err := json.Marshal(Message{Type: eventType, Room: roomID, Payload: payload})
+		if err != nil {
+			log.Printf("Error marshaling %s event: %v", eventType, err)
+			return false
 		}
+		h.deliver(roomID, &frame{data: data, key: roomID + "|" + key, room: roomID})
 	}
+	return true
 }
@@ -0,0 +1,253 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"psycho-platform/internal/rooms"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport keeps the frames written to it, encoded as the
+// client's protocol would put them on the wire, after waiting delay per
+// frame to model a slow connection. Benchmarks discard them.
+type recordingTransport struct {
+	encoding string
+	delay    time.Duration
+	discard  bool
+
+	mu     sync.Mutex
+	frames [][]byte
+}
+
+func (t *recordingTransport) writeFrame(f *frame) error {
+	if t.delay > 0 {
+		time.Sleep(t.delay)
+	}
+	data := f.data
+	if t.encoding == EncodingMsgpack {
+		var err error
+		if data, err = f.msgpack(); err != nil {
+			return err
+		}
+	}
+	if !t.discard {
+		t.mu.Lock()
+		t.frames = append(t.frames, data)
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+func (t *recordingTransport) heartbeat() error                   { return nil }
+func (t *recordingTransport) heartbeatInterval() time.Duration   { return time.Hour }
+func (t *recordingTransport) writeClose(code int, reason string) {}
+func (t *recordingTransport) finish()                            {}
+
+func (t *recordingTransport) received() [][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([][]byte(nil), t.frames...)
+}
+
+// connect registers a client of userID on hub, joined to its personal
+// rooms and roomIDs, with its write pump running.
+func connect(tb testing.TB, hub *Hub, userID string, t *recordingTransport, roomIDs ...string) *Client {
+	tb.Helper()
+	proto := DefaultProtocol
+	proto.Encoding = t.encoding
+	if proto.Encoding == "" {
+		proto.Encoding = EncodingJSON
+	}
+	c := newClient(hub, t, userID, proto)
+	if !hub.register(c) {
+		tb.Fatal("hub refused the client")
+	}
+	c.start()
+	for _, roomID := range roomIDs {
+		hub.JoinRoom(c, roomID)
+	}
+	go c.writePump()
+	return c
+}
+
+func quietLogs(tb testing.TB) {
+	log.SetOutput(io.Discard)
+	tb.Cleanup(func() { log.SetOutput(os.Stderr) })
+}
+
+func shutdown(tb testing.TB, hub *Hub) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hub.Shutdown(ctx); err != nil {
+		tb.Errorf("Shutdown: %v", err)
+	}
+}
+
+// waitFrames waits until t has received n frames.
+func waitFrames(tb testing.TB, t *recordingTransport, n int) [][]byte {
+	tb.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		frames := t.received()
+		if len(frames) >= n {
+			return frames
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("received %d frames, want %d", len(frames), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func decodeFrame(tb testing.TB, data []byte) Message {
+	tb.Helper()
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		tb.Fatalf("invalid frame %s: %v", data, err)
+	}
+	return msg
+}
+
+func TestBroadcastToRoom(t *testing.T) {
+	quietLogs(t)
+	hub := NewHub()
+	defer shutdown(t, hub)
+
+	member := &recordingTransport{}
+	binary := &recordingTransport{encoding: EncodingMsgpack}
+	outsider := &recordingTransport{}
+	connect(t, hub, "u1", member, "topic_1")
+	connect(t, hub, "u2", binary, "topic_1")
+	connect(t, hub, "u3", outsider, "topic_2")
+
+	event := ReactionAddedEvent{ReactionEvent{MessageID: "m1", UserID: "u1", Emoji: "👍", Count: 2}}
+	hub.BroadcastToRoom("topic_1", event)
+
+	// Every client got its hello first
+	frames := waitFrames(t, member, 2)
+	if msg := decodeFrame(t, frames[1]); msg.Type != EventReactionAdded || msg.Room != "topic_1" {
+		t.Errorf("member got %s, want %s in topic_1", frames[1], EventReactionAdded)
+	}
+
+	packed := waitFrames(t, binary, 2)[1]
+	unpacked, err := msgpackToJSON(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := decodeFrame(t, unpacked); msg.Type != EventReactionAdded {
+		t.Errorf("binary client got %s, want %s", unpacked, EventReactionAdded)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if frames := outsider.received(); len(frames) != 1 {
+		t.Errorf("outsider got %d frames, want only the hello", len(frames))
+	}
+}
+
+func TestBroadcastToUsers(t *testing.T) {
+	quietLogs(t)
+	hub := NewHub()
+	defer shutdown(t, hub)
+
+	watchers := []*recordingTransport{{}, {}}
+	connect(t, hub, "u1", watchers[0])
+	connect(t, hub, "u2", watchers[1])
+	other := &recordingTransport{}
+	connect(t, hub, "u3", other)
+
+	hub.BroadcastToUsers([]string{"u1", "u2"}, PresenceEvent{UserID: "u9", Status: StatusOnline})
+
+	for i, w := range watchers {
+		frames := waitFrames(t, w, 2)
+		msg := decodeFrame(t, frames[1])
+		if want := rooms.User(fmt.Sprintf("u%d", i+1)); msg.Type != EventPresence || msg.Room != want {
+			t.Errorf("watcher %d got %s, want presence in %s", i+1, frames[1], want)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if frames := other.received(); len(frames) != 1 {
+		t.Errorf("non-watcher got %d frames, want only the hello", len(frames))
+	}
+}
+
+func TestSlowClientIsDisconnected(t *testing.T) {
+	quietLogs(t)
+	hub := NewHub()
+	hub.SetQueueLimits(1, 2)
+	defer shutdown(t, hub)
+
+	slow := &recordingTransport{delay: 50 * time.Millisecond}
+	c := connect(t, hub, "u1", slow, "topic_1")
+
+	for i := 0; i < 10; i++ {
+		hub.BroadcastToRoom("topic_1", ReactionAddedEvent{ReactionEvent{MessageID: "m1", Count: i}})
+	}
+	select {
+	case <-c.closing:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow client was not disconnected")
+	}
+	if c.closeCode != CloseSlowConsumer {
+		t.Errorf("close code = %d, want %d", c.closeCode, CloseSlowConsumer)
+	}
+}
+
+// benchmarkBroadcast broadcasts to a room of clients, slow of which take
+// a millisecond per frame and fall behind.
+func benchmarkBroadcast(b *testing.B, clients, slow int, encoding string) {
+	quietLogs(b)
+	hub := NewHub()
+	defer shutdown(b, hub)
+
+	for i := 0; i < clients; i++ {
+		t := &recordingTransport{encoding: encoding, discard: true}
+		if i < slow {
+			t.delay = time.Millisecond
+		}
+		connect(b, hub, fmt.Sprintf("u%d", i), t, "topic_1")
+	}
+	event := ReactionAddedEvent{ReactionEvent{MessageID: "m1", UserID: "u1", Emoji: "👍", Count: 1}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		hub.BroadcastToRoom("topic_1", event)
+	}
+}
+
+func BenchmarkBroadcastFastJSON(b *testing.B)    { benchmarkBroadcast(b, 10000, 0, EncodingJSON) }
+func BenchmarkBroadcastFastMsgpack(b *testing.B) { benchmarkBroadcast(b, 10000, 0, EncodingMsgpack) }
+func BenchmarkBroadcastSlowJSON(b *testing.B)    { benchmarkBroadcast(b, 10000, 1000, EncodingJSON) }
+func BenchmarkBroadcastSlowMsgpack(b *testing.B) { benchmarkBroadcast(b, 10000, 1000, EncodingMsgpack) }
+
+func TestTypingOutsideRoomIsForbidden(t *testing.T) {
+	quietLogs(t)
+	hub := NewHub()
+	defer shutdown(t, hub)
+
+	member := &recordingTransport{}
+	outsider := &recordingTransport{}
+	connect(t, hub, "u1", member, "topic_1")
+	c := connect(t, hub, "u2", outsider)
+
+	for _, typing := range []string{`{"is_typing":true}`, `{"is_typing":false}`} {
+		c.typingFrame("topic_1", json.RawMessage(typing))
+	}
+
+	frames := waitFrames(t, outsider, 3)
+	for _, f := range frames[1:] {
+		if msg := decodeFrame(t, f); msg.Type != EventError {
+			t.Errorf("outsider got %s, want a forbidden error", f)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	if frames := member.received(); len(frames) != 1 {
+		t.Errorf("member got %d frames, want only the hello", len(frames))
+	}
+}
@@ -0,0 +1,270 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// presenceTTL is how long a node's entries survive without a heartbeat,
+	// so users on a crashed node drop offline on their own.
+	presenceTTL       = 90 * time.Second
+	presenceHeartbeat = 30 * time.Second
+)
+
+// Presence statuses, from most to least available. A user's status is the
+// most available status among their connections.
+const (
+	StatusOnline  = "online"
+	StatusIdle    = "idle"
+	StatusAway    = "away"
+	StatusOffline = "offline"
+)
+
+func statusRank(status string) int {
+	switch status {
+	case StatusOnline:
+		return 3
+	case StatusIdle:
+		return 2
+	case StatusAway:
+		return 1
+	}
+	return 0
+}
+
+// ValidClientStatus reports whether a client may report status for itself.
+func ValidClientStatus(status string) bool {
+	return status == StatusOnline || status == StatusIdle || status == StatusAway
+}
+
+// UserPresence aggregates a user's connections across devices.
+type UserPresence struct {
+	Status      string `json:"status"`
+	Connections int    `json:"connections"`
+}
+
+// merge folds another node's view of the same user into p.
+func (p UserPresence) merge(other UserPresence) UserPresence {
+	p.Connections += other.Connections
+	if statusRank(other.Status) > statusRank(p.Status) {
+		p.Status = other.Status
+	}
+	return p
+}
+
+// Presence records which users are connected to which node, so any node
+// can answer "is this user online" for the whole cluster.
+type Presence interface {
+	// Sync replaces nodeID's entry with the users connected to it.
+	Sync(ctx context.Context, nodeID string, users map[string]UserPresence) error
+	// Lookup returns the cluster-wide presence of those userIDs that are
+	// connected to any live node.
+	Lookup(ctx context.Context, userIDs []string) (map[string]UserPresence, error)
+	// Nodes counts the live nodes.
+	Nodes(ctx context.Context) (int, error)
+	// Leave removes nodeID's entry on shutdown.
+	Leave(ctx context.Context, nodeID string) error
+}
+
+const redisPresenceNodes = "ws:nodes"
+
+func redisPresenceKey(nodeID string) string {
+	return "ws:presence:" + nodeID
+}
+
+// RedisPresence keeps one hash per node mapping user ID to
+// "connections:status", expiring with the node's heartbeat, plus a sorted
+// set of nodes scored by last heartbeat.
+type RedisPresence struct {
+	client *redis.Client
+}
+
+func NewRedisPresence(client *redis.Client) *RedisPresence {
+	return &RedisPresence{client: client}
+}
+
+func (p *RedisPresence) Sync(ctx context.Context, nodeID string, users map[string]UserPresence) error {
+	key := redisPresenceKey(nodeID)
+
+	pipe := p.client.TxPipeline()
+	pipe.Del(ctx, key)
+	if len(users) > 0 {
+		fields := make(map[string]interface{}, len(users))
+		for id, up := range users {
+			fields[id] = strconv.Itoa(up.Connections) + ":" + up.Status
+		}
+		pipe.HSet(ctx, key, fields)
+		pipe.Expire(ctx, key, presenceTTL)
+	}
+	pipe.ZAdd(ctx, redisPresenceNodes, redis.Z{Score: float64(time.Now().Unix()), Member: nodeID})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (p *RedisPresence) liveNodes(ctx context.Context) ([]string, error) {
+	cutoff := time.Now().Add(-presenceTTL).Unix()
+	return p.client.ZRangeByScore(ctx, redisPresenceNodes, &redis.ZRangeBy{
+		Min: strconv.FormatInt(cutoff, 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func (p *RedisPresence) Lookup(ctx context.Context, userIDs []string) (map[string]UserPresence, error) {
+	found := make(map[string]UserPresence, len(userIDs))
+	if len(userIDs) == 0 {
+		return found, nil
+	}
+
+	nodes, err := p.liveNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := p.client.Pipeline()
+	results := make([]*redis.SliceCmd, len(nodes))
+	for i, node := range nodes {
+		results[i] = pipe.HMGet(ctx, redisPresenceKey(node), userIDs...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	for _, res := range results {
+		values, err := res.Result()
+		if err != nil {
+			continue
+		}
+		for i, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				continue
+			}
+			count, status, _ := strings.Cut(s, ":")
+			n, _ := strconv.Atoi(count)
+			found[userIDs[i]] = found[userIDs[i]].merge(UserPresence{Status: status, Connections: n})
+		}
+	}
+
+	return found, nil
+}
+
+func (p *RedisPresence) Nodes(ctx context.Context) (int, error) {
+	nodes, err := p.liveNodes(ctx)
+	return len(nodes), err
+}
+
+func (p *RedisPresence) Leave(ctx context.Context, nodeID string) error {
+	pipe := p.client.TxPipeline()
+	pipe.Del(ctx, redisPresenceKey(nodeID))
+	pipe.ZRem(ctx, redisPresenceNodes, nodeID)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// PostgresPresence stores the same data in ws_nodes and ws_presence for
+// deployments using the Postgres broker.
+type PostgresPresence struct {
+	db *sql.DB
+}
+
+func NewPostgresPresence(db *sql.DB) *PostgresPresence {
+	return &PostgresPresence{db: db}
+}
+
+func (p *PostgresPresence) Sync(ctx context.Context, nodeID string, users map[string]UserPresence) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO ws_nodes (node_id, heartbeat_at) VALUES ($1, NOW())
+		ON CONFLICT (node_id) DO UPDATE SET heartbeat_at = NOW()
+	`, nodeID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM ws_presence WHERE node_id = $1`, nodeID); err != nil {
+		return err
+	}
+
+	if len(users) > 0 {
+		ids := make([]string, 0, len(users))
+		counts := make([]int64, 0, len(users))
+		statuses := make([]string, 0, len(users))
+		for id, up := range users {
+			ids = append(ids, id)
+			counts = append(counts, int64(up.Connections))
+			statuses = append(statuses, up.Status)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO ws_presence (node_id, user_id, connections, status)
+			SELECT $1, u.id, u.connections, u.status
+			FROM unnest($2::uuid[], $3::int[], $4::varchar[]) AS u(id, connections, status)
+		`, nodeID, pq.Array(ids), pq.Array(counts), pq.Array(statuses)); err != nil {
+			return err
+		}
+	}
+
+	// Forget nodes that have been gone for a while; presence rows cascade
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM ws_nodes WHERE heartbeat_at < NOW() - INTERVAL '1 hour'
+	`); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (p *PostgresPresence) Lookup(ctx context.Context, userIDs []string) (map[string]UserPresence, error) {
+	found := make(map[string]UserPresence, len(userIDs))
+	if len(userIDs) == 0 {
+		return found, nil
+	}
+
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT p.user_id, p.connections, p.status
+		FROM ws_presence p
+		JOIN ws_nodes n ON n.node_id = p.node_id
+		WHERE p.user_id = ANY($1::uuid[])
+		  AND n.heartbeat_at > NOW() - $2 * INTERVAL '1 second'
+	`, pq.Array(userIDs), presenceTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var up UserPresence
+		if err := rows.Scan(&id, &up.Connections, &up.Status); err != nil {
+			return nil, err
+		}
+		found[id] = found[id].merge(up)
+	}
+
+	return found, rows.Err()
+}
+
+func (p *PostgresPresence) Nodes(ctx context.Context) (int, error) {
+	var n int
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM ws_nodes
+		WHERE heartbeat_at > NOW() - $1 * INTERVAL '1 second'
+	`, presenceTTL.Seconds()).Scan(&n)
+	return n, err
+}
+
+func (p *PostgresPresence) Leave(ctx context.Context, nodeID string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM ws_nodes WHERE node_id = $1`, nodeID)
+	return err
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ugorji/go/codec"
+)
+
+// ProtocolVersion is the version of the frame schema documented in
+// WEBSOCKET_PROTOCOL.md. Breaking changes to any frame bump it.
+const ProtocolVersion = 1
+
+// Frame encodings. JSON frames are text messages; MessagePack frames are
+// binary messages carrying the same structure.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+const protocolPrefix = "psycho.v"
+
+// Protocol is what a connection negotiated through Sec-WebSocket-Protocol,
+// e.g. "psycho.v1.msgpack".
+type Protocol struct {
+	Version  int
+	Encoding string
+}
+
+// DefaultProtocol applies to clients that offer no versioned subprotocol.
+var DefaultProtocol = Protocol{Version: ProtocolVersion, Encoding: EncodingJSON}
+
+func (p Protocol) Name() string {
+	return protocolPrefix + strconv.Itoa(p.Version) + "." + p.Encoding
+}
+
+// Supported reports whether this server speaks p.
+func (p Protocol) Supported() bool {
+	return p.Version == ProtocolVersion && (p.Encoding == EncodingJSON || p.Encoding == EncodingMsgpack)
+}
+
+// ParseProtocol reads a versioned subprotocol name. ok is false for
+// anything else, such as the ticket subprotocol.
+func ParseProtocol(name string) (Protocol, bool) {
+	rest, found := strings.CutPrefix(name, protocolPrefix)
+	if !found {
+		return Protocol{}, false
+	}
+	version, encoding, found := strings.Cut(rest, ".")
+	if !found {
+		return Protocol{}, false
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil || v <= 0 {
+		return Protocol{}, false
+	}
+	return Protocol{Version: v, Encoding: encoding}, true
+}
+
+// SupportedProtocols lists the subprotocols this server accepts.
+func SupportedProtocols() []string {
+	return []string{
+		Protocol{ProtocolVersion, EncodingJSON}.Name(),
+		Protocol{ProtocolVersion, EncodingMsgpack}.Name(),
+	}
+}
+
+// frame is one outbound event, shared by every connection it is delivered
+// to. The MessagePack form is produced once, by the first binary client.
+// key is set for ephemeral events; see coalesceKey. room and seq are set
+// for sequenced room events.
+type frame struct {
+	data []byte
+	key  string
+	room string
+	seq  int64
+
+	packOnce sync.Once
+	packed   []byte
+	packErr  error
+}
+
+func newFrame(data []byte) *frame {
+	return &frame{data: data}
+}
+
+// eventFrame encodes an event addressed to roomID, which may be empty for
+// connection-level events.
+func eventFrame(roomID string, e Event) (*frame, error) {
+	data, err := encodeEvent(roomID, e)
+	if err != nil {
+		return nil, err
+	}
+	return newFrame(data), nil
+}
+
+func (f *frame) msgpack() ([]byte, error) {
+	f.packOnce.Do(func() {
+		f.packed, f.packErr = jsonToMsgpack(f.data)
+	})
+	return f.packed, f.packErr
+}
+
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+// jsonToMsgpack transcodes a JSON frame, keeping integers as integers.
+func jsonToMsgpack(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(numbersToValues(v))
+	return out, err
+}
+
+func numbersToValues(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, x := range t {
+			t[k] = numbersToValues(x)
+		}
+	case []interface{}:
+		for i, x := range t {
+			t[i] = numbersToValues(x)
+		}
+	}
+	return v
+}
+
+// msgpackToJSON transcodes a binary client frame so it can be handled like
+// a text one.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,271 @@
+package websocket
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrReplayGap means the events after the requested sequence number are no
+// longer retained; the client has to refetch the room's state.
+var ErrReplayGap = errors.New("events no longer retained")
+
+// ReplayLog numbers every room event and keeps the most recent ones so
+// reconnecting clients can catch up with a resume frame.
+type ReplayLog interface {
+	// Append assigns the room's next sequence number to an event and
+	// stores it. body is the encoded event without its opening brace; the
+	// stored frame is {"seq":N, followed by body.
+	Append(ctx context.Context, roomID string, body []byte) (seq int64, frame []byte, err error)
+	// Since returns the room's frames with a sequence number above seq, in
+	// order, or ErrReplayGap if some of them were already dropped.
+	Since(ctx context.Context, roomID string, seq int64) ([][]byte, error)
+}
+
+// ReplayOptions bound how much history a ReplayLog keeps per room.
+type ReplayOptions struct {
+	Size int
+	TTL  time.Duration
+}
+
+func (o ReplayOptions) withDefaults() ReplayOptions {
+	if o.Size <= 0 {
+		o.Size = 500
+	}
+	if o.TTL <= 0 {
+		o.TTL = time.Hour
+	}
+	return o
+}
+
+func sequencedFrame(seq int64, body []byte) []byte {
+	frame := make([]byte, 0, len(body)+24)
+	frame = append(frame, `{"seq":`...)
+	frame = strconv.AppendInt(frame, seq, 10)
+	frame = append(frame, ',')
+	return append(frame, body...)
+}
+
+// MemoryReplayLog keeps a ring of recent frames per room in process. It is
+// only consistent with a single node.
+type MemoryReplayLog struct {
+	opts  ReplayOptions
+	mu    sync.Mutex
+	rooms map[string]*memoryRoomLog
+}
+
+type memoryRoomLog struct {
+	seq     int64
+	frames  [][]byte // frames[i] has sequence number seq-len(frames)+1+i
+	touched time.Time
+}
+
+func NewMemoryReplayLog(opts ReplayOptions) *MemoryReplayLog {
+	return &MemoryReplayLog{opts: opts.withDefaults(), rooms: make(map[string]*memoryRoomLog)}
+}
+
+func (l *MemoryReplayLog) Append(ctx context.Context, roomID string, body []byte) (int64, []byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	room := l.rooms[roomID]
+	if room == nil {
+		l.evictIdle(now)
+		room = &memoryRoomLog{}
+		l.rooms[roomID] = room
+	}
+
+	room.seq++
+	room.touched = now
+	frame := sequencedFrame(room.seq, body)
+	room.frames = append(room.frames, frame)
+	if len(room.frames) > l.opts.Size {
+		room.frames = room.frames[len(room.frames)-l.opts.Size:]
+	}
+
+	return room.seq, frame, nil
+}
+
+// evictIdle drops rooms without events for longer than the TTL. Their
+// counters restart, which clients see as a gap.
+func (l *MemoryReplayLog) evictIdle(now time.Time) {
+	for id, room := range l.rooms {
+		if now.Sub(room.touched) > l.opts.TTL {
+			delete(l.rooms, id)
+		}
+	}
+}
+
+func (l *MemoryReplayLog) Since(ctx context.Context, roomID string, seq int64) ([][]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	room := l.rooms[roomID]
+	if room == nil {
+		if seq == 0 {
+			return nil, nil
+		}
+		return nil, ErrReplayGap
+	}
+	if seq > room.seq {
+		return nil, ErrReplayGap
+	}
+
+	first := room.seq - int64(len(room.frames)) + 1
+	if seq+1 < first {
+		return nil, ErrReplayGap
+	}
+	missed := room.frames[seq+1-first:]
+	return append([][]byte(nil), missed...), nil
+}
+
+// appendScript bumps the room counter and writes the frame to the room's
+// stream in one step, so stream IDs (seq-0) always increase even when
+// several nodes append concurrently.
+var appendScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+local frame = '{"seq":' .. seq .. ',' .. ARGV[1]
+redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[2], seq .. '-0', 'f', frame)
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+return seq
+`)
+
+// RedisReplayLog keeps a capped stream per room whose entry IDs are the
+// sequence numbers. Counters are kept without expiry so they never go back.
+type RedisReplayLog struct {
+	client *redis.Client
+	opts   ReplayOptions
+}
+
+func NewRedisReplayLog(client *redis.Client, opts ReplayOptions) *RedisReplayLog {
+	return &RedisReplayLog{client: client, opts: opts.withDefaults()}
+}
+
+func (l *RedisReplayLog) Append(ctx context.Context, roomID string, body []byte) (int64, []byte, error) {
+	seq, err := appendScript.Run(ctx, l.client,
+		[]string{"ws:seq:" + roomID, "ws:log:" + roomID},
+		string(body), l.opts.Size, l.opts.TTL.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, nil, err
+	}
+	return seq, sequencedFrame(seq, body), nil
+}
+
+func (l *RedisReplayLog) Since(ctx context.Context, roomID string, seq int64) ([][]byte, error) {
+	current, err := l.client.Get(ctx, "ws:seq:"+roomID).Int64()
+	if err == redis.Nil {
+		current = 0
+	} else if err != nil {
+		return nil, err
+	}
+	if seq > current {
+		return nil, ErrReplayGap
+	}
+	if seq == current {
+		return nil, nil
+	}
+
+	entries, err := l.client.XRange(ctx, "ws:log:"+roomID, "("+strconv.FormatInt(seq, 10)+"-0", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	// The first retained entry must directly follow seq
+	if len(entries) == 0 || entries[0].ID != strconv.FormatInt(seq+1, 10)+"-0" {
+		return nil, ErrReplayGap
+	}
+
+	frames := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		if f, ok := e.Values["f"].(string); ok {
+			frames = append(frames, []byte(f))
+		}
+	}
+	return frames, nil
+}
+
+// PostgresReplayLog stores counters in ws_room_seq and frames in
+// ws_events, for deployments using the Postgres broker. Old events are
+// removed by the ws.events.prune job.
+type PostgresReplayLog struct {
+	db   *sql.DB
+	opts ReplayOptions
+}
+
+func NewPostgresReplayLog(db *sql.DB, opts ReplayOptions) *PostgresReplayLog {
+	return &PostgresReplayLog{db: db, opts: opts.withDefaults()}
+}
+
+// Append takes the room's counter row lock for the duration of the insert,
+// so sequence numbers are gapless and committed in order.
+func (l *PostgresReplayLog) Append(ctx context.Context, roomID string, body []byte) (int64, []byte, error) {
+	var seq int64
+	err := l.db.QueryRowContext(ctx, `
+		WITH next AS (
+			INSERT INTO ws_room_seq (room, seq) VALUES ($1, 1)
+			ON CONFLICT (room) DO UPDATE SET seq = ws_room_seq.seq + 1
+			RETURNING seq
+		)
+		INSERT INTO ws_events (room, seq, frame)
+		SELECT $1, next.seq, '{"seq":' || next.seq || ',' || $2 FROM next
+		RETURNING seq
+	`, roomID, string(body)).Scan(&seq)
+	if err != nil {
+		return 0, nil, err
+	}
+	return seq, sequencedFrame(seq, body), nil
+}
+
+func (l *PostgresReplayLog) Since(ctx context.Context, roomID string, seq int64) ([][]byte, error) {
+	var current int64
+	err := l.db.QueryRowContext(ctx, `SELECT seq FROM ws_room_seq WHERE room = $1`, roomID).Scan(&current)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if seq > current {
+		return nil, ErrReplayGap
+	}
+	if seq == current {
+		return nil, nil
+	}
+
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT seq, frame FROM ws_events
+		WHERE room = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3
+	`, roomID, seq, l.opts.Size+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	frames := [][]byte{}
+	expected := seq + 1
+	for rows.Next() {
+		var n int64
+		var frame string
+		if err := rows.Scan(&n, &frame); err != nil {
+			return nil, err
+		}
+		if n != expected {
+			return nil, ErrReplayGap
+		}
+		expected++
+		frames = append(frames, []byte(frame))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if expected <= current || len(frames) > l.opts.Size {
+		return nil, ErrReplayGap
+	}
+
+	return frames, nil
+}
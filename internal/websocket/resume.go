@@ -0,0 +1,83 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// UseReplayLog enables sequence numbers and resume. Must be called before
+// Run.
+func (h *Hub) UseReplayLog(l ReplayLog) {
+	h.replay = l
+}
+
+// resumeRequest maps room names to the last sequence number the client
+// processed in that room.
+type resumeRequest struct {
+	Rooms map[string]int64 `json:"rooms"`
+}
+
+// resume handles a resume frame.
+func (c *Client) resume(payload json.RawMessage) {
+	var req resumeRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		c.hub.sendToClient(c, "", ErrorEvent{Frame: "resume", Code: "invalid_payload"})
+		return
+	}
+	c.resumeRooms(req.Rooms)
+}
+
+// resumeRooms rejoins the given rooms and replays what the client missed
+// after each sequence number. Rooms that cannot be replayed get a resync
+// frame instead; the batch ends with a resumed frame. Live events that
+// raced the replay may arrive twice, so clients skip sequence numbers they
+// have already seen.
+func (c *Client) resumeRooms(cursor map[string]int64) {
+	batch := []*frame{}
+	resumed := []string{}
+	for roomID, seq := range cursor {
+		if !c.hub.authorize(c.userID, roomID) {
+			f, _ := eventFrame(roomID, ErrorEvent{Frame: "resume", Room: roomID, Code: "forbidden"})
+			batch = append(batch, f)
+			continue
+		}
+
+		// Join before reading the log so nothing falls between the two
+		c.hub.JoinRoom(c, roomID)
+
+		frames, err := c.hub.replaySince(roomID, seq)
+		if err != nil {
+			if err != ErrReplayGap {
+				log.Printf("Replay for %s in %s failed: %v", c.userID, roomID, err)
+			}
+			f, _ := eventFrame(roomID, ResyncEvent{Room: roomID, Reason: "too_old"})
+			batch = append(batch, f)
+			continue
+		}
+
+		for i, data := range frames {
+			batch = append(batch, &frame{data: data, room: roomID, seq: seq + 1 + int64(i)})
+		}
+		resumed = append(resumed, roomID)
+	}
+	f, _ := eventFrame("", ResumedEvent{Rooms: resumed})
+	batch = append(batch, f)
+
+	// The batch can exceed the send buffer, so writePump takes it whole
+	select {
+	case c.replay <- batch:
+	case <-c.stopped:
+	}
+}
+
+func (h *Hub) replaySince(roomID string, seq int64) ([][]byte, error) {
+	if h.replay == nil || seq < 0 {
+		return nil, ErrReplayGap
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return h.replay.Since(ctx, roomID, seq)
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+)
+
+// roomShardCount spreads room membership over independently locked maps so
+// a fan-out to a large room never blocks joins, leaves or broadcasts in
+// other rooms.
+const roomShardCount = 64
+
+type roomShard struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*Client]bool
+}
+
+func (h *Hub) shard(roomID string) *roomShard {
+	f := fnv.New32a()
+	f.Write([]byte(roomID))
+	return &h.shards[f.Sum32()%roomShardCount]
+}
+
+// JoinRoom subscribes client to roomID. Closed clients are ignored so a
+// join racing a disconnect cannot leave a dead member behind.
+func (h *Hub) JoinRoom(client *Client, roomID string) {
+	// Holding the read lock keeps removeClient out until the join is done
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if !h.clients[client] {
+		return
+	}
+
+	s := h.shard(roomID)
+	s.mu.Lock()
+	if s.rooms[roomID] == nil {
+		s.rooms[roomID] = make(map[*Client]bool)
+	}
+	s.rooms[roomID][client] = true
+	s.mu.Unlock()
+	client.rememberRoom(roomID)
+
+	log.Printf("Client %s joined room %s", client.userID, roomID)
+}
+
+func (h *Hub) LeaveRoom(client *Client, roomID string) {
+	h.leave(client, roomID)
+	client.forgetRoom(roomID)
+
+	log.Printf("Client %s left room %s", client.userID, roomID)
+}
+
+func (h *Hub) leave(client *Client, roomID string) {
+	s := h.shard(roomID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if room := s.rooms[roomID]; room != nil {
+		delete(room, client)
+		if len(room) == 0 {
+			delete(s.rooms, roomID)
+		}
+	}
+}
+
+// leaveAll removes client from every room it joined.
+func (h *Hub) leaveAll(client *Client) {
+	for _, roomID := range client.joinedRooms() {
+		h.leave(client, roomID)
+		client.forgetRoom(roomID)
+	}
+}
+
+func (h *Hub) inRoom(client *Client, roomID string) bool {
+	s := h.shard(roomID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rooms[roomID][client]
+}
+
+// deliver queues one frame for every local client in the room. They share
+// it, so binary clients transcode it only once. Queuing never blocks;
+// slow clients are handled by Client.enqueue.
+func (h *Hub) deliver(roomID string, f *frame) {
+	s := h.shard(roomID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for client := range s.rooms[roomID] {
+		client.enqueue(f)
+	}
+}
+
+// roomCount counts the rooms with a local member.
+func (h *Hub) roomCount() int {
+	n := 0
+	for i := range h.shards {
+		s := &h.shards[i]
+		s.mu.RLock()
+		n += len(s.rooms)
+		s.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,226 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseHeartbeat is shorter than the WebSocket ping period because proxies
+// tend to cut idle HTTP responses sooner than idle sockets.
+const sseHeartbeat = 15 * time.Second
+
+// sseTransport writes frames as Server-Sent Events. Each sequenced event
+// carries the client's cursor, every room's last sequence number, as its
+// id, so a reconnect with Last-Event-ID resumes all rooms at once.
+//
+// Live events of rooms still being resumed are held back until the replay
+// is written: sent first, they would move the cursor past the replayed
+// events, which would then be dropped as already sent.
+type sseTransport struct {
+	w        http.ResponseWriter
+	rc       *http.ResponseController
+	cursor   map[string]int64
+	resuming map[string]bool
+	held     []*frame
+}
+
+func (t *sseTransport) writeFrame(f *frame) error {
+	if f.seq > 0 && t.resuming[f.room] {
+		t.held = append(t.held, f)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if f.seq > 0 {
+		if f.seq <= t.cursor[f.room] {
+			// Already sent: a held live event the replay also covered
+			return nil
+		}
+		t.cursor[f.room] = f.seq
+		buf.WriteString("id: ")
+		buf.WriteString(EncodeCursor(t.cursor))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("data: ")
+	buf.Write(f.data)
+	buf.WriteString("\n\n")
+	return t.write(buf.Bytes())
+}
+
+// writeBatch writes a resume batch, then the live events held back
+// meanwhile.
+func (t *sseTransport) writeBatch(batch []*frame) error {
+	t.resuming = nil
+	for _, f := range batch {
+		if err := t.writeFrame(f); err != nil {
+			return err
+		}
+	}
+
+	held := t.held
+	t.held = nil
+	for _, f := range held {
+		if err := t.writeFrame(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sseTransport) heartbeat() error {
+	return t.write([]byte(": ping\n\n"))
+}
+
+func (t *sseTransport) heartbeatInterval() time.Duration {
+	return sseHeartbeat
+}
+
+func (t *sseTransport) writeClose(code int, reason string) {
+	if code == 0 {
+		return
+	}
+	if f, err := eventFrame("", ClosedEvent{Code: code, Reason: reason}); err == nil {
+		t.writeFrame(f)
+	}
+}
+
+func (t *sseTransport) finish() {}
+
+func (t *sseTransport) write(data []byte) error {
+	// The server's WriteTimeout would cut the stream; bound each write
+	// instead
+	t.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if _, err := t.w.Write(data); err != nil {
+		return err
+	}
+	return t.rc.Flush()
+}
+
+// EncodeCursor formats room sequence numbers as an SSE event id,
+// "room:seq,room:seq", sorted by room.
+func EncodeCursor(cursor map[string]int64) string {
+	parts := make([]string, 0, len(cursor))
+	for roomID, seq := range cursor {
+		parts = append(parts, roomID+":"+strconv.FormatInt(seq, 10))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// ParseCursor reads a Last-Event-ID written by EncodeCursor, skipping
+// malformed entries.
+func ParseCursor(id string) map[string]int64 {
+	cursor := make(map[string]int64)
+	for _, part := range strings.Split(id, ",") {
+		i := strings.LastIndexByte(part, ':')
+		if i <= 0 {
+			continue
+		}
+		seq, err := strconv.ParseInt(part[i+1:], 10, 64)
+		if err != nil || seq < 0 {
+			continue
+		}
+		cursor[part[:i]] = seq
+	}
+	return cursor
+}
+
+// IssueTicket returns a single-use ticket for the stream's user and how
+// long it can be redeemed.
+type IssueTicket func(ctx context.Context) (string, time.Duration, error)
+
+// ServeSSE streams the hub's events for userID until ctx is done. The
+// client is subscribed to its personal rooms plus roomIDs it may join;
+// rooms in cursor are resumed from the given sequence numbers. SSE is
+// receive-only: clients act through the REST API. Unless issue is nil,
+// the stream always carries an unexpired ticket for the next reconnect.
+func ServeSSE(ctx context.Context, hub *Hub, w http.ResponseWriter, userID string, roomIDs []string, cursor map[string]int64, issue IssueTicket) {
+	t := &sseTransport{w: w, rc: http.NewResponseController(w), cursor: make(map[string]int64), resuming: make(map[string]bool)}
+	client := newClient(hub, t, userID, DefaultProtocol)
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+
+	if !hub.register(client) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer hub.unregister(client)
+
+	w.WriteHeader(http.StatusOK)
+	t.write([]byte("retry: 3000\n\n"))
+	client.start()
+
+	resume := make(map[string]int64)
+	for _, roomID := range roomIDs {
+		if seq, ok := cursor[roomID]; ok {
+			resume[roomID] = seq
+			continue
+		}
+		if hub.authorize(userID, roomID) {
+			hub.JoinRoom(client, roomID)
+		} else {
+			hub.sendToClient(client, roomID, ErrorEvent{Room: roomID, Code: "forbidden"})
+		}
+	}
+	// Personal rooms were joined by start; they only need replaying
+	for _, roomID := range client.joinedRooms() {
+		if seq, ok := cursor[roomID]; ok {
+			resume[roomID] = seq
+		}
+	}
+	if len(resume) > 0 {
+		// Until new events arrive, ids keep carrying the resumed positions
+		for roomID, seq := range resume {
+			t.cursor[roomID] = seq
+			t.resuming[roomID] = true
+		}
+		go client.resumeRooms(resume)
+	}
+	if issue != nil {
+		go sendTickets(client, issue)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.close(0, "")
+		case <-client.stopped:
+		}
+	}()
+	client.writePump()
+}
+
+// sendTickets sends client a new ticket whenever half of the previous
+// one's lifetime has passed, until the stream ends. The ticket the stream
+// was opened with is spent, so this is how clients reconnect without
+// asking the REST API again.
+func sendTickets(client *Client, issue IssueTicket) {
+	for {
+		wait := 5 * time.Second
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		ticket, ttl, err := issue(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to issue event stream ticket for %s: %v", client.userID, err)
+		} else {
+			client.hub.sendToClient(client, "", TicketEvent{Ticket: ticket, ExpiresIn: int(ttl.Seconds())})
+			wait = ttl / 2
+		}
+
+		select {
+		case <-client.stopped:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
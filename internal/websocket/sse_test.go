@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		id   string
+		want map[string]int64
+	}{
+		{"", map[string]int64{}},
+		{"topic_1:42", map[string]int64{"topic_1": 42}},
+		{"topic_1:42,user_abc:7", map[string]int64{"topic_1": 42, "user_abc": 7}},
+		{"topic_1:0", map[string]int64{"topic_1": 0}},
+		// Room names may contain colons; the sequence number is last
+		{"a:b:3", map[string]int64{"a:b": 3}},
+		{"topic_1:42,,group_2:x,:5,dm_1:-1,conversation_9", map[string]int64{"topic_1": 42}},
+		{"topic_1:99999999999999999999", map[string]int64{}},
+	}
+	for _, tt := range tests {
+		if got := ParseCursor(tt.id); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCursor(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeCursor(t *testing.T) {
+	tests := []struct {
+		cursor map[string]int64
+		want   string
+	}{
+		{map[string]int64{}, ""},
+		{map[string]int64{"topic_1": 42}, "topic_1:42"},
+		{map[string]int64{"user_abc": 7, "topic_1": 42, "group_2": 1}, "group_2:1,topic_1:42,user_abc:7"},
+	}
+	for _, tt := range tests {
+		got := EncodeCursor(tt.cursor)
+		if got != tt.want {
+			t.Errorf("EncodeCursor(%v) = %q, want %q", tt.cursor, got, tt.want)
+		}
+		if back := ParseCursor(got); !reflect.DeepEqual(back, tt.cursor) {
+			t.Errorf("ParseCursor(EncodeCursor(%v)) = %v", tt.cursor, back)
+		}
+	}
+}
+
+// TestSSELiveFrameDuringReplay sends a live event of a resuming room
+// before the replay is written: it must not hide the replayed events, nor
+// arrive twice when the replay covers it.
+func TestSSELiveFrameDuringReplay(t *testing.T) {
+	rec := httptest.NewRecorder()
+	out := &sseTransport{
+		w:        rec,
+		rc:       http.NewResponseController(rec),
+		cursor:   map[string]int64{"topic_1": 5},
+		resuming: map[string]bool{"topic_1": true},
+	}
+	event := func(seq int64) *frame {
+		return &frame{data: []byte(`{"seq":` + strconv.FormatInt(seq, 10) + `}`), room: "topic_1", seq: seq}
+	}
+
+	for _, seq := range []int64{8, 9} {
+		if err := out.writeFrame(event(seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resumed, _ := eventFrame("", ResumedEvent{Rooms: []string{"topic_1"}})
+	if err := writeBatch(out, []*frame{event(6), event(7), event(8), resumed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.writeFrame(event(10)); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if id, ok := strings.CutPrefix(line, "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	want := []string{"topic_1:6", "topic_1:7", "topic_1:8", "topic_1:9", "topic_1:10"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("event ids = %v, want %v", ids, want)
+	}
+}
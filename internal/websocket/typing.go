@@ -0,0 +1,246 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"psycho-platform/internal/rooms"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// typingTTL is how long a typing signal lasts; clients repeat it while
+	// the user keeps typing.
+	typingTTL = 6 * time.Second
+	// typingThrottle is the minimum interval between signals from one user
+	// in one room that are acted upon; more frequent ones are ignored.
+	typingThrottle = 2 * time.Second
+	typingSweep    = time.Second
+)
+
+// TypingRoom reports whether roomID is a room users can type in: a topic,
+// a group or a direct conversation.
+func TypingRoom(roomID string) bool {
+	prefix, _, ok := rooms.Parse(roomID)
+	return ok && (prefix == rooms.TopicPrefix || prefix == rooms.GroupPrefix || prefix == rooms.ConversationPrefix)
+}
+
+// Typist is a user typing in a room.
+type Typist struct {
+	RoomID string
+	UserID string
+}
+
+// TypingStore tracks who is typing where until their signal expires.
+type TypingStore interface {
+	// Start marks userID as typing in roomID until the deadline. started is
+	// true if they were not typing there before.
+	Start(ctx context.Context, roomID, userID string, until time.Time) (started bool, err error)
+	// Stop clears userID's entry and reports whether there was one.
+	Stop(ctx context.Context, roomID, userID string) (bool, error)
+	// Expire removes and returns the entries whose deadline is before now.
+	// Each expired entry is returned by exactly one call.
+	Expire(ctx context.Context, now time.Time) ([]Typist, error)
+}
+
+// MemoryTypingStore keeps typing state in process, for single-node
+// deployments.
+type MemoryTypingStore struct {
+	mu      sync.Mutex
+	typists map[Typist]time.Time
+}
+
+func NewMemoryTypingStore() *MemoryTypingStore {
+	return &MemoryTypingStore{typists: make(map[Typist]time.Time)}
+}
+
+func (s *MemoryTypingStore) Start(_ context.Context, roomID, userID string, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := Typist{RoomID: roomID, UserID: userID}
+	_, typing := s.typists[t]
+	s.typists[t] = until
+	return !typing, nil
+}
+
+func (s *MemoryTypingStore) Stop(_ context.Context, roomID, userID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := Typist{RoomID: roomID, UserID: userID}
+	_, typing := s.typists[t]
+	delete(s.typists, t)
+	return typing, nil
+}
+
+func (s *MemoryTypingStore) Expire(_ context.Context, now time.Time) ([]Typist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []Typist
+	for t, until := range s.typists {
+		if until.Before(now) {
+			expired = append(expired, t)
+			delete(s.typists, t)
+		}
+	}
+	return expired, nil
+}
+
+const redisTypingKey = "ws:typing"
+
+// typingStartScript sets the member's deadline and returns 1 unless it was
+// already set and still in the future.
+var typingStartScript = redis.NewScript(`
+local prev = redis.call('ZSCORE', KEYS[1], ARGV[1])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+if prev and tonumber(prev) >= tonumber(ARGV[3]) then
+	return 0
+end
+return 1
+`)
+
+// typingExpireScript pops up to ARGV[2] members whose deadline has passed,
+// so concurrent nodes never announce the same expiry twice.
+var typingExpireScript = redis.NewScript(`
+local expired = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+if #expired > 0 then
+	redis.call('ZREM', KEYS[1], unpack(expired))
+end
+return expired
+`)
+
+const redisTypingBatch = 500
+
+// RedisTypingStore keeps typing state in a sorted set of "room|user"
+// members scored by deadline in Unix milliseconds, shared by every node.
+type RedisTypingStore struct {
+	client *redis.Client
+}
+
+func NewRedisTypingStore(client *redis.Client) *RedisTypingStore {
+	return &RedisTypingStore{client: client}
+}
+
+func typingMember(roomID, userID string) string {
+	return roomID + "|" + userID
+}
+
+func (s *RedisTypingStore) Start(ctx context.Context, roomID, userID string, until time.Time) (bool, error) {
+	started, err := typingStartScript.Run(ctx, s.client, []string{redisTypingKey},
+		typingMember(roomID, userID), until.UnixMilli(), time.Now().UnixMilli()).Int()
+	return started == 1, err
+}
+
+func (s *RedisTypingStore) Stop(ctx context.Context, roomID, userID string) (bool, error) {
+	n, err := s.client.ZRem(ctx, redisTypingKey, typingMember(roomID, userID)).Result()
+	return n > 0, err
+}
+
+func (s *RedisTypingStore) Expire(ctx context.Context, now time.Time) ([]Typist, error) {
+	var expired []Typist
+	for {
+		members, err := typingExpireScript.Run(ctx, s.client, []string{redisTypingKey},
+			strconv.FormatInt(now.UnixMilli(), 10), redisTypingBatch).StringSlice()
+		if err != nil {
+			return expired, err
+		}
+		for _, m := range members {
+			if roomID, userID, ok := strings.Cut(m, "|"); ok {
+				expired = append(expired, Typist{RoomID: roomID, UserID: userID})
+			}
+		}
+		if len(members) < redisTypingBatch {
+			return expired, nil
+		}
+	}
+}
+
+// UseTypingStore replaces the in-process typing store, e.g. with one
+// shared by every node. Must be called before Run.
+func (h *Hub) UseTypingStore(s TypingStore) {
+	h.typing = s
+}
+
+// StartTyping announces that userID is typing in roomID. The caller
+// checks that the user may post there. Repeated signals only extend the
+// indicator; those arriving within typingThrottle of the last one are
+// ignored.
+func (h *Hub) StartTyping(userID, roomID string) {
+	key := typingMember(roomID, userID)
+	now := time.Now()
+
+	h.typingMu.Lock()
+	if last, ok := h.typingSeen[key]; ok && now.Sub(last) < typingThrottle {
+		h.typingMu.Unlock()
+		return
+	}
+	h.typingSeen[key] = now
+	h.typingMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	started, err := h.typing.Start(ctx, roomID, userID, now.Add(typingTTL))
+	cancel()
+	if err != nil {
+		log.Printf("Failed to record typing in %s: %v", roomID, err)
+		return
+	}
+	if started {
+		h.BroadcastToRoom(roomID, TypingEvent{UserID: userID, IsTyping: true})
+	}
+}
+
+// StopTyping clears userID's typing indicator in roomID, if any.
+func (h *Hub) StopTyping(userID, roomID string) {
+	key := typingMember(roomID, userID)
+
+	h.typingMu.Lock()
+	delete(h.typingSeen, key)
+	h.typingMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	stopped, err := h.typing.Stop(ctx, roomID, userID)
+	cancel()
+	if err != nil {
+		log.Printf("Failed to clear typing in %s: %v", roomID, err)
+		return
+	}
+	if stopped {
+		h.BroadcastToRoom(roomID, TypingEvent{UserID: userID, IsTyping: false})
+	}
+}
+
+// expireTyping announces the end of typing signals that were not renewed,
+// e.g. because the client vanished without sending a stop.
+func (h *Hub) expireTyping(ctx context.Context) {
+	ticker := time.NewTicker(typingSweep)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expired, err := h.typing.Expire(ctx, now)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to expire typing indicators: %v", err)
+			}
+			for _, t := range expired {
+				h.BroadcastToRoom(t.RoomID, TypingEvent{UserID: t.UserID, IsTyping: false})
+			}
+
+			h.typingMu.Lock()
+			for key, last := range h.typingSeen {
+				if now.Sub(last) > typingTTL {
+					delete(h.typingSeen, key)
+				}
+			}
+			h.typingMu.Unlock()
+		}
+	}
+}
@@ -9,6 +9,8 @@ const API_URL = window.location.hostname === 'localhost'
 const WS_URL = window.location.hostname === 'localhost'
   ? 'ws://localhost:8080/api/ws'
   : `wss://${window.location.host}/api/ws`;
+// Event schema version and encoding, see WEBSOCKET_PROTOCOL.md
+const WS_PROTOCOL = 'psycho.v1.json';
 
 // State management
 const state = {
@@ -26,6 +28,9 @@ const state = {
   users: [],
   typingUsers: new Set(),
   ws: null,
+  events: null, // EventSource when WebSocket is unavailable
+  // Last event sequence number seen per room, used to resume after reconnect
+  roomSeq: {},
 };
 
 const ROLE_META = {
@@ -77,19 +82,39 @@ async function apiCall(endpoint, options = {}) {
 }
 
 // WebSocket connection
-function connectWebSocket() {
+async function connectWebSocket() {
   if (!state.token) return;
 
-  state.ws = new WebSocket(`${WS_URL}?token=${state.token}`);
+  // Browsers can't send Authorization on the handshake, so trade the JWT
+  // for a short-lived single-use ticket first
+  let ticket;
+  try {
+    ({ ticket } = await apiCall('/ws/ticket', { method: 'POST' }));
+  } catch (error) {
+    console.error('WebSocket ticket error:', error);
+    setTimeout(connectWebSocket, 3000);
+    return;
+  }
+
+  state.ws = new WebSocket(WS_URL, [WS_PROTOCOL, 'ws-ticket', ticket]);
+  let opened = false;
 
   state.ws.onopen = () => {
     console.log('WebSocket connected');
-    // Set online status
-    apiCall('/status/online?online=true', { method: 'POST' });
+    opened = true;
+    wsFailures = 0;
+    // Presence follows the connection; only report idle/away explicitly
+    if (presenceStatus !== 'online') {
+      sendPresence(presenceStatus);
+    }
 
-    // Join current room
-    if (state.currentTopic) {
-      joinRoom('topic_' + state.currentTopic);
+    // Rejoin rooms and catch up on the events missed while disconnected
+    const rooms = { ...state.roomSeq };
+    if (state.currentTopic && !(('topic_' + state.currentTopic) in rooms)) {
+      rooms['topic_' + state.currentTopic] = 0;
+    }
+    if (Object.keys(rooms).length > 0) {
+      state.ws.send(JSON.stringify({ type: 'resume', payload: { rooms } }));
     }
   };
 
@@ -102,27 +127,236 @@ function connectWebSocket() {
     console.error('WebSocket error:', error);
   };
 
-  state.ws.onclose = () => {
+  state.ws.onclose = (event) => {
     console.log('WebSocket disconnected');
-    apiCall('/status/online?online=false', { method: 'POST' }).catch(() => {});
+    state.ws = null;
+    if (event.code === 4403) return; // account disabled
+    // Some networks block upgrades; fall back to Server-Sent Events
+    if (!opened && ++wsFailures >= 2) {
+      connectEventStream();
+      return;
+    }
     setTimeout(connectWebSocket, 3000);
   };
+}
 
-  // Set offline on page unload
-  window.addEventListener('beforeunload', () => {
-    apiCall('/status/online?online=false', { method: 'POST' }).catch(() => {});
-  });
+let wsFailures = 0;
+
+// Latest single-use ticket sent by the event stream, for its reconnect
+let streamTicket = null;
+
+// connectEventStream receives the same events over SSE. Reconnecting is
+// done here rather than by EventSource, whose URL holds a spent ticket, so
+// the new stream also covers rooms opened since, passing the per-room
+// sequence numbers as last_event_id.
+async function connectEventStream() {
+  if (!state.token) return;
+
+  let ticket;
+  if (streamTicket && streamTicket.expires > Date.now()) {
+    ticket = streamTicket.ticket;
+    streamTicket = null;
+  } else {
+    try {
+      ({ ticket } = await apiCall('/ws/ticket', { method: 'POST' }));
+    } catch (error) {
+      console.error('Event stream ticket error:', error);
+      setTimeout(connectEventStream, 3000);
+      return;
+    }
+  }
+
+  const rooms = { ...state.roomSeq };
+  if (state.currentTopic && !(('topic_' + state.currentTopic) in rooms)) {
+    rooms['topic_' + state.currentTopic] = 0;
+  }
+  const params = new URLSearchParams({ ticket });
+  if (Object.keys(rooms).length > 0) {
+    params.set('rooms', Object.keys(rooms).join(','));
+    params.set('last_event_id', Object.entries(rooms).map(([room, seq]) => `${room}:${seq}`).join(','));
+  }
+
+  const source = new EventSource(`${API_URL}/events?${params}`);
+  state.events = source;
+
+  const reconnect = () => {
+    source.close();
+    if (state.events === source) {
+      state.events = null;
+      setTimeout(connectEventStream, 3000);
+    }
+  };
+
+  source.onmessage = (event) => {
+    const data = JSON.parse(event.data);
+    if (data.type === 'ticket') {
+      streamTicket = {
+        ticket: data.payload.ticket,
+        // Leave a margin for the reconnect delay
+        expires: Date.now() + (data.payload.expires_in - 5) * 1000,
+      };
+      return;
+    }
+    if (data.type === 'closed') {
+      if (data.payload.code === 4403) { // account disabled
+        source.close();
+        state.events = null;
+      } else {
+        reconnect();
+      }
+      return;
+    }
+    handleWebSocketMessage(data);
+  };
+  source.onerror = reconnect;
+}
+
+function closeEventStream() {
+  if (state.events) {
+    state.events.close();
+    state.events = null;
+  }
+}
+
+// Idle after 5 minutes without input or with the tab hidden, away after 30
+const IDLE_AFTER = 5 * 60 * 1000;
+const AWAY_AFTER = 30 * 60 * 1000;
+let presenceStatus = 'online';
+let lastActivity = Date.now();
+
+function sendPresence(status) {
+  presenceStatus = status;
+  if (state.ws && state.ws.readyState === WebSocket.OPEN) {
+    state.ws.send(JSON.stringify({ type: 'presence', payload: { status } }));
+  }
+}
+
+function markActive() {
+  lastActivity = Date.now();
+  if (presenceStatus !== 'online' && !document.hidden) {
+    sendPresence('online');
+  }
 }
 
+['mousemove', 'keydown', 'touchstart', 'scroll'].forEach((name) => {
+  window.addEventListener(name, markActive, { passive: true });
+});
+document.addEventListener('visibilitychange', () => {
+  if (document.hidden) {
+    sendPresence('idle');
+  } else {
+    markActive();
+  }
+});
+setInterval(() => {
+  const quiet = Date.now() - lastActivity;
+  if (quiet >= AWAY_AFTER && presenceStatus !== 'away') {
+    sendPresence('away');
+  } else if (quiet >= IDLE_AFTER && presenceStatus === 'online') {
+    sendPresence('idle');
+  }
+}, 30 * 1000);
+
 function handleWebSocketMessage(data) {
-  if (data.type === 'new_message') {
+  if (data.room && data.seq) {
+    // Replayed and live events can overlap right after a resume
+    const last = state.roomSeq[data.room] || 0;
+    if (data.seq <= last) return;
+    // A gap means the server dropped events while this tab fell behind
+    if (last > 0 && data.seq > last + 1) resyncRoom(data.room);
+    state.roomSeq[data.room] = data.seq;
+  }
+
+  if (data.type === 'resync') {
+    resyncRoom(data.payload.room);
+  } else if ((data.type === 'ack' || data.type === 'error') && data.payload?.client_msg_id) {
+    settleSend(data);
+  } else if (data.type === 'new_message') {
     state.messages.unshift(data.payload);
     render();
+  } else if (data.type === 'message_edited') {
+    const msg = state.messages.find((m) => m.id === data.payload.id);
+    if (msg) {
+      msg.content = data.payload.content;
+      msg.is_edited = true;
+      msg.edited_at = data.payload.edited_at;
+      render();
+    }
+  } else if (data.type === 'message_deleted') {
+    const msg = state.messages.find((m) => m.id === data.payload.id);
+    if (msg) {
+      msg.content = '[Видалено]';
+      msg.is_deleted = true;
+      render();
+    }
+  } else if (data.type === 'message_updated') {
+    const msg = state.messages.find((m) => m.id === data.payload.id);
+    if (msg) {
+      msg.link_previews = data.payload.link_previews;
+      render();
+    }
+  } else if (data.type === 'poll_updated') {
+    const msg = state.messages.find((m) => m.id === data.payload.message_id);
+    if (msg?.poll) {
+      const { total_voters, is_closed, tallies, results_for_voters } = data.payload;
+      Object.assign(msg.poll, { total_voters, is_closed });
+      // after_vote results are sent to everyone but only shown to voters
+      if (tallies && (!results_for_voters || msg.poll.my_votes?.length > 0)) {
+        msg.poll.results_visible = true;
+        msg.poll.options.forEach((o) => { o.votes = tallies[o.id] ?? 0; });
+      }
+      render();
+    }
+  } else if (data.type === 'message_restored') {
+    const msg = state.messages.find((m) => m.id === data.payload.id);
+    if (msg) {
+      msg.content = data.payload.content;
+      msg.is_deleted = false;
+      render();
+    }
+  } else if (data.type === 'reaction_added' || data.type === 'reaction_removed') {
+    const { message_id, user_id, emoji, count } = data.payload;
+    const msg = state.messages.find((m) => m.id === message_id);
+    if (msg) {
+      msg.reactions = msg.reactions || [];
+      let reaction = msg.reactions.find((r) => r.emoji === emoji);
+      if (!reaction) {
+        reaction = { emoji, count: 0, reacted_by_me: false };
+        msg.reactions.push(reaction);
+      }
+      reaction.count = count;
+      if (user_id === state.user?.id) {
+        reaction.reacted_by_me = data.type === 'reaction_added';
+      }
+      msg.reactions = msg.reactions.filter((r) => r.count > 0);
+      render();
+    }
   } else if (data.type === 'new_dm') {
     if (state.currentView === 'conversations') {
       fetchConversations();
     }
+  } else if (data.type === 'presence') {
+    const { user_id, status } = data.payload;
+    const conversation = state.conversations.find((c) => c.other_user.id === user_id);
+    if (conversation) {
+      conversation.other_user.is_online = status !== 'offline';
+      if (state.currentView === 'conversations') {
+        render();
+      }
+    }
+  } else if (data.type === 'read') {
+    // Another device read a room; mirror its badge counts
+    const { room, unread_count, mention_count } = data.payload;
+    const item = room.startsWith('topic_')
+      ? state.topics.find((t) => room === 'topic_' + t.id)
+      : state.groups.find((g) => room === 'group_' + g.id);
+    if (item) {
+      item.unread_count = unread_count;
+      item.mention_count = mention_count;
+      render();
+    }
   } else if (data.type === 'typing') {
+    if (data.room !== 'topic_' + state.currentTopic) return;
     if (data.payload.is_typing) {
       state.typingUsers.add(data.payload.user_id);
     } else {
@@ -132,12 +366,29 @@ function handleWebSocketMessage(data) {
   }
 }
 
+// resyncRoom refetches a room whose missed events are no longer retained.
+function resyncRoom(roomID) {
+  delete state.roomSeq[roomID];
+  if (roomID === 'topic_' + state.currentTopic) {
+    fetchMessages(state.currentTopic).catch(() => {});
+  } else if (roomID.startsWith('dm_') && state.currentView === 'conversations') {
+    fetchConversations().catch(() => {});
+  }
+}
+
 function joinRoom(roomID) {
+  if (!(roomID in state.roomSeq)) {
+    state.roomSeq[roomID] = 0;
+  }
   if (state.ws && state.ws.readyState === WebSocket.OPEN) {
     state.ws.send(JSON.stringify({
       type: 'join_room',
       room: roomID,
     }));
+  } else if (state.events) {
+    // SSE rooms are fixed per stream; reopen it with the new room
+    closeEventStream();
+    connectEventStream();
   }
 }
 
@@ -153,17 +404,43 @@ function updateTypingIndicator() {
   }
 }
 
+// Typing signals expire on the server after a few seconds, so they are
+// repeated while the user types and an explicit stop is only a courtesy.
+const TYPING_REPEAT = 2000;
+const TYPING_IDLE = 3000;
 let typingTimer;
+let typingRoom = null;
+let typingSentAt = 0;
+
+function sendTyping(roomID, isTyping) {
+  if (state.ws && state.ws.readyState === WebSocket.OPEN) {
+    state.ws.send(JSON.stringify({ type: 'typing', room: roomID, payload: { is_typing: isTyping } }));
+    return;
+  }
+  const action = isTyping ? 'start' : 'stop';
+  apiCall(`/messages/typing/${action}?room=${roomID}`, { method: 'POST' }).catch(() => {});
+}
+
 function handleTyping(roomID) {
   clearTimeout(typingTimer);
 
-  // Send typing start
-  apiCall(`/messages/typing/start?room=${roomID}`, { method: 'POST' }).catch(() => {});
+  const now = Date.now();
+  if (roomID !== typingRoom || now - typingSentAt >= TYPING_REPEAT) {
+    sendTyping(roomID, true);
+    typingRoom = roomID;
+    typingSentAt = now;
+  }
+
+  typingTimer = setTimeout(stopTyping, TYPING_IDLE);
+}
 
-  // Auto-stop after 3 seconds
-  typingTimer = setTimeout(() => {
-    apiCall(`/messages/typing/stop?room=${roomID}`, { method: 'POST' }).catch(() => {});
-  }, 3000);
+function stopTyping() {
+  clearTimeout(typingTimer);
+  if (typingRoom) {
+    sendTyping(typingRoom, false);
+  }
+  typingRoom = null;
+  typingSentAt = 0;
 }
 
 // Auth functions
@@ -196,9 +473,14 @@ async function login(username, password) {
 }
 
 function logout() {
-  apiCall('/status/online?online=false', { method: 'POST' }).catch(() => {});
+  if (state.ws) {
+    state.ws.onclose = null;
+    state.ws.close();
+  }
+  closeEventStream();
   state.token = null;
   state.user = null;
+  state.roomSeq = {};
   state.currentView = 'login';
   localStorage.removeItem('token');
   if (state.ws) state.ws.close();
@@ -245,20 +527,57 @@ async function blockUser(userId) {
 }
 
 // Message actions
-async function sendMessage(content, topicId, groupId, quotedMessageId) {
-  await apiCall('/messages', {
-    method: 'POST',
-    body: JSON.stringify({
-      content,
-      topic_id: topicId || null,
-      group_id: groupId || null,
-      quoted_message_id: quotedMessageId || null,
-    }),
+// Sends over the WebSocket are acknowledged by client_msg_id. Retries reuse
+// the id, so the server stores each message once; without an open socket
+// the same payload goes over REST.
+const pendingSends = new Map();
+const SEND_TIMEOUT = 5000;
+const SEND_ATTEMPTS = 3;
+
+function sendFrame(type, payload, restEndpoint) {
+  const body = { ...payload, client_msg_id: crypto.randomUUID() };
+
+  return new Promise((resolve, reject) => {
+    let attempt = 0;
+    const trySend = () => {
+      attempt += 1;
+      if (!state.ws || state.ws.readyState !== WebSocket.OPEN || attempt > SEND_ATTEMPTS) {
+        pendingSends.delete(body.client_msg_id);
+        apiCall(restEndpoint, { method: 'POST', body: JSON.stringify(body) }).then(resolve, reject);
+        return;
+      }
+      const timer = setTimeout(trySend, SEND_TIMEOUT);
+      pendingSends.set(body.client_msg_id, { resolve, reject, timer });
+      state.ws.send(JSON.stringify({ type, payload: body }));
+    };
+    trySend();
   });
+}
+
+function settleSend(data) {
+  const pending = pendingSends.get(data.payload.client_msg_id);
+  if (!pending) return;
+  clearTimeout(pending.timer);
+  pendingSends.delete(data.payload.client_msg_id);
+  if (data.type === 'ack') {
+    pending.resolve(data.payload.data);
+  } else {
+    pending.reject(new Error(data.payload.error));
+  }
+}
 
-  // Stop typing
-  const roomID = topicId ? 'topic_' + topicId : 'group_' + groupId;
-  apiCall(`/messages/typing/stop?room=${roomID}`, { method: 'POST' }).catch(() => {});
+async function sendMessage(content, topicId, groupId, quotedMessageId) {
+  await sendFrame('send_message', {
+    content,
+    topic_id: topicId || null,
+    group_id: groupId || null,
+    quoted_message_id: quotedMessageId || null,
+  }, '/messages');
+
+  // Posting clears the indicator on the server
+  clearTimeout(typingTimer);
+  typingRoom = null;
+  typingSentAt = 0;
 }
 
 async function editMessage(messageId, newContent) {
@@ -279,10 +598,7 @@ async function deleteMessage(messageId) {
 
 // DM actions
 async function sendDirectMessage(recipientId, content) {
-  await apiCall('/conversations/send', {
-    method: 'POST',
-    body: JSON.stringify({ recipient_id: recipientId, content }),
-  });
+  await sendFrame('send_dm', { recipient_id: recipientId, content }, '/conversations/send');
 
   await fetchConversations();
 }
@@ -727,12 +1043,17 @@ window.toggleUserStatus = toggleUserStatus;
 window.updateUserRole = updateUserRole;
 window.voteTopic = (id, type) => apiCall(`/topics/${id}/vote?type=${type}`, { method: 'POST' }).then(fetchTopics);
 window.openTopic = (id) => {
+  state.typingUsers.clear();
   state.currentTopic = id;
   state.currentView = 'topic-detail';
-  fetchMessages(id);
+  fetchMessages(id)
+    .then(() => apiCall(`/topics/${id}/read`, { method: 'POST' }))
+    .catch(() => {});
   joinRoom('topic_' + id);
 };
 window.backToTopics = () => {
+  stopTyping();
+  state.typingUsers.clear();
   state.currentView = 'topics';
   state.currentTopic = null;
   render();
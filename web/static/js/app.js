@@ -6,6 +6,8 @@ const API_URL = window.location.hostname === 'localhost'
 const WS_URL = window.location.hostname === 'localhost'
   ? 'ws://localhost:8080/api/ws'
   : `wss://${window.location.host}/api/ws`;
+// Event schema version and encoding, see WEBSOCKET_PROTOCOL.md
+const WS_PROTOCOL = 'psycho.v1.json';
 
 // State management
 const state = {
@@ -41,10 +43,21 @@ async function apiCall(endpoint, options = {}) {
 }
 
 // WebSocket connection
-function connectWebSocket() {
+async function connectWebSocket() {
   if (!state.token) return;
 
-  state.ws = new WebSocket(`${WS_URL}?token=${state.token}`);
+  // Browsers can't send Authorization on the handshake, so trade the JWT
+  // for a short-lived single-use ticket first
+  let ticket;
+  try {
+    ({ ticket } = await apiCall('/ws/ticket', { method: 'POST' }));
+  } catch (error) {
+    console.error('WebSocket ticket error:', error);
+    setTimeout(connectWebSocket, 3000);
+    return;
+  }
+
+  state.ws = new WebSocket(WS_URL, [WS_PROTOCOL, 'ws-ticket', ticket]);
 
   state.ws.onopen = () => {
     console.log('WebSocket connected');
@@ -59,8 +72,9 @@ function connectWebSocket() {
     console.error('WebSocket error:', error);
   };
 
-  state.ws.onclose = () => {
+  state.ws.onclose = (event) => {
     console.log('WebSocket disconnected');
+    if (event.code === 4403) return; // account disabled
     setTimeout(connectWebSocket, 3000);
   };
 }